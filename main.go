@@ -1,14 +1,35 @@
 package main
 
 import (
+	"os"
+
 	"github.com/mattmattox/supportability-collector/modules/cli"
 	"github.com/mattmattox/supportability-collector/modules/health"
 	"github.com/mattmattox/supportability-collector/modules/run"
 )
 
 func main() {
+	switch cli.Command() {
+	case "analyze":
+		run.Analyze(cli.AnalyzeSettings(os.Args[2:]))
+		return
+	case "diff":
+		run.Diff(cli.DiffSettings(os.Args[2:]))
+		return
+	case "decrypt":
+		run.Decrypt(cli.DecryptSettings(os.Args[2:]))
+		return
+	case "verify":
+		run.Verify(cli.VerifySettings(os.Args[2:]))
+		return
+	}
+
 	settings := cli.Settings()
 	health.PrintVersion()
+	if cli.Command() == "serve" {
+		run.Serve(settings)
+		return
+	}
 	health.StartHealthServer(settings)
 	run.Run(settings)
 }
@@ -0,0 +1,186 @@
+package analyze
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/mattmattox/supportability-collector/modules/logging"
+)
+
+var log = logging.SetupLogging()
+
+// severityOrder sorts findings with the most serious first.
+var severityOrder = map[string]int{"error": 0, "warning": 1, "info": 2}
+
+type Finding struct {
+	RuleID    string `json:"ruleId"`
+	Severity  string `json:"severity"`
+	Title     string `json:"title"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	File      string `json:"file"`
+	Message   string `json:"message"`
+}
+
+type Report struct {
+	GeneratedAt  time.Time      `json:"generatedAt"`
+	Rules        int            `json:"rules"`
+	Objects      int            `json:"objects"`
+	Counts       map[string]int `json:"counts"`
+	Findings     []Finding      `json:"findings"`
+	Deprecations []Deprecation  `json:"deprecations"`
+}
+
+// Options select the rules and support matrix an analysis runs with.
+type Options struct {
+	RulesDir          string
+	SupportMatrixFile string
+	UpgradeTarget     string
+}
+
+// Run evaluates every rule against every object of the bundle.
+func Run(bundle *Bundle, rules []Rule) *Report {
+	report := &Report{
+		GeneratedAt:  time.Now().UTC(),
+		Rules:        len(rules),
+		Objects:      len(bundle.Objects),
+		Counts:       map[string]int{},
+		Findings:     []Finding{},
+		Deprecations: []Deprecation{},
+	}
+	for _, object := range bundle.Objects {
+		for i := range rules {
+			rule := &rules[i]
+			if !rule.Applies(object) {
+				continue
+			}
+			matched, value := rule.Evaluate(object)
+			if !matched {
+				continue
+			}
+			report.Findings = append(report.Findings, Finding{
+				RuleID:    rule.ID,
+				Severity:  rule.Severity,
+				Title:     rule.Title,
+				Kind:      object.Kind(),
+				Namespace: object.Namespace(),
+				Name:      object.Name(),
+				File:      object.Path,
+				Message:   rule.Render(object, value),
+			})
+			report.Counts[rule.Severity]++
+		}
+	}
+	report.sort()
+	return report
+}
+
+// Add appends findings from checks other than the rules.
+func (report *Report) Add(findings ...Finding) {
+	for _, finding := range findings {
+		report.Findings = append(report.Findings, finding)
+		report.Counts[finding.Severity]++
+	}
+	report.sort()
+}
+
+func (report *Report) sort() {
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		a, b := report.Findings[i], report.Findings[j]
+		if severityOrder[a.Severity] != severityOrder[b.Severity] {
+			return severityOrder[a.Severity] < severityOrder[b.Severity]
+		}
+		if a.RuleID != b.RuleID {
+			return a.RuleID < b.RuleID
+		}
+		return a.File < b.File
+	})
+}
+
+// Analyze runs the rules and the support matrix checks over a bundle.
+func Analyze(bundle *Bundle, options Options) (*Report, error) {
+	rules, err := LoadRules(options.RulesDir)
+	if err != nil {
+		return nil, err
+	}
+	matrix, err := LoadSupportMatrix(options.SupportMatrixFile)
+	if err != nil {
+		return nil, err
+	}
+	apis, err := BuiltinDeprecatedAPIs()
+	if err != nil {
+		return nil, err
+	}
+	report := Run(bundle, rules)
+	report.Add(CheckCompatibility(bundle, matrix, options.UpgradeTarget)...)
+	deprecations, findings := CheckDeprecatedAPIs(bundle, apis)
+	report.Deprecations = deprecations
+	report.Add(findings...)
+	return report, nil
+}
+
+// WriteReport writes analysis.json, analysis-summary.txt and
+// deprecated-apis.txt into dir.
+func WriteReport(dir string, report *Report) error {
+	return WriteReportFiles(func(name string) (io.WriteCloser, error) {
+		return os.Create(filepath.Join(dir, name))
+	}, report)
+}
+
+// WriteReportFiles writes the report files with create, which opens a file
+// by its name relative to the bundle root.
+func WriteReportFiles(create func(name string) (io.WriteCloser, error), report *Report) error {
+	files := []struct {
+		name  string
+		write func(w io.Writer) error
+	}{
+		{"analysis.json", func(w io.Writer) error { return WriteJSON(w, report) }},
+		{"analysis-summary.txt", func(w io.Writer) error { return WriteSummary(w, report) }},
+		{"deprecated-apis.txt", func(w io.Writer) error { return WriteDeprecations(w, report.Deprecations) }},
+	}
+	for _, file := range files {
+		f, err := create(file.name)
+		if err != nil {
+			return err
+		}
+		err = file.write(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func WriteJSON(w io.Writer, report *Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// WriteSummary writes the findings grouped by severity for humans.
+func WriteSummary(w io.Writer, report *Report) error {
+	fmt.Fprintf(w, "Analyzed %d object(s) with %d rule(s): %d error(s), %d warning(s), %d info\n",
+		report.Objects, report.Rules, report.Counts["error"], report.Counts["warning"], report.Counts["info"])
+	if len(report.Findings) == 0 {
+		_, err := fmt.Fprintln(w, "\nNo problems found.")
+		return err
+	}
+	severity := ""
+	for _, finding := range report.Findings {
+		if finding.Severity != severity {
+			severity = finding.Severity
+			fmt.Fprintf(w, "\n== %s ==\n", severity)
+		}
+		fmt.Fprintf(w, "[%s] %s\n    %s\n", finding.RuleID, finding.Message, finding.File)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package analyze
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Magic numbers of the archive formats bundles are written in.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zipMagic  = []byte("PK\x03\x04")
+)
+
+// ReadBundle streams a bundle into a new Bundle without extracting it to
+// disk. visit, when not nil, is called with every regular file small
+// enough to be loaded, otherwise only the files the analyzer loads are
+// read.
+func ReadBundle(path string, visit func(name string, data []byte)) (*Bundle, error) {
+	bundle := NewBundle()
+	err := WalkBundle(path, func(name string, size int64, r io.Reader) error {
+		if size > MaxFileSize || (visit == nil && (!Loads(name) || bundle.Full())) {
+			return nil
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		bundle.AddFile(name, data)
+		if visit != nil {
+			visit(name, data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// WalkBundle calls visit with every regular file of a bundle, which is a
+// tar.gz, tar.zst or zip archive, told apart by their content, or a
+// directory. Names are relative to the bundle root.
+func WalkBundle(path string, visit func(name string, size int64, r io.Reader) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return walkDir(path, visit)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	buffered := bufio.NewReader(f)
+	magic, _ := buffered.Peek(len(zipMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return walkTar(gz, visit)
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		return walkTar(zr, visit)
+	case bytes.HasPrefix(magic, zipMagic):
+		return walkZip(f, info.Size(), visit)
+	}
+	return errors.New("bundle is not a tar.gz, tar.zst or zip archive, decrypt encrypted bundles first")
+}
+
+func walkTar(r io.Reader, visit func(name string, size int64, r io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		err = visit(BundlePath(header.Name), header.Size, tr)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func walkZip(r io.ReaderAt, size int64, visit func(name string, size int64, r io.Reader) error) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, file := range zr.File {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		f, err := file.Open()
+		if err != nil {
+			return err
+		}
+		err = visit(BundlePath(file.Name), int64(file.UncompressedSize64), f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkDir(dir string, visit func(name string, size int64, r io.Reader) error) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return visit(filepath.ToSlash(rel), info.Size(), f)
+	})
+}
+
+// BundlePath returns an archive entry name relative to the bundle root.
+// Archives are rooted under a supportbundle-* directory, older ones carry
+// the absolute temporary supportability-* directory the bundle was
+// collected in, so everything up to either is dropped.
+func BundlePath(name string) string {
+	name = strings.TrimPrefix(name, "./")
+	parts := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	for i, part := range parts[:len(parts)-1] {
+		if strings.HasPrefix(part, "supportability-") || strings.HasPrefix(part, "supportbundle-") {
+			return strings.Join(parts[i+1:], "/")
+		}
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,224 @@
+package analyze
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// MaxFileSize is the largest file the loader keeps or parses. Logs and
+// metric dumps are bigger than this and are not needed for analysis.
+const MaxFileSize = 8 * 1024 * 1024
+
+// MaxBundleSize is the most file content the loader keeps or parses for
+// one bundle. Files added past it are left out of the analysis.
+const MaxBundleSize = 512 * 1024 * 1024
+
+// summaryFiles are the JSON and text files the analysis, the report and
+// the summaries read. Other JSON and text files are not kept.
+var summaryFiles = map[string]bool{
+	"manifest.json":                                           true,
+	"collection-errors.txt":                                   true,
+	"rancher-data/rancher-data.json":                          true,
+	"certificates/certificates.json":                          true,
+	"deprecated-apis/helm-releases.json":                      true,
+	"deprecated-apis/server-version.json":                     true,
+	"deprecated-apis/apiserver_requested_deprecated_apis.txt": true,
+}
+
+// Object is one Kubernetes object read from the bundle.
+type Object struct {
+	Path string
+	Data map[string]interface{}
+}
+
+func (o Object) Kind() string {
+	kind, _ := o.Data["kind"].(string)
+	return kind
+}
+
+func (o Object) APIVersion() string {
+	apiVersion, _ := o.Data["apiVersion"].(string)
+	return apiVersion
+}
+
+func (o Object) Name() string {
+	name, _ := lookup(o.Data, "metadata", "name").(string)
+	return name
+}
+
+func (o Object) Namespace() string {
+	namespace, _ := lookup(o.Data, "metadata", "namespace").(string)
+	return namespace
+}
+
+func (o Object) Labels() map[string]interface{} {
+	labels, _ := lookup(o.Data, "metadata", "labels").(map[string]interface{})
+	return labels
+}
+
+// Field returns the first value found at a rule path as text. Strings are
+// returned as they are and anything else as JSON.
+func (o Object) Field(path string) string {
+	values := Values(o.Data, path)
+	if len(values) == 0 {
+		return ""
+	}
+	if s, ok := values[0].(string); ok {
+		return s
+	}
+	raw, _ := json.Marshal(values[0])
+	return string(raw)
+}
+
+// Bundle is the parsed content of a support bundle: every Kubernetes object
+// found in its YAML files plus the raw content of the JSON and text files
+// summaries are built from.
+type Bundle struct {
+	Objects []Object
+	Files   map[string][]byte
+
+	// loaded is the size of the files added so far.
+	loaded int64
+}
+
+// kindsByDirectory maps the directory names used by the collectors to the
+// apiVersion and kind of the objects inside, for files that were written
+// without a kind.
+var kindsByDirectory = map[string][2]string{
+	"pods":                       {"v1", "Pod"},
+	"services":                   {"v1", "Service"},
+	"endpoints":                  {"v1", "Endpoints"},
+	"nodes":                      {"v1", "Node"},
+	"persistentvolumes":          {"v1", "PersistentVolume"},
+	"persistentvolumeclaims":     {"v1", "PersistentVolumeClaim"},
+	"configmaps":                 {"v1", "ConfigMap"},
+	"rancher-all-namespace-yaml": {"v1", "Namespace"},
+	"deployments":                {"apps/v1", "Deployment"},
+	"daemonsets":                 {"apps/v1", "DaemonSet"},
+	"statefulsets":               {"apps/v1", "StatefulSet"},
+	"replicasets":                {"apps/v1", "ReplicaSet"},
+	"jobs":                       {"batch/v1", "Job"},
+	"cronjobs":                   {"batch/v1", "CronJob"},
+	"ingresses":                  {"networking.k8s.io/v1", "Ingress"},
+	"networkpolicies":            {"networking.k8s.io/v1", "NetworkPolicy"},
+	"ingressclasses":             {"networking.k8s.io/v1", "IngressClass"},
+	"endpointslices":             {"discovery.k8s.io/v1", "EndpointSlice"},
+	"storageclasses":             {"storage.k8s.io/v1", "StorageClass"},
+	"volumeattachments":          {"storage.k8s.io/v1", "VolumeAttachment"},
+	"csidrivers":                 {"storage.k8s.io/v1", "CSIDriver"},
+	"csinodes":                   {"storage.k8s.io/v1", "CSINode"},
+	"validating":                 {"admissionregistration.k8s.io/v1", "ValidatingWebhookConfiguration"},
+	"mutating":                   {"admissionregistration.k8s.io/v1", "MutatingWebhookConfiguration"},
+	"apiservices":                {"apiregistration.k8s.io/v1", "APIService"},
+	"clusters":                   {"management.cattle.io/v3", "Cluster"},
+	"cluster-nodes":              {"management.cattle.io/v3", "Node"},
+	"cluster-node-pools":         {"management.cattle.io/v3", "NodePool"},
+	"cluster-templates":          {"management.cattle.io/v3", "ClusterTemplate"},
+	"cluster-template-revisions": {"management.cattle.io/v3", "ClusterTemplateRevision"},
+	"features":                   {"management.cattle.io/v3", "Feature"},
+	"cluster-node-templates":     {"management.cattle.io/v3", "NodeTemplate"},
+}
+
+func NewBundle() *Bundle {
+	return &Bundle{Files: map[string][]byte{}}
+}
+
+// AddFile adds one bundle file. YAML files are parsed into objects, lists
+// are flattened, and the JSON and text files summaries read are kept as-is.
+// Once MaxBundleSize bytes were added the rest is left out with a warning.
+func (b *Bundle) AddFile(name string, data []byte) {
+	if !Loads(name) {
+		return
+	}
+	if b.loaded+int64(len(data)) > MaxBundleSize {
+		if !b.Full() {
+			log.Warningf("Analysis reached its limit of %d bytes, %s and the files after it are left out", MaxBundleSize, name)
+			b.loaded = MaxBundleSize
+		}
+		return
+	}
+	b.loaded += int64(len(data))
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		b.addYaml(name, data)
+	default:
+		b.Files[name] = data
+	}
+}
+
+// Full reports whether the bundle reached MaxBundleSize, after which
+// AddFile leaves every file out.
+func (b *Bundle) Full() bool {
+	return b.loaded >= MaxBundleSize
+}
+
+// Loads reports whether AddFile keeps anything of a file, so writers can
+// skip buffering logs and other files the analyzer ignores.
+func Loads(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return summaryFiles[name]
+}
+
+func (b *Bundle) addYaml(name string, data []byte) {
+	for _, document := range bytes.Split(data, []byte("\n---")) {
+		var object map[string]interface{}
+		if err := yaml.Unmarshal(document, &object); err != nil || object == nil {
+			continue
+		}
+		if items, ok := object["items"].([]interface{}); ok {
+			for _, item := range items {
+				if itemObject, ok := item.(map[string]interface{}); ok {
+					b.addObject(name, itemObject)
+				}
+			}
+			continue
+		}
+		b.addObject(name, object)
+	}
+}
+
+func (b *Bundle) addObject(name string, object map[string]interface{}) {
+	if _, ok := lookup(object, "metadata", "name").(string); !ok {
+		return
+	}
+	if kind, _ := object["kind"].(string); kind == "" {
+		apiVersion, kind, ok := kindFromPath(name)
+		if !ok {
+			return
+		}
+		object["apiVersion"] = apiVersion
+		object["kind"] = kind
+	}
+	b.Objects = append(b.Objects, Object{Path: name, Data: object})
+}
+
+// kindFromPath looks at the parent and grandparent directory of name, the
+// latter covering the <kind>/<namespace>/<name>.yaml layout.
+func kindFromPath(name string) (string, string, bool) {
+	parts := strings.Split(name, "/")
+	for i := len(parts) - 2; i >= 0 && i >= len(parts)-3; i-- {
+		if kind, ok := kindsByDirectory[parts[i]]; ok {
+			return kind[0], kind[1], true
+		}
+	}
+	return "", "", false
+}
+
+func lookup(data map[string]interface{}, keys ...string) interface{} {
+	var current interface{} = data
+	for _, key := range keys {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[key]
+	}
+	return current
+}
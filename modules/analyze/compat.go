@@ -0,0 +1,327 @@
+package analyze
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+//go:embed support-matrix.yaml
+var builtinSupportMatrix []byte
+
+// certManagerImage identifies the cert-manager controller among the
+// containers of the collected Deployments.
+const certManagerImage = "cert-manager-controller"
+
+type VersionRange struct {
+	Min string `json:"min"`
+	Max string `json:"max"`
+}
+
+// RancherSupport is what one Rancher release line supports.
+type RancherSupport struct {
+	Version              string       `json:"version"`
+	Kubernetes           VersionRange `json:"kubernetes"`
+	DownstreamKubernetes VersionRange `json:"downstreamKubernetes"`
+	CertManager          VersionRange `json:"certManager"`
+	UpgradeFrom          string       `json:"upgradeFrom"`
+	OperatingSystems     []string     `json:"operatingSystems"`
+	ContainerRuntimes    []string     `json:"containerRuntimes"`
+	UnsupportedProviders []string     `json:"unsupportedProviders,omitempty"`
+}
+
+type SupportMatrix struct {
+	Rancher []RancherSupport `json:"rancher"`
+}
+
+// LoadSupportMatrix reads the support matrix at path, or the built-in one
+// when path is empty.
+func LoadSupportMatrix(path string) (*SupportMatrix, error) {
+	data := builtinSupportMatrix
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var matrix SupportMatrix
+	err := yaml.Unmarshal(data, &matrix)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &matrix, nil
+}
+
+// Lookup returns the entry with the longest version prefix matching a
+// Rancher version.
+func (m *SupportMatrix) Lookup(version string) *RancherSupport {
+	parsed, ok := parseVersion(version)
+	if !ok {
+		return nil
+	}
+	var best *RancherSupport
+	bestLength := 0
+	for i := range m.Rancher {
+		entry, ok := parseVersion(m.Rancher[i].Version)
+		if !ok || len(entry) <= bestLength || compareVersions(parsed, entry) != 0 {
+			continue
+		}
+		best, bestLength = &m.Rancher[i], len(entry)
+	}
+	return best
+}
+
+// CheckCompatibility checks the collected versions against the support
+// matrix entry of the running Rancher and, when target is set, against the
+// entry of the version Rancher is to be upgraded to.
+func CheckCompatibility(bundle *Bundle, matrix *SupportMatrix, target string) []Finding {
+	var findings []Finding
+	version := rancherVersion(bundle)
+	if version == "" {
+		return findings
+	}
+	current := matrix.Lookup(version)
+	if current == nil {
+		findings = append(findings, Finding{
+			RuleID:   "compat-unknown-rancher",
+			Severity: "info",
+			Title:    "Rancher version not in the support matrix",
+			Kind:     "Rancher",
+			Name:     version,
+			File:     "rancher-data/rancher-data.json",
+			Message:  "Rancher " + version + " isn't in the support matrix, compatibility wasn't checked",
+		})
+	} else {
+		findings = append(findings, checkSupport(bundle, current, "compat", "error", "Rancher "+version)...)
+	}
+
+	if target == "" {
+		return findings
+	}
+	upgrade := matrix.Lookup(target)
+	if upgrade == nil {
+		return append(findings, Finding{
+			RuleID:   "upgrade-unknown-target",
+			Severity: "error",
+			Title:    "Upgrade target not in the support matrix",
+			Kind:     "Rancher",
+			Name:     target,
+			File:     "rancher-data/rancher-data.json",
+			Message:  "Rancher " + target + " isn't in the support matrix, upgrade readiness wasn't checked",
+		})
+	}
+	if atLeast(version, target) {
+		return append(findings, Finding{
+			RuleID:   "upgrade-not-newer",
+			Severity: "info",
+			Title:    "Upgrade target is not newer than the running version",
+			Kind:     "Rancher",
+			Name:     version,
+			File:     "rancher-data/rancher-data.json",
+			Message:  "Rancher " + version + " is already at or above " + target,
+		})
+	}
+	if upgrade.UpgradeFrom != "" && !atLeast(version, upgrade.UpgradeFrom) {
+		findings = append(findings, Finding{
+			RuleID:   "upgrade-path",
+			Severity: "error",
+			Title:    "Rancher can't be upgraded to the target directly",
+			Kind:     "Rancher",
+			Name:     version,
+			File:     "rancher-data/rancher-data.json",
+			Message:  fmt.Sprintf("Rancher %s must be upgraded to %s first, %s can only be upgraded from %s or later", version, upgrade.UpgradeFrom, target, upgrade.UpgradeFrom),
+		})
+	}
+	return append(findings, checkSupport(bundle, upgrade, "upgrade", "error", "Rancher "+target)...)
+}
+
+// checkSupport compares the bundle with one support matrix entry. prefix
+// and subject make the findings read either as a current problem or as an
+// upgrade blocker.
+func checkSupport(bundle *Bundle, support *RancherSupport, prefix string, severity string, subject string) []Finding {
+	var findings []Finding
+	add := func(id string, title string, object Object, message string) {
+		findings = append(findings, Finding{
+			RuleID:    prefix + "-" + id,
+			Severity:  severity,
+			Title:     title,
+			Kind:      object.Kind(),
+			Namespace: object.Namespace(),
+			Name:      object.Name(),
+			File:      object.Path,
+			Message:   message,
+		})
+	}
+
+	for _, object := range bundle.Objects {
+		switch {
+		case object.Kind() == "Node" && object.APIVersion() == "v1":
+			kubelet := object.Field("status.nodeInfo.kubeletVersion")
+			if kubelet != "" && !support.Kubernetes.Contains(kubelet) {
+				add("upstream-kubernetes", "Unsupported upstream Kubernetes version", object,
+					fmt.Sprintf("Node %s runs Kubernetes %s, %s supports %s for the Rancher cluster", object.Name(), kubelet, subject, support.Kubernetes))
+			}
+			findings = append(findings, checkNodeInfo(object, "status.nodeInfo", support, prefix, severity, subject)...)
+
+		case object.Kind() == "Node" && strings.HasPrefix(object.APIVersion(), "management.cattle.io/") && object.Namespace() != "local":
+			findings = append(findings, checkNodeInfo(object, "status.internalNodeStatus.nodeInfo", support, prefix, severity, subject)...)
+
+		case object.Kind() == "Cluster" && strings.HasPrefix(object.APIVersion(), "management.cattle.io/") && object.Name() != "local":
+			name := displayName(object)
+			version := object.Field("status.version.gitVersion")
+			if version != "" && !support.DownstreamKubernetes.Contains(version) {
+				add("downstream-kubernetes", "Unsupported downstream Kubernetes version", object,
+					fmt.Sprintf("Cluster %s runs Kubernetes %s, %s supports %s for downstream clusters", name, version, subject, support.DownstreamKubernetes))
+			}
+			provider := object.Field("status.provider")
+			if contains(support.UnsupportedProviders, provider) {
+				add("provider", "Unsupported cluster provider", object,
+					fmt.Sprintf("Cluster %s is provisioned with %s, which %s no longer supports", name, provider, subject))
+			}
+
+		case object.Kind() == "Deployment":
+			version := certManagerVersion(object)
+			if version != "" && !support.CertManager.Contains(version) {
+				add("cert-manager", "Unsupported cert-manager version", object,
+					fmt.Sprintf("cert-manager %s is installed, %s supports %s", version, subject, support.CertManager))
+			}
+		}
+	}
+	return findings
+}
+
+func checkNodeInfo(object Object, path string, support *RancherSupport, prefix string, severity string, subject string) []Finding {
+	var findings []Finding
+	osImage := object.Field(path + ".osImage")
+	if osImage != "" && len(support.OperatingSystems) > 0 && !hasAnyPrefix(osImage, support.OperatingSystems) {
+		findings = append(findings, Finding{
+			RuleID:   prefix + "-os",
+			Severity: "warning",
+			Title:    "Operating system not in the support matrix",
+			Kind:     object.Kind(),
+			Name:     object.Name(),
+			File:     object.Path,
+			Message:  fmt.Sprintf("Node %s runs %s, which isn't listed as supported by %s", object.Name(), osImage, subject),
+		})
+	}
+	runtime, _, _ := strings.Cut(object.Field(path+".containerRuntimeVersion"), "://")
+	if runtime != "" && len(support.ContainerRuntimes) > 0 && !contains(support.ContainerRuntimes, runtime) {
+		findings = append(findings, Finding{
+			RuleID:   prefix + "-container-runtime",
+			Severity: severity,
+			Title:    "Unsupported container runtime",
+			Kind:     object.Kind(),
+			Name:     object.Name(),
+			File:     object.Path,
+			Message:  fmt.Sprintf("Node %s uses %s, %s supports %s", object.Name(), runtime, subject, strings.Join(support.ContainerRuntimes, ", ")),
+		})
+	}
+	return findings
+}
+
+func rancherVersion(bundle *Bundle) string {
+	var rancherData struct {
+		Version string `json:"Version"`
+	}
+	if raw, ok := bundle.Files["rancher-data/rancher-data.json"]; ok {
+		json.Unmarshal(raw, &rancherData)
+	}
+	return rancherData.Version
+}
+
+// certManagerVersion returns the image tag of the cert-manager controller
+// of a Deployment, if it is one.
+func certManagerVersion(object Object) string {
+	for _, value := range Values(object.Data, "spec.template.spec.containers[*].image") {
+		image, _ := value.(string)
+		if !strings.Contains(image, certManagerImage) {
+			continue
+		}
+		if colon := strings.LastIndex(image, ":"); colon > strings.LastIndex(image, "/") {
+			return image[colon+1:]
+		}
+	}
+	return ""
+}
+
+func displayName(object Object) string {
+	if name := object.Field("spec.displayName"); name != "" && name != object.Name() {
+		return object.Name() + " (" + name + ")"
+	}
+	return object.Name()
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r VersionRange) Contains(version string) bool {
+	return (r.Min == "" || atLeast(version, r.Min)) && (r.Max == "" || atMost(version, r.Max))
+}
+
+func (r VersionRange) String() string {
+	return r.Min + " to " + r.Max
+}
+
+// atLeast and atMost compare version with bound on the components bound
+// has, so that v1.26.4+rke2r1 is at most v1.26.
+func atLeast(version string, bound string) bool {
+	v, ok1 := parseVersion(version)
+	b, ok2 := parseVersion(bound)
+	return ok1 && ok2 && compareVersions(v, b) >= 0
+}
+
+func atMost(version string, bound string) bool {
+	v, ok1 := parseVersion(version)
+	b, ok2 := parseVersion(bound)
+	return ok1 && ok2 && compareVersions(v, b) <= 0
+}
+
+// parseVersion returns the numeric components of a version such as
+// v1.26.4+rke2r1 or 2.7.5-rc1.
+func parseVersion(version string) ([]int, bool) {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if end := strings.IndexAny(version, "-+"); end >= 0 {
+		version = version[:end]
+	}
+	if version == "" {
+		return nil, false
+	}
+	var parsed []int
+	for _, part := range strings.Split(version, ".") {
+		number, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, false
+		}
+		parsed = append(parsed, number)
+	}
+	return parsed, true
+}
+
+// compareVersions compares a with b on the components b has.
+func compareVersions(a []int, b []int) int {
+	for i := range b {
+		component := 0
+		if i < len(a) {
+			component = a[i]
+		}
+		if component != b[i] {
+			if component < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,362 @@
+package analyze
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+//go:embed deprecated-apis.yaml
+var builtinDeprecatedAPIs []byte
+
+// lastAppliedAnnotation holds the manifest an object was last applied
+// with, in the apiVersion the user wrote rather than the one it was read
+// back at.
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+var metricLabel = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// DeprecatedAPI is an apiVersion, or one kind of it, that is deprecated
+// or removed in a Kubernetes minor.
+type DeprecatedAPI struct {
+	APIVersion   string `json:"apiVersion"`
+	Kind         string `json:"kind,omitempty"`
+	DeprecatedIn string `json:"deprecatedIn"`
+	RemovedIn    string `json:"removedIn"`
+	Replacement  string `json:"replacement"`
+}
+
+// HelmResource and HelmRelease are written by the collector to
+// deprecated-apis/helm-releases.json.
+type HelmResource struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace,omitempty"`
+	} `json:"metadata"`
+}
+
+type HelmRelease struct {
+	Name         string         `json:"name"`
+	Namespace    string         `json:"namespace"`
+	Revision     int            `json:"revision"`
+	Chart        string         `json:"chart"`
+	ChartVersion string         `json:"chartVersion"`
+	AppVersion   string         `json:"appVersion,omitempty"`
+	Resources    []HelmResource `json:"resources"`
+}
+
+// Deprecation is one use of a deprecated API, found in a collected object,
+// its last applied configuration, a Helm release manifest or the
+// apiserver's request metric.
+type Deprecation struct {
+	Source       string `json:"source"`
+	HelmRelease  string `json:"helmRelease,omitempty"`
+	APIVersion   string `json:"apiVersion"`
+	Kind         string `json:"kind"`
+	Namespace    string `json:"namespace,omitempty"`
+	Name         string `json:"name,omitempty"`
+	File         string `json:"file"`
+	Requests     string `json:"requests,omitempty"`
+	DeprecatedIn string `json:"deprecatedIn,omitempty"`
+	RemovedIn    string `json:"removedIn"`
+	Replacement  string `json:"replacement,omitempty"`
+	Status       string `json:"status"`
+}
+
+// BuiltinDeprecatedAPIs returns the deprecated API table shipped with the
+// collector.
+func BuiltinDeprecatedAPIs() ([]DeprecatedAPI, error) {
+	var apis []DeprecatedAPI
+	err := yaml.Unmarshal(builtinDeprecatedAPIs, &apis)
+	return apis, err
+}
+
+// CheckDeprecatedAPIs finds the uses of deprecated APIs in a bundle and
+// grades them against the cluster's Kubernetes minor and the next one.
+func CheckDeprecatedAPIs(bundle *Bundle, apis []DeprecatedAPI) ([]Deprecation, []Finding) {
+	current := clusterMinor(bundle)
+	deprecations := []Deprecation{}
+	add := func(deprecation Deprecation, api *DeprecatedAPI) {
+		if api != nil {
+			deprecation.DeprecatedIn = api.DeprecatedIn
+			deprecation.Replacement = api.Replacement
+			if deprecation.RemovedIn == "" {
+				deprecation.RemovedIn = api.RemovedIn
+			}
+		}
+		deprecation.Status = deprecationStatus(deprecation, current)
+		if deprecation.Status != "" {
+			deprecations = append(deprecations, deprecation)
+		}
+	}
+
+	for _, object := range bundle.Objects {
+		if api := findDeprecatedAPI(apis, object.APIVersion(), object.Kind()); api != nil {
+			add(Deprecation{Source: "object", APIVersion: object.APIVersion(), Kind: object.Kind(), Namespace: object.Namespace(), Name: object.Name(), File: object.Path}, api)
+		}
+		lastApplied, _ := lookup(object.Data, "metadata", "annotations", lastAppliedAnnotation).(string)
+		var applied struct {
+			APIVersion string `json:"apiVersion"`
+		}
+		if lastApplied == "" || json.Unmarshal([]byte(lastApplied), &applied) != nil || applied.APIVersion == object.APIVersion() {
+			continue
+		}
+		if api := findDeprecatedAPI(apis, applied.APIVersion, object.Kind()); api != nil {
+			add(Deprecation{Source: "last-applied", APIVersion: applied.APIVersion, Kind: object.Kind(), Namespace: object.Namespace(), Name: object.Name(), File: object.Path}, api)
+		}
+	}
+
+	var releases []HelmRelease
+	if raw, ok := bundle.Files["deprecated-apis/helm-releases.json"]; ok {
+		json.Unmarshal(raw, &releases)
+	}
+	for _, release := range releases {
+		for _, resource := range release.Resources {
+			api := findDeprecatedAPI(apis, resource.APIVersion, resource.Kind)
+			if api == nil {
+				continue
+			}
+			add(Deprecation{
+				Source:      "helm",
+				HelmRelease: release.Namespace + "/" + release.Name,
+				APIVersion:  resource.APIVersion,
+				Kind:        resource.Kind,
+				Namespace:   resource.Metadata.Namespace,
+				Name:        resource.Metadata.Name,
+				File:        "deprecated-apis/helm-releases.json",
+			}, api)
+		}
+	}
+
+	for _, sample := range metricSamples(bundle.Files["deprecated-apis/apiserver_requested_deprecated_apis.txt"]) {
+		apiVersion := sample.labels["version"]
+		if sample.labels["group"] != "" {
+			apiVersion = sample.labels["group"] + "/" + apiVersion
+		}
+		// The apiserver knows best when the API it served goes away, so
+		// its removed_release wins over the table.
+		add(Deprecation{
+			Source:     "apiserver",
+			APIVersion: apiVersion,
+			Kind:       sample.labels["resource"],
+			File:       "deprecated-apis/apiserver_requested_deprecated_apis.txt",
+			Requests:   sample.value,
+			RemovedIn:  sample.labels["removed_release"],
+		}, findDeprecatedResource(apis, apiVersion, sample.labels["resource"]))
+	}
+
+	sort.SliceStable(deprecations, func(i, j int) bool {
+		a, b := deprecations[i], deprecations[j]
+		if deprecationGroup(a) != deprecationGroup(b) {
+			return deprecationGroup(a) < deprecationGroup(b)
+		}
+		return a.Kind+"/"+a.Name < b.Kind+"/"+b.Name
+	})
+
+	var findings []Finding
+	for _, deprecation := range deprecations {
+		findings = append(findings, deprecationFinding(deprecation))
+	}
+	return deprecations, findings
+}
+
+// findDeprecatedAPI returns the table entry for an apiVersion and kind.
+// An empty kind matches the first entry of the apiVersion.
+func findDeprecatedAPI(apis []DeprecatedAPI, apiVersion string, kind string) *DeprecatedAPI {
+	for i := range apis {
+		if apis[i].APIVersion == apiVersion && (apis[i].Kind == "" || kind == "" || apis[i].Kind == kind) {
+			return &apis[i]
+		}
+	}
+	return nil
+}
+
+// findDeprecatedResource is findDeprecatedAPI for the plural resource
+// names the apiserver reports.
+func findDeprecatedResource(apis []DeprecatedAPI, apiVersion string, resource string) *DeprecatedAPI {
+	for i := range apis {
+		if apis[i].APIVersion != apiVersion {
+			continue
+		}
+		kind := strings.ToLower(apis[i].Kind)
+		switch {
+		case kind == "":
+		case strings.HasSuffix(kind, "y"):
+			kind = strings.TrimSuffix(kind, "y") + "ies"
+		case strings.HasSuffix(kind, "s"):
+			kind += "es"
+		default:
+			kind += "s"
+		}
+		if kind == "" || kind == resource {
+			return &apis[i]
+		}
+	}
+	return nil
+}
+
+// deprecationStatus is removed when the API is gone in the running minor,
+// removed-next when the next minor removes it and deprecated otherwise.
+// Without a known cluster version everything is reported as deprecated.
+func deprecationStatus(deprecation Deprecation, current []int) string {
+	if current == nil {
+		return "deprecated"
+	}
+	removed, ok := parseVersion(deprecation.RemovedIn)
+	if ok && compareVersions(current, removed) >= 0 {
+		return "removed"
+	}
+	next := []int{current[0], current[1] + 1}
+	if ok && compareVersions(next, removed) >= 0 {
+		return "removed-next"
+	}
+	if deprecated, ok := parseVersion(deprecation.DeprecatedIn); ok && compareVersions(current, deprecated) < 0 {
+		return ""
+	}
+	return "deprecated"
+}
+
+func deprecationFinding(deprecation Deprecation) Finding {
+	finding := Finding{
+		Kind:      deprecation.Kind,
+		Namespace: deprecation.Namespace,
+		Name:      deprecation.Name,
+		File:      deprecation.File,
+	}
+	switch deprecation.Status {
+	case "removed":
+		finding.RuleID, finding.Severity, finding.Title = "deprecated-api-removed", "error", "API removed in the running Kubernetes version"
+	case "removed-next":
+		finding.RuleID, finding.Severity, finding.Title = "deprecated-api-removed-next", "warning", "API removed in the next Kubernetes minor"
+	default:
+		finding.RuleID, finding.Severity, finding.Title = "deprecated-api", "info", "Deprecated API in use"
+	}
+
+	subject := deprecation.Kind
+	if deprecation.Name != "" {
+		subject += " " + deprecation.Name
+		if deprecation.Namespace != "" {
+			subject = deprecation.Kind + " " + deprecation.Namespace + "/" + deprecation.Name
+		}
+	}
+	switch deprecation.Source {
+	case "helm":
+		finding.Message = fmt.Sprintf("Helm release %s deploys %s as %s", deprecation.HelmRelease, subject, deprecation.APIVersion)
+	case "last-applied":
+		finding.Message = fmt.Sprintf("%s was last applied as %s", subject, deprecation.APIVersion)
+	case "apiserver":
+		finding.Message = fmt.Sprintf("The apiserver served %s requests for %s %s", deprecation.Requests, deprecation.APIVersion, deprecation.Kind)
+	default:
+		finding.Message = fmt.Sprintf("%s uses %s", subject, deprecation.APIVersion)
+	}
+	finding.Message += ", removed in Kubernetes " + deprecation.RemovedIn
+	if deprecation.Replacement != "" {
+		finding.Message += ", use " + deprecation.Replacement
+	}
+	return finding
+}
+
+// clusterMinor returns the major and minor version of the cluster, from
+// the apiserver when it was collected and from the newest kubelet
+// otherwise.
+func clusterMinor(bundle *Bundle) []int {
+	var serverVersion struct {
+		GitVersion string `json:"gitVersion"`
+	}
+	if raw, ok := bundle.Files["deprecated-apis/server-version.json"]; ok {
+		json.Unmarshal(raw, &serverVersion)
+	}
+	if version, ok := parseVersion(serverVersion.GitVersion); ok && len(version) >= 2 {
+		return version[:2]
+	}
+	var newest []int
+	for _, object := range bundle.Objects {
+		if object.Kind() != "Node" || object.APIVersion() != "v1" {
+			continue
+		}
+		version, ok := parseVersion(object.Field("status.nodeInfo.kubeletVersion"))
+		if ok && len(version) >= 2 && (newest == nil || compareVersions(version, newest) > 0) {
+			newest = version[:2]
+		}
+	}
+	return newest
+}
+
+type metricSample struct {
+	labels map[string]string
+	value  string
+}
+
+// metricSamples parses the samples of a Prometheus text format file.
+func metricSamples(data []byte) []metricSample {
+	var samples []metricSample
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		open, close := strings.Index(line, "{"), strings.LastIndex(line, "}")
+		if open < 0 || close < open {
+			continue
+		}
+		sample := metricSample{labels: map[string]string{}, value: strings.TrimSpace(line[close+1:])}
+		if number, err := strconv.ParseFloat(sample.value, 64); err == nil {
+			sample.value = strconv.FormatFloat(number, 'f', -1, 64)
+		}
+		for _, match := range metricLabel.FindAllStringSubmatch(line[open:close], -1) {
+			sample.labels[match[1]] = match[2]
+		}
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+// deprecationGroup is the heading a deprecation is listed under.
+func deprecationGroup(deprecation Deprecation) string {
+	switch {
+	case deprecation.HelmRelease != "":
+		return "Helm release " + deprecation.HelmRelease
+	case deprecation.Source == "apiserver":
+		return "Apiserver requests"
+	case deprecation.Namespace == "":
+		return "Cluster scoped"
+	}
+	return "Namespace " + deprecation.Namespace
+}
+
+// WriteDeprecations writes the deprecated API uses grouped by Helm release
+// and then by namespace.
+func WriteDeprecations(w io.Writer, deprecations []Deprecation) error {
+	if len(deprecations) == 0 {
+		_, err := fmt.Fprintln(w, "No deprecated API usage found.")
+		return err
+	}
+	group := ""
+	for _, deprecation := range deprecations {
+		if current := deprecationGroup(deprecation); current != group {
+			group = current
+			fmt.Fprintf(w, "\n== %s ==\n", group)
+		}
+		name := deprecation.Kind
+		if deprecation.Name != "" {
+			name += " " + deprecation.Name
+		}
+		if deprecation.Requests != "" {
+			name += " (" + deprecation.Requests + " requests)"
+		}
+		fmt.Fprintf(w, "[%s] %s %s, removed in %s", deprecation.Status, deprecation.APIVersion, name, deprecation.RemovedIn)
+		if deprecation.Replacement != "" {
+			fmt.Fprintf(w, ", use %s", deprecation.Replacement)
+		}
+		fmt.Fprintf(w, " (%s)\n", deprecation.Source)
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+package analyze
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"sigs.k8s.io/yaml"
+)
+
+//go:embed rules.yaml
+var builtinRules []byte
+
+// Rule is a declarative check run against every object of a kind. An
+// object matches when all of its conditions match.
+type Rule struct {
+	ID         string      `json:"id"`
+	Title      string      `json:"title"`
+	Severity   string      `json:"severity"`
+	APIVersion string      `json:"apiVersion,omitempty"`
+	Kind       string      `json:"kind"`
+	Namespaces []string    `json:"namespaces,omitempty"`
+	When       []Condition `json:"when"`
+	Message    string      `json:"message"`
+
+	message *template.Template
+}
+
+// Condition tests the values found at Path. Path is a dotted field path
+// where a segment may end in [*] to visit every list element or in
+// [key=value] to visit the elements whose key equals value, for example
+// status.conditions[type=Ready].status.
+type Condition struct {
+	Path   string   `json:"path"`
+	Op     string   `json:"op"`
+	Value  string   `json:"value,omitempty"`
+	Values []string `json:"values,omitempty"`
+
+	regexp *regexp.Regexp
+}
+
+// BuiltinRules returns the rules shipped with the collector.
+func BuiltinRules() ([]Rule, error) {
+	return ParseRules(builtinRules)
+}
+
+// LoadRules returns the built-in rules plus every rule in the *.yaml files
+// of dir. A custom rule with the ID of a built-in one replaces it.
+func LoadRules(dir string) ([]Rule, error) {
+	rules, err := BuiltinRules()
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return rules, nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		custom, err := ParseRules(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		for _, rule := range custom {
+			replaced := false
+			for i := range rules {
+				if rules[i].ID == rule.ID {
+					rules[i] = rule
+					replaced = true
+				}
+			}
+			if !replaced {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// ParseRules parses and validates a YAML list of rules.
+func ParseRules(data []byte) ([]Rule, error) {
+	var rules []Rule
+	err := yaml.Unmarshal(data, &rules)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		rule := &rules[i]
+		if rule.ID == "" || rule.Kind == "" || len(rule.When) == 0 {
+			return nil, fmt.Errorf("rule %d: id, kind and when are required", i)
+		}
+		if rule.Severity == "" {
+			rule.Severity = "warning"
+		}
+		if rule.Message == "" {
+			rule.Message = "{{.Kind}} {{.Name}}: " + rule.Title
+		}
+		rule.message, err = template.New(rule.ID).Parse(rule.Message)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rule.ID, err)
+		}
+		for j := range rule.When {
+			condition := &rule.When[j]
+			switch condition.Op {
+			case "equals", "notEquals", "in", "notIn", "exists", "notExists", "contains", "gt", "lt":
+			case "matches":
+				condition.regexp, err = regexp.Compile(condition.Value)
+				if err != nil {
+					return nil, fmt.Errorf("rule %s: %w", rule.ID, err)
+				}
+			default:
+				return nil, fmt.Errorf("rule %s: unknown op %q", rule.ID, condition.Op)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// Applies reports whether the rule should be evaluated for object.
+func (r *Rule) Applies(object Object) bool {
+	if object.Kind() != r.Kind {
+		return false
+	}
+	if r.APIVersion != "" && object.APIVersion() != r.APIVersion {
+		return false
+	}
+	if len(r.Namespaces) > 0 && !contains(r.Namespaces, object.Namespace()) {
+		return false
+	}
+	return true
+}
+
+// Evaluate returns whether every condition matches object, together with
+// the value that matched the first condition.
+func (r *Rule) Evaluate(object Object) (bool, string) {
+	matchedValue := ""
+	for i, condition := range r.When {
+		matched, value := condition.Evaluate(object.Data)
+		if !matched {
+			return false, ""
+		}
+		if i == 0 {
+			matchedValue = value
+		}
+	}
+	return true, matchedValue
+}
+
+// Render fills in the rule message for a matching object.
+func (r *Rule) Render(object Object, value string) string {
+	var buf bytes.Buffer
+	err := r.message.Execute(&buf, map[string]string{
+		"Kind":      object.Kind(),
+		"Name":      object.Name(),
+		"Namespace": object.Namespace(),
+		"Value":     value,
+		"Rule":      r.ID,
+	})
+	if err != nil {
+		return r.Title
+	}
+	return buf.String()
+}
+
+// Evaluate matches when any value at the path satisfies the operator; the
+// negative operators match when a value exists and none equal the target.
+func (c *Condition) Evaluate(data map[string]interface{}) (bool, string) {
+	values := Values(data, c.Path)
+	switch c.Op {
+	case "exists":
+		if len(values) > 0 {
+			return true, fmt.Sprint(values[0])
+		}
+		return false, ""
+	case "notExists":
+		return len(values) == 0, ""
+	case "notEquals", "notIn":
+		targets := c.Values
+		if c.Op == "notEquals" {
+			targets = []string{c.Value}
+		}
+		for _, value := range values {
+			if !contains(targets, fmt.Sprint(value)) {
+				return true, fmt.Sprint(value)
+			}
+		}
+		return false, ""
+	}
+	for _, value := range values {
+		text := fmt.Sprint(value)
+		switch c.Op {
+		case "equals":
+			if text == c.Value {
+				return true, text
+			}
+		case "in":
+			if contains(c.Values, text) {
+				return true, text
+			}
+		case "contains":
+			if strings.Contains(text, c.Value) {
+				return true, text
+			}
+		case "matches":
+			if c.regexp.MatchString(text) {
+				return true, text
+			}
+		case "gt", "lt":
+			number, err := strconv.ParseFloat(text, 64)
+			target, targetErr := strconv.ParseFloat(c.Value, 64)
+			if err != nil || targetErr != nil {
+				continue
+			}
+			if (c.Op == "gt" && number > target) || (c.Op == "lt" && number < target) {
+				return true, text
+			}
+		}
+	}
+	return false, ""
+}
+
+// Values returns every value found at path in data.
+func Values(data map[string]interface{}, path string) []interface{} {
+	current := []interface{}{data}
+	for _, segment := range strings.Split(path, ".") {
+		field, selector := segment, ""
+		if open := strings.Index(segment, "["); open >= 0 && strings.HasSuffix(segment, "]") {
+			field, selector = segment[:open], segment[open+1:len(segment)-1]
+		}
+		var next []interface{}
+		for _, value := range current {
+			m, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			child, ok := m[field]
+			if !ok {
+				continue
+			}
+			if selector == "" {
+				next = append(next, child)
+				continue
+			}
+			list, ok := child.([]interface{})
+			if !ok {
+				continue
+			}
+			for _, element := range list {
+				if selector == "*" || selectorMatches(element, selector) {
+					next = append(next, element)
+				}
+			}
+		}
+		current = next
+	}
+	var values []interface{}
+	for _, value := range current {
+		if value != nil {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func selectorMatches(element interface{}, selector string) bool {
+	key, want, ok := strings.Cut(selector, "=")
+	if !ok {
+		return false
+	}
+	m, ok := element.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	return fmt.Sprint(m[key]) == want
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
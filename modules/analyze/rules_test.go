@@ -0,0 +1,360 @@
+package analyze
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	crashingPod = `apiVersion: v1
+kind: Pod
+metadata:
+  name: web-1
+  namespace: default
+status:
+  containerStatuses:
+    - name: sidecar
+      restartCount: 0
+      state:
+        running: {}
+    - name: web
+      restartCount: 42
+      state:
+        waiting:
+          reason: CrashLoopBackOff
+`
+	pullingPod = `apiVersion: v1
+kind: Pod
+metadata:
+  name: api-1
+  namespace: default
+status:
+  initContainerStatuses:
+    - name: migrate
+      state:
+        waiting:
+          reason: ErrImagePull
+  containerStatuses:
+    - name: api
+      restartCount: 1
+      state:
+        waiting:
+          reason: ImagePullBackOff
+`
+	healthyPod = `apiVersion: v1
+kind: Pod
+metadata:
+  name: ok-1
+  namespace: default
+status:
+  containerStatuses:
+    - name: ok
+      restartCount: 3
+      state:
+        running: {}
+`
+	// nodes are written as a list without kinds, which are taken from
+	// their directory
+	nodes = `items:
+  - metadata:
+      name: node-1
+    status:
+      conditions:
+        - type: MemoryPressure
+          status: "True"
+        - type: DiskPressure
+          status: "False"
+        - type: Ready
+          status: "True"
+  - metadata:
+      name: node-2
+    status:
+      conditions:
+        - type: Ready
+          status: Unknown
+`
+	pendingClaim = `apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data
+  namespace: default
+status:
+  phase: Pending
+`
+	failedJob = `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: backup
+  namespace: default
+status:
+  conditions:
+    - type: Complete
+      status: "False"
+    - type: Failed
+      status: "True"
+`
+	rancherDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: rancher
+  namespace: %s
+status:
+  unavailableReplicas: 2
+  conditions:
+    - type: Available
+      status: "False"
+`
+	clusters = `apiVersion: management.cattle.io/v3
+kind: Cluster
+metadata:
+  name: c-abcde
+status:
+  conditions:
+    - type: Connected
+      status: "False"
+    - type: Ready
+      status: "True"
+---
+apiVersion: management.cattle.io/v3
+kind: Cluster
+metadata:
+  name: local
+status:
+  conditions:
+    - type: Connected
+      status: "True"
+    - type: Ready
+      status: "True"
+`
+)
+
+// bundleOf builds a bundle from file names and contents.
+func bundleOf(files map[string]string) *Bundle {
+	bundle := NewBundle()
+	for name, content := range files {
+		bundle.AddFile(name, []byte(content))
+	}
+	return bundle
+}
+
+// findingsOf returns the findings of a report as "rule object: message".
+func findingsOf(report *Report) []string {
+	var findings []string
+	for _, finding := range report.Findings {
+		object := finding.Name
+		if finding.Namespace != "" {
+			object = finding.Namespace + "/" + object
+		}
+		findings = append(findings, finding.RuleID+" "+object+": "+finding.Message)
+	}
+	return findings
+}
+
+func TestBuiltinRules(t *testing.T) {
+	rules, err := BuiltinRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		files    map[string]string
+		findings []string
+	}{
+		{
+			name:  "crash looping pod",
+			files: map[string]string{"pods/default/web-1.yaml": crashingPod},
+			findings: []string{
+				"pod-crashloop default/web-1: Pod default/web-1 has a container in CrashLoopBackOff",
+				"pod-restarts default/web-1: Pod default/web-1 has a container that restarted 42 times",
+			},
+		},
+		{
+			name:  "image pulls failing",
+			files: map[string]string{"pods/default/api-1.yaml": pullingPod},
+			findings: []string{
+				"pod-image-pull default/api-1: Pod default/api-1 can't pull its image (ImagePullBackOff)",
+				"pod-init-image-pull default/api-1: Pod default/api-1 can't pull an init container image (ErrImagePull)",
+			},
+		},
+		{
+			name:  "healthy pod",
+			files: map[string]string{"pods/default/ok-1.yaml": healthyPod},
+		},
+		{
+			name:  "node conditions",
+			files: map[string]string{"nodes/nodes.yaml": nodes},
+			findings: []string{
+				"node-not-ready node-2: Node node-2 is not ready (Ready=Unknown)",
+				"node-pressure node-1: Node node-1 reports MemoryPressure",
+			},
+		},
+		{
+			name: "pending claim and failed job",
+			files: map[string]string{
+				"persistentvolumeclaims/default/data.yaml": pendingClaim,
+				"jobs/default/backup.yaml":                 failedJob,
+			},
+			findings: []string{
+				"job-failed default/backup: Job default/backup failed",
+				"pvc-pending default/data: PersistentVolumeClaim default/data is pending",
+			},
+		},
+		{
+			name: "Rancher deployments only in cattle-system",
+			files: map[string]string{
+				"deployments/cattle-system/rancher.yaml": strings.Replace(rancherDeployment, "%s", "cattle-system", 1),
+				"deployments/default/rancher.yaml":       strings.Replace(rancherDeployment, "%s", "default", 1),
+			},
+			findings: []string{
+				"cattle-system-deployment-unavailable cattle-system/rancher: Deployment cattle-system/rancher is not available",
+				"cattle-system-deployment-degraded cattle-system/rancher: Deployment cattle-system/rancher has 2 unavailable replica(s)",
+			},
+		},
+		{
+			name:     "disconnected downstream cluster",
+			files:    map[string]string{"rancher-resources/clusters/clusters.yaml": clusters},
+			findings: []string{"cluster-disconnected c-abcde: Cluster c-abcde is disconnected from Rancher"},
+		},
+		{
+			name: "objects of another apiVersion are skipped",
+			files: map[string]string{
+				"custom/pod.yaml": strings.Replace(crashingPod, "apiVersion: v1", "apiVersion: example.com/v1", 1),
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report := Run(bundleOf(test.files), rules)
+			got := findingsOf(report)
+			if !reflect.DeepEqual(got, test.findings) {
+				t.Errorf("findings\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(test.findings, "\n"))
+			}
+			counted := 0
+			for _, count := range report.Counts {
+				counted += count
+			}
+			if counted != len(report.Findings) {
+				t.Errorf("counts %v for %d findings", report.Counts, len(report.Findings))
+			}
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	builtin, err := BuiltinRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		custom   string
+		rules    int
+		findings []string
+		err      string
+	}{
+		{
+			name:     "built-in rules only",
+			rules:    len(builtin),
+			findings: []string{"pod-crashloop default/web-1", "pod-restarts default/web-1"},
+		},
+		{
+			name: "custom rule replaces a built-in one",
+			custom: `- id: pod-restarts
+  title: Container restarted
+  kind: Pod
+  when:
+    - path: status.containerStatuses[*].restartCount
+      op: gt
+      value: "100"
+`,
+			rules:    len(builtin),
+			findings: []string{"pod-crashloop default/web-1"},
+		},
+		{
+			name: "custom rule is added",
+			custom: `- id: sidecar-container
+  title: Pod has a sidecar
+  severity: info
+  kind: Pod
+  when:
+    - path: status.containerStatuses[name=sidecar].state
+      op: exists
+    - path: metadata.namespace
+      op: matches
+      value: ^def
+`,
+			rules:    len(builtin) + 1,
+			findings: []string{"pod-crashloop default/web-1", "pod-restarts default/web-1", "sidecar-container default/web-1"},
+		},
+		{
+			name: "unknown op",
+			custom: `- id: broken
+  kind: Pod
+  when:
+    - path: metadata.name
+      op: startsWith
+`,
+			err: `rule broken: unknown op "startsWith"`,
+		},
+		{
+			name: "missing conditions",
+			custom: `- id: broken
+  kind: Pod
+`,
+			err: "rule 0: id, kind and when are required",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if test.custom != "" {
+				err := os.WriteFile(filepath.Join(dir, "custom.yaml"), []byte(test.custom), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			rules, err := LoadRules(dir)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rules) != test.rules {
+				t.Errorf("%d rules, want %d", len(rules), test.rules)
+			}
+			var got []string
+			for _, finding := range Run(bundleOf(map[string]string{"pods/default/web-1.yaml": crashingPod}), rules).Findings {
+				got = append(got, finding.RuleID+" "+finding.Namespace+"/"+finding.Name)
+			}
+			if !reflect.DeepEqual(got, test.findings) {
+				t.Errorf("findings %v, want %v", got, test.findings)
+			}
+		})
+	}
+}
+
+func TestBundleLoads(t *testing.T) {
+	bundle := bundleOf(map[string]string{
+		"pods/default/ok-1.yaml":         healthyPod,
+		"rancher-data/rancher-data.json": `{"RancherVersion":"v2.7.5"}`,
+		"metrics/pod-metrics.json":       `{"items":[]}`,
+		"nodes/node-1/kubelet.log":       "kubelet log",
+	})
+	if len(bundle.Objects) != 1 || bundle.Objects[0].Kind() != "Pod" {
+		t.Errorf("objects %v, want the pod", bundle.Objects)
+	}
+	var files []string
+	for name := range bundle.Files {
+		files = append(files, name)
+	}
+	if !reflect.DeepEqual(files, []string{"rancher-data/rancher-data.json"}) {
+		t.Errorf("files %v, want only the Rancher data", files)
+	}
+}
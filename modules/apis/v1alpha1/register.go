@@ -0,0 +1,29 @@
+// Package v1alpha1 holds the SupportBundle API of the
+// supportability.rancher.io group.
+package v1alpha1
+
+import (
+	_ "embed"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// CustomResourceDefinition is the CRD of SupportBundle.
+//
+//go:embed supportbundles.yaml
+var CustomResourceDefinition []byte
+
+var GroupVersion = schema.GroupVersion{Group: "supportability.rancher.io", Version: "v1alpha1"}
+
+var (
+	SchemeBuilder = runtime.NewSchemeBuilder(addKnownTypes)
+	AddToScheme   = SchemeBuilder.AddToScheme
+)
+
+func addKnownTypes(scheme *runtime.Scheme) error {
+	scheme.AddKnownTypes(GroupVersion, &SupportBundle{}, &SupportBundleList{})
+	metav1.AddToGroupVersion(scheme, GroupVersion)
+	return nil
+}
@@ -0,0 +1,72 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	PhasePending   = "Pending"
+	PhaseRunning   = "Running"
+	PhaseSucceeded = "Succeeded"
+	PhaseFailed    = "Failed"
+)
+
+// SupportBundle requests a collection. The controller runs it once and
+// records the outcome in the status.
+type SupportBundle struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SupportBundleSpec   `json:"spec,omitempty"`
+	Status SupportBundleStatus `json:"status,omitempty"`
+}
+
+type SupportBundleSpec struct {
+	// Profile is the collection profile, full when empty.
+	Profile string `json:"profile,omitempty"`
+	// Clusters limits the Rancher clusters collected, all when empty.
+	Clusters []string `json:"clusters,omitempty"`
+	// Upload is where the bundle is sent once collected.
+	Upload *UploadDestination `json:"upload,omitempty"`
+	// TTLSecondsAfterFinished deletes the SupportBundle that long after it
+	// succeeded or failed. The controller default applies when unset.
+	TTLSecondsAfterFinished *int64 `json:"ttlSecondsAfterFinished,omitempty"`
+}
+
+// UploadSecretLabel marks the Secrets a SupportBundle can read its upload
+// destination from.
+const UploadSecretLabel = "supportability.rancher.io/upload-destination"
+
+// UploadDestination is an http(s):// URL under one of the prefixes the
+// operator allows with UPLOAD_ALLOWED_DESTINATIONS. When it carries
+// credentials, such as a presigned URL, it can be read from the url key of
+// a Secret labeled UploadSecretLabel=true in the namespace of the
+// SupportBundle instead.
+type UploadDestination struct {
+	URL       string `json:"url,omitempty"`
+	SecretRef string `json:"secretRef,omitempty"`
+}
+
+type SupportBundleStatus struct {
+	Phase          string       `json:"phase,omitempty"`
+	JobID          string       `json:"jobID,omitempty"`
+	Step           string       `json:"step,omitempty"`
+	Progress       string       `json:"progress,omitempty"`
+	Error          string       `json:"error,omitempty"`
+	Bundle         string       `json:"bundle,omitempty"`
+	Location       string       `json:"location,omitempty"`
+	Checksum       string       `json:"checksum,omitempty"`
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+func (s *SupportBundleStatus) Finished() bool {
+	return s.Phase == PhaseSucceeded || s.Phase == PhaseFailed
+}
+
+type SupportBundleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []SupportBundle `json:"items"`
+}
@@ -0,0 +1,268 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	"github.com/mattmattox/supportability-collector/modules/kubernetes"
+	"github.com/mattmattox/supportability-collector/modules/logging"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	k8s "k8s.io/client-go/kubernetes"
+)
+
+var log = logging.SetupLogging()
+
+// Anonymous are the paths served without authentication, so kubelet
+// probes, which can't send a token or a client certificate, keep working.
+// /readyz and /livez only answer with their status; the errors of their
+// checks, which describe the cluster and the bundle storage, are served
+// with authentication on /readyz/checks and /livez/checks.
+var Anonymous = []string{"/healthz", "/readyz", "/livez"}
+
+// cacheTTL is how long token and access reviews are reused.
+const cacheTTL = time.Minute
+
+// maxCacheEntries bounds the review cache.
+const maxCacheEntries = 1024
+
+var errUnauthenticated = errors.New("unauthenticated")
+
+// Authenticator protects the HTTP server. Requests are authenticated by a
+// verified client certificate, whose common name and organizations are the
+// user and groups, or with TokenAuth by a bearer token checked with a
+// TokenReview. With TokenAuth every request is then authorized with a
+// SubjectAccessReview on its path and lower case method, so access is
+// granted with ClusterRoles listing nonResourceURLs such as /api/v1/*.
+// Without TokenAuth a verified client certificate grants access.
+type Authenticator struct {
+	TokenAuth   bool
+	ClientCerts bool
+
+	client k8s.Interface
+	mu     sync.Mutex
+	cache  map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	user    *authenticationv1.UserInfo
+	allowed bool
+	reason  string
+	expires time.Time
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// New returns the authenticator configured by settings, or nil when
+// neither client certificates nor tokens are required.
+func New(settings cli.Cli) (*Authenticator, error) {
+	if !settings.TokenAuth && settings.TLSClientCAFile == "" {
+		return nil, nil
+	}
+	a := &Authenticator{
+		TokenAuth:   settings.TokenAuth,
+		ClientCerts: settings.TLSClientCAFile != "",
+		cache:       map[string]cacheEntry{},
+	}
+	if a.TokenAuth {
+		client, err := kubernetes.GetClient()
+		if err != nil {
+			return nil, err
+		}
+		a.client = client
+	}
+	return a, nil
+}
+
+// CheckServe refuses to serve the collection API on an address other than
+// loopback without TLS and either client certificates or tokens, unless
+// InsecureServe opts out.
+func CheckServe(settings cli.Cli) error {
+	if settings.InsecureServe || isLoopback(settings.ListenAddress) {
+		return nil
+	}
+	if settings.TLSCertFile == "" {
+		return fmt.Errorf("listening on %s requires TLS_CERT_FILE and TLS_KEY_FILE, or INSECURE_SERVE=true", settings.ListenAddress)
+	}
+	if !settings.TokenAuth && settings.TLSClientCAFile == "" {
+		return fmt.Errorf("listening on %s requires TOKEN_AUTH or TLS_CLIENT_CA_FILE, or INSECURE_SERVE=true", settings.ListenAddress)
+	}
+	return nil
+}
+
+func isLoopback(address string) bool {
+	if address == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Middleware rejects the requests that aren't authenticated and
+// authorized, except for the anonymous paths.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAnonymous(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user, err := a.authenticate(r)
+		if errors.Is(err, errUnauthenticated) {
+			if a.TokenAuth {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="supportability-collector"`)
+			}
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		if err != nil {
+			log.Warningf("Authentication failed - Error %s", err)
+			writeError(w, http.StatusInternalServerError, "authentication failed")
+			return
+		}
+		allowed, reason, err := a.authorize(user, r)
+		if err != nil {
+			log.Warningf("Authorization failed - Error %s", err)
+			writeError(w, http.StatusInternalServerError, "authorization failed")
+			return
+		}
+		if !allowed {
+			log.Infof("Denied %s %s to %s: %s", r.Method, r.URL.Path, user.Username, reason)
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *Authenticator) authenticate(r *http.Request) (*authenticationv1.UserInfo, error) {
+	if a.ClientCerts && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cert := r.TLS.VerifiedChains[0][0]
+		return &authenticationv1.UserInfo{
+			Username: cert.Subject.CommonName,
+			Groups:   cert.Subject.Organization,
+		}, nil
+	}
+	token, ok := bearerToken(r)
+	if !a.TokenAuth || !ok {
+		return nil, errUnauthenticated
+	}
+
+	key := "token/" + hash(token)
+	if entry, ok := a.cached(key); ok {
+		if entry.user == nil {
+			return nil, errUnauthenticated
+		}
+		return entry.user, nil
+	}
+	status, err := kubernetes.CreateTokenReview(a.client, token)
+	if err != nil {
+		return nil, err
+	}
+	var user *authenticationv1.UserInfo
+	if status.Authenticated {
+		user = &status.User
+	}
+	a.store(key, cacheEntry{user: user})
+	if user == nil {
+		return nil, errUnauthenticated
+	}
+	return user, nil
+}
+
+func (a *Authenticator) authorize(user *authenticationv1.UserInfo, r *http.Request) (bool, string, error) {
+	if !a.TokenAuth {
+		return true, "", nil
+	}
+	verb := strings.ToLower(r.Method)
+	key := "access/" + hash(user.Username+"\x00"+user.UID+"\x00"+strings.Join(user.Groups, ",")+"\x00"+verb+"\x00"+r.URL.Path)
+	if entry, ok := a.cached(key); ok {
+		return entry.allowed, entry.reason, nil
+	}
+	extra := map[string]authorizationv1.ExtraValue{}
+	for name, values := range user.Extra {
+		extra[name] = authorizationv1.ExtraValue(values)
+	}
+	status, err := kubernetes.CreateSubjectAccessReview(a.client, authorizationv1.SubjectAccessReviewSpec{
+		User:   user.Username,
+		UID:    user.UID,
+		Groups: user.Groups,
+		Extra:  extra,
+		NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+			Path: r.URL.Path,
+			Verb: verb,
+		},
+	})
+	if err != nil {
+		return false, "", err
+	}
+	a.store(key, cacheEntry{allowed: status.Allowed, reason: status.Reason})
+	return status.Allowed, status.Reason, nil
+}
+
+func (a *Authenticator) cached(key string) (cacheEntry, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entry, ok := a.cache[key]
+	if !ok || time.Now().After(entry.expires) {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (a *Authenticator) store(key string, entry cacheEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	if len(a.cache) >= maxCacheEntries {
+		for key, entry := range a.cache {
+			if now.After(entry.expires) {
+				delete(a.cache, key)
+			}
+		}
+	}
+	if len(a.cache) >= maxCacheEntries {
+		a.cache = map[string]cacheEntry{}
+	}
+	entry.expires = now.Add(cacheTTL)
+	a.cache[key] = entry
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < len("Bearer ") || !strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(header[len("Bearer "):])
+	return token, token != ""
+}
+
+func isAnonymous(path string) bool {
+	for _, anonymous := range Anonymous {
+		if path == anonymous {
+			return true
+		}
+	}
+	return false
+}
+
+func hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: message})
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattmattox/supportability-collector/modules/cli"
+)
+
+func TestCheckServe(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings cli.Cli
+		err      bool
+	}{
+		{
+			name:     "all addresses without TLS",
+			settings: cli.Cli{ListenAddress: "0.0.0.0"},
+			err:      true,
+		},
+		{
+			name:     "TLS without authentication",
+			settings: cli.Cli{ListenAddress: "0.0.0.0", TLSCertFile: "tls.crt", TLSKeyFile: "tls.key"},
+			err:      true,
+		},
+		{
+			name:     "authentication without TLS",
+			settings: cli.Cli{ListenAddress: "10.0.0.1", TokenAuth: true},
+			err:      true,
+		},
+		{
+			name:     "TLS and tokens",
+			settings: cli.Cli{ListenAddress: "0.0.0.0", TLSCertFile: "tls.crt", TLSKeyFile: "tls.key", TokenAuth: true},
+		},
+		{
+			name:     "TLS and client certificates",
+			settings: cli.Cli{ListenAddress: "::", TLSCertFile: "tls.crt", TLSKeyFile: "tls.key", TLSClientCAFile: "ca.crt"},
+		},
+		{
+			name:     "IPv4 loopback",
+			settings: cli.Cli{ListenAddress: "127.0.0.1"},
+		},
+		{
+			name:     "IPv6 loopback",
+			settings: cli.Cli{ListenAddress: "::1"},
+		},
+		{
+			name:     "localhost",
+			settings: cli.Cli{ListenAddress: "localhost"},
+		},
+		{
+			name:     "explicit opt-out",
+			settings: cli.Cli{ListenAddress: "0.0.0.0", InsecureServe: true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckServe(test.settings)
+			if (err != nil) != test.err {
+				t.Errorf("error %v, want error %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestMiddlewareAnonymous(t *testing.T) {
+	a := &Authenticator{ClientCerts: true, cache: map[string]cacheEntry{}}
+	handler := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	tests := map[string]int{
+		"/healthz":            http.StatusOK,
+		"/readyz":             http.StatusOK,
+		"/livez":              http.StatusOK,
+		"/readyz/checks":      http.StatusUnauthorized,
+		"/livez/checks":       http.StatusUnauthorized,
+		"/healthz/":           http.StatusUnauthorized,
+		"/metrics":            http.StatusUnauthorized,
+		"/api/v1/collections": http.StatusUnauthorized,
+	}
+	for path, status := range tests {
+		t.Run(path, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+			if recorder.Code != status {
+				t.Errorf("status %d, want %d", recorder.Code, status)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mattmattox/supportability-collector/modules/cli"
+)
+
+// TLSConfig returns the TLS configuration of the HTTP server, or nil when
+// no certificate is configured. The certificate and key are reloaded when
+// their files change, so renewed certificates are picked up without a
+// restart. With a client CA, client certificates signed by it are
+// verified and authenticate their requests.
+func TLSConfig(settings cli.Cli) (*tls.Config, error) {
+	if settings.TLSCertFile == "" {
+		return nil, nil
+	}
+	reloader := &certReloader{certFile: settings.TLSCertFile, keyFile: settings.TLSKeyFile}
+	_, err := reloader.GetCertificate(nil)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if settings.TLSClientCAFile != "" {
+		data, err := os.ReadFile(settings.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%s: no certificates found", settings.TLSClientCAFile)
+		}
+		config.ClientCAs = pool
+		// Anonymous endpoints such as probes connect without certificates,
+		// the middleware requires them on everything else.
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// certReloader serves a key pair, reloading it when either file's
+// modification time changes.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var modTimes [2]time.Time
+	for i, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			if c.cert != nil {
+				log.Warningf("TLS certificate reload failed, keeping the current one - Error %s", err)
+				return c.cert, nil
+			}
+			return nil, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	if c.cert != nil && modTimes == c.modTimes {
+		return c.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		if c.cert != nil {
+			log.Warningf("TLS certificate reload failed, keeping the current one - Error %s", err)
+			return c.cert, nil
+		}
+		return nil, err
+	}
+	if c.cert != nil {
+		log.Infof("Reloaded TLS certificate %s", c.certFile)
+	}
+	c.cert = &cert
+	c.modTimes = modTimes
+	return c.cert, nil
+}
@@ -1,19 +1,202 @@
 package cli
 
 import (
+	"flag"
+	"net/url"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mattmattox/supportability-collector/modules/logging"
 )
 
 type Cli struct {
-	HealthCheckPort  string
-	RancherAccessKey string
-	RancherSecretKey string
+	HealthCheckPort           string
+	RancherAccessKey          string
+	RancherSecretKey          string
+	NodeDiagnostics           bool
+	Probes                    bool
+	NodeDiagnosticsImage      string
+	NodeDiagnosticsNamespace  string
+	NodeDiagnosticsTimeout    time.Duration
+	EtcdEndpoints             []string
+	EtcdCAFile                string
+	EtcdCertFile              string
+	EtcdKeyFile               string
+	PrometheusURL             string
+	PrometheusQueriesFile     string
+	PrometheusWindow          time.Duration
+	Namespaces                []string
+	RedactionRulesFile        string
+	AnalyzeRulesDir           string
+	SupportMatrixFile         string
+	UpgradeTarget             string
+	Profile                   string
+	Clusters                  []string
+	BundleDir                 string
+	MaxConcurrentCollections  int
+	Controller                bool
+	ControllerNamespace       string
+	SupportBundleTTL          time.Duration
+	SchedulesFile             string
+	TriggersFile              string
+	TriggerInterval           time.Duration
+	StuckJobTimeout           time.Duration
+	JobHistoryTTL             time.Duration
+	JobHistoryLimit           int
+	ListenAddress             string
+	TLSCertFile               string
+	TLSKeyFile                string
+	TLSClientCAFile           string
+	TokenAuth                 bool
+	InsecureServe             bool
+	UploadAllowedDestinations []string
+	UploadDestination         string
+	AgeRecipients             []string
+	PGPRecipientsFile         string
+	SigningKeyFile            string
+	SigningKeySecret          string
+	SigningKeyless            bool
+	ArchiveFormat             string
+	ArchiveBufferSize         int64
+	ArchiveCompressionLevel   int
+	ArchiveCompressionThreads int
+	ClusterName               string
+}
+
+// AnalyzeOptions are the arguments of the offline analyze command.
+type AnalyzeOptions struct {
+	Bundle            string
+	RulesDir          string
+	SupportMatrixFile string
+	UpgradeTarget     string
+	OutputDir         string
+}
+
+// DiffOptions are the arguments of the diff command.
+type DiffOptions struct {
+	Old    string
+	New    string
+	Format string
+}
+
+// DecryptOptions are the arguments of the decrypt command.
+type DecryptOptions struct {
+	Bundle         string
+	IdentityFile   string
+	PassphraseFile string
+	Output         string
+}
+
+// VerifyOptions are the arguments of the verify command.
+type VerifyOptions struct {
+	Bundle        string
+	PublicKeyFile string
 }
 
 var log = logging.SetupLogging()
 
+// Command returns the sub-command given on the command line, defaulting to
+// collect so the container keeps its original behaviour.
+func Command() string {
+	if len(os.Args) < 2 {
+		return "collect"
+	}
+	return os.Args[1]
+}
+
+func AnalyzeSettings(args []string) AnalyzeOptions {
+	flags := flag.NewFlagSet("analyze", flag.ExitOnError)
+	rulesDir := flags.String("rules", os.Getenv("ANALYZE_RULES_DIR"), "directory of additional rule files")
+	supportMatrixFile := flags.String("support-matrix", os.Getenv("SUPPORT_MATRIX_FILE"), "support matrix file replacing the built-in one")
+	upgradeTarget := flags.String("target", os.Getenv("UPGRADE_TARGET"), "Rancher version to check upgrade readiness for")
+	outputDir := flags.String("output", ".", "directory to write the analysis files to")
+	flags.Usage = func() {
+		flags.Output().Write([]byte("Usage: supportability-collector analyze [flags] <bundle>\n"))
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	return AnalyzeOptions{
+		Bundle:            flags.Arg(0),
+		RulesDir:          *rulesDir,
+		SupportMatrixFile: *supportMatrixFile,
+		UpgradeTarget:     *upgradeTarget,
+		OutputDir:         *outputDir,
+	}
+}
+
+func DiffSettings(args []string) DiffOptions {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	format := flags.String("format", "text", "output format, text or json")
+	flags.Usage = func() {
+		flags.Output().Write([]byte("Usage: supportability-collector diff [flags] <old-bundle> <new-bundle>\n"))
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 || (*format != "text" && *format != "json") {
+		flags.Usage()
+		os.Exit(2)
+	}
+	return DiffOptions{
+		Old:    flags.Arg(0),
+		New:    flags.Arg(1),
+		Format: *format,
+	}
+}
+
+func DecryptSettings(args []string) DecryptOptions {
+	flags := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	identityFile := flags.String("identity", os.Getenv("DECRYPT_IDENTITY_FILE"), "age identity file or armored OpenPGP private key")
+	passphraseFile := flags.String("passphrase-file", "", "file holding the passphrase of a protected OpenPGP key")
+	output := flags.String("output", "", "decrypted bundle path, defaults to the bundle without its .age or .gpg extension")
+	flags.Usage = func() {
+		flags.Output().Write([]byte("Usage: supportability-collector decrypt [flags] <bundle.tar.gz.age|bundle.tar.gz.gpg>\n"))
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 || *identityFile == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *output == "" {
+		*output = strings.TrimSuffix(strings.TrimSuffix(flags.Arg(0), ".age"), ".gpg")
+		if *output == flags.Arg(0) {
+			*output += ".decrypted"
+		}
+	}
+	return DecryptOptions{
+		Bundle:         flags.Arg(0),
+		IdentityFile:   *identityFile,
+		PassphraseFile: *passphraseFile,
+		Output:         *output,
+	}
+}
+
+func VerifySettings(args []string) VerifyOptions {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	publicKeyFile := flags.String("key", os.Getenv("VERIFY_PUBLIC_KEY_FILE"), "PEM ed25519 public key the manifest must be signed with; without it only integrity is checked, not authenticity, and keyless signatures are checked against their own certificate")
+	flags.Usage = func() {
+		flags.Output().Write([]byte("Usage: supportability-collector verify [flags] <bundle>\n"))
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	return VerifyOptions{
+		Bundle:        flags.Arg(0),
+		PublicKeyFile: *publicKeyFile,
+	}
+}
+
 func Settings() Cli {
 	healthCheckPort := os.Getenv("HEALTH_CHECK_PORT")
 	if healthCheckPort == "" {
@@ -27,11 +210,287 @@ func Settings() Cli {
 	if rancherSecretKey == "" {
 		log.Fatal("RANCHER_SECRET_KEY is not set")
 	}
+	nodeDiagnostics := false
+	if os.Getenv("NODE_DIAGNOSTICS") != "" {
+		var err error
+		nodeDiagnostics, err = strconv.ParseBool(os.Getenv("NODE_DIAGNOSTICS"))
+		if err != nil {
+			log.Fatal("NODE_DIAGNOSTICS must be true or false")
+		}
+	}
+	probes := false
+	if os.Getenv("PROBES") != "" {
+		var err error
+		probes, err = strconv.ParseBool(os.Getenv("PROBES"))
+		if err != nil {
+			log.Fatal("PROBES must be true or false")
+		}
+	}
+	nodeDiagnosticsImage := os.Getenv("NODE_DIAGNOSTICS_IMAGE")
+	if nodeDiagnosticsImage == "" {
+		nodeDiagnosticsImage = "rancherlabs/swiss-army-knife:latest"
+	}
+	nodeDiagnosticsNamespace := os.Getenv("NODE_DIAGNOSTICS_NAMESPACE")
+	if nodeDiagnosticsNamespace == "" {
+		nodeDiagnosticsNamespace = "cattle-system"
+	}
+	nodeDiagnosticsTimeout := 5 * time.Minute
+	if os.Getenv("NODE_DIAGNOSTICS_TIMEOUT") != "" {
+		var err error
+		nodeDiagnosticsTimeout, err = time.ParseDuration(os.Getenv("NODE_DIAGNOSTICS_TIMEOUT"))
+		if err != nil {
+			log.Fatal("NODE_DIAGNOSTICS_TIMEOUT must be a duration such as 5m")
+		}
+	}
+	var etcdEndpoints []string
+	if os.Getenv("ETCD_ENDPOINTS") != "" {
+		etcdEndpoints = strings.Split(os.Getenv("ETCD_ENDPOINTS"), ",")
+	}
+	prometheusWindow := time.Hour
+	if os.Getenv("PROMETHEUS_WINDOW") != "" {
+		var err error
+		prometheusWindow, err = time.ParseDuration(os.Getenv("PROMETHEUS_WINDOW"))
+		if err != nil {
+			log.Fatal("PROMETHEUS_WINDOW must be a duration such as 1h")
+		}
+	}
+	var clusters []string
+	if os.Getenv("COLLECT_CLUSTERS") != "" {
+		clusters = strings.Split(os.Getenv("COLLECT_CLUSTERS"), ",")
+	}
+	bundleDir := os.Getenv("BUNDLE_DIR")
+	if bundleDir == "" {
+		bundleDir = filepath.Join(os.TempDir(), "supportability-bundles")
+	}
+	maxConcurrentCollections := 1
+	if os.Getenv("MAX_CONCURRENT_COLLECTIONS") != "" {
+		var err error
+		maxConcurrentCollections, err = strconv.Atoi(os.Getenv("MAX_CONCURRENT_COLLECTIONS"))
+		if err != nil || maxConcurrentCollections < 1 {
+			log.Fatal("MAX_CONCURRENT_COLLECTIONS must be a positive number")
+		}
+	}
+	controller := false
+	if os.Getenv("SUPPORTBUNDLE_CONTROLLER") != "" {
+		var err error
+		controller, err = strconv.ParseBool(os.Getenv("SUPPORTBUNDLE_CONTROLLER"))
+		if err != nil {
+			log.Fatal("SUPPORTBUNDLE_CONTROLLER must be true or false")
+		}
+	}
+	supportBundleTTL := 24 * time.Hour
+	if os.Getenv("SUPPORTBUNDLE_TTL") != "" {
+		var err error
+		supportBundleTTL, err = time.ParseDuration(os.Getenv("SUPPORTBUNDLE_TTL"))
+		if err != nil {
+			log.Fatal("SUPPORTBUNDLE_TTL must be a duration such as 24h")
+		}
+	}
+	triggerInterval := 30 * time.Second
+	if os.Getenv("TRIGGER_INTERVAL") != "" {
+		var err error
+		triggerInterval, err = time.ParseDuration(os.Getenv("TRIGGER_INTERVAL"))
+		if err != nil || triggerInterval <= 0 {
+			log.Fatal("TRIGGER_INTERVAL must be a duration such as 30s")
+		}
+	}
+	stuckJobTimeout := time.Hour
+	if os.Getenv("STUCK_JOB_TIMEOUT") != "" {
+		var err error
+		stuckJobTimeout, err = time.ParseDuration(os.Getenv("STUCK_JOB_TIMEOUT"))
+		if err != nil || stuckJobTimeout <= 0 {
+			log.Fatal("STUCK_JOB_TIMEOUT must be a duration such as 1h")
+		}
+	}
+	jobHistoryTTL := 24 * time.Hour
+	if os.Getenv("JOB_HISTORY_TTL") != "" {
+		var err error
+		jobHistoryTTL, err = time.ParseDuration(os.Getenv("JOB_HISTORY_TTL"))
+		if err != nil || jobHistoryTTL <= 0 {
+			log.Fatal("JOB_HISTORY_TTL must be a duration such as 24h")
+		}
+	}
+	jobHistoryLimit := 100
+	if os.Getenv("JOB_HISTORY_LIMIT") != "" {
+		var err error
+		jobHistoryLimit, err = strconv.Atoi(os.Getenv("JOB_HISTORY_LIMIT"))
+		if err != nil || jobHistoryLimit < 1 {
+			log.Fatal("JOB_HISTORY_LIMIT must be a positive number")
+		}
+	}
+	listenAddress := os.Getenv("LISTEN_ADDRESS")
+	if listenAddress == "" {
+		listenAddress = "0.0.0.0"
+	}
+	if (os.Getenv("TLS_CERT_FILE") == "") != (os.Getenv("TLS_KEY_FILE") == "") {
+		log.Fatal("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if os.Getenv("TLS_CLIENT_CA_FILE") != "" && os.Getenv("TLS_CERT_FILE") == "" {
+		log.Fatal("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	tokenAuth := false
+	if os.Getenv("TOKEN_AUTH") != "" {
+		var err error
+		tokenAuth, err = strconv.ParseBool(os.Getenv("TOKEN_AUTH"))
+		if err != nil {
+			log.Fatal("TOKEN_AUTH must be true or false")
+		}
+	}
+	insecureServe := false
+	if os.Getenv("INSECURE_SERVE") != "" {
+		var err error
+		insecureServe, err = strconv.ParseBool(os.Getenv("INSECURE_SERVE"))
+		if err != nil {
+			log.Fatal("INSECURE_SERVE must be true or false")
+		}
+	}
+	var uploadAllowedDestinations []string
+	if os.Getenv("UPLOAD_ALLOWED_DESTINATIONS") != "" {
+		for _, destination := range strings.Split(os.Getenv("UPLOAD_ALLOWED_DESTINATIONS"), ",") {
+			destination = strings.TrimSpace(destination)
+			allowed, err := url.Parse(destination)
+			if err != nil || (allowed.Scheme != "http" && allowed.Scheme != "https") || allowed.Host == "" {
+				log.Fatal("UPLOAD_ALLOWED_DESTINATIONS must be a comma separated list of http(s) URL prefixes")
+			}
+			uploadAllowedDestinations = append(uploadAllowedDestinations, destination)
+		}
+	}
+	var ageRecipients []string
+	if os.Getenv("AGE_RECIPIENTS") != "" {
+		ageRecipients = strings.Split(os.Getenv("AGE_RECIPIENTS"), ",")
+	}
+	if len(ageRecipients) > 0 && os.Getenv("PGP_RECIPIENTS_FILE") != "" {
+		log.Fatal("AGE_RECIPIENTS and PGP_RECIPIENTS_FILE can't be used together")
+	}
+	signingKeyless := false
+	if os.Getenv("SIGNING_KEYLESS") != "" {
+		var err error
+		signingKeyless, err = strconv.ParseBool(os.Getenv("SIGNING_KEYLESS"))
+		if err != nil {
+			log.Fatal("SIGNING_KEYLESS must be true or false")
+		}
+	}
+	signers := 0
+	for _, set := range []bool{os.Getenv("SIGNING_KEY_FILE") != "", os.Getenv("SIGNING_KEY_SECRET") != "", signingKeyless} {
+		if set {
+			signers++
+		}
+	}
+	if signers > 1 {
+		log.Fatal("Only one of SIGNING_KEY_FILE, SIGNING_KEY_SECRET and SIGNING_KEYLESS can be set")
+	}
+	archiveFormat := os.Getenv("ARCHIVE_FORMAT")
+	if archiveFormat == "" {
+		archiveFormat = "tar.gz"
+	}
+	if archiveFormat != "tar.gz" && archiveFormat != "tar.zst" && archiveFormat != "zip" && archiveFormat != "dir" {
+		log.Fatal("ARCHIVE_FORMAT must be tar.gz, tar.zst, zip or dir")
+	}
+	if archiveFormat == "dir" && (len(ageRecipients) > 0 || os.Getenv("PGP_RECIPIENTS_FILE") != "") {
+		log.Fatal("ARCHIVE_FORMAT dir can't be encrypted")
+	}
+	// UPLOAD_DESTINATION is where one-shot collections send their bundle,
+	// chosen by whoever runs the collector, so file:// is allowed
+	uploadDestination := os.Getenv("UPLOAD_DESTINATION")
+	if uploadDestination != "" {
+		destination, err := url.Parse(uploadDestination)
+		if err != nil || (destination.Scheme != "file" && destination.Scheme != "http" && destination.Scheme != "https") {
+			log.Fatal("UPLOAD_DESTINATION must be a file://, http:// or https:// URL")
+		}
+		if archiveFormat == "dir" {
+			log.Fatal("ARCHIVE_FORMAT dir can't be uploaded to UPLOAD_DESTINATION")
+		}
+	}
+	archiveBufferMB := 32
+	if os.Getenv("ARCHIVE_BUFFER_MB") != "" {
+		var err error
+		archiveBufferMB, err = strconv.Atoi(os.Getenv("ARCHIVE_BUFFER_MB"))
+		if err != nil || archiveBufferMB < 0 {
+			log.Fatal("ARCHIVE_BUFFER_MB must be a number of megabytes")
+		}
+	}
+	// -1 is the default level of every format
+	archiveCompressionLevel := -1
+	if os.Getenv("ARCHIVE_COMPRESSION_LEVEL") != "" {
+		var err error
+		archiveCompressionLevel, err = strconv.Atoi(os.Getenv("ARCHIVE_COMPRESSION_LEVEL"))
+		minLevel, maxLevel := 0, 9
+		if archiveFormat == "tar.zst" {
+			minLevel, maxLevel = 1, 22
+		}
+		if err != nil || archiveCompressionLevel < minLevel || archiveCompressionLevel > maxLevel {
+			log.Fatalf("ARCHIVE_COMPRESSION_LEVEL must be between %d and %d for %s", minLevel, maxLevel, archiveFormat)
+		}
+	}
+	archiveCompressionThreads := runtime.GOMAXPROCS(0)
+	if os.Getenv("ARCHIVE_COMPRESSION_THREADS") != "" {
+		var err error
+		archiveCompressionThreads, err = strconv.Atoi(os.Getenv("ARCHIVE_COMPRESSION_THREADS"))
+		if err != nil || archiveCompressionThreads < 1 {
+			log.Fatal("ARCHIVE_COMPRESSION_THREADS must be a positive number")
+		}
+	}
+	clusterName := os.Getenv("CLUSTER_NAME")
+	if clusterName == "" {
+		clusterName = "local"
+	}
+	namespaces := []string{"cattle-system", "kube-system", "cattle-fleet-system", "cattle-fleet-local-system", "cattle-monitoring-system", "cert-manager", "ingress-nginx"}
+	if os.Getenv("COLLECT_NAMESPACES") != "" {
+		namespaces = strings.Split(os.Getenv("COLLECT_NAMESPACES"), ",")
+	}
 
 	settings := Cli{
-		HealthCheckPort:  healthCheckPort,
-		RancherAccessKey: rancherAccessKey,
-		RancherSecretKey: rancherSecretKey,
+		HealthCheckPort:           healthCheckPort,
+		RancherAccessKey:          rancherAccessKey,
+		RancherSecretKey:          rancherSecretKey,
+		NodeDiagnostics:           nodeDiagnostics,
+		Probes:                    probes,
+		NodeDiagnosticsImage:      nodeDiagnosticsImage,
+		NodeDiagnosticsNamespace:  nodeDiagnosticsNamespace,
+		NodeDiagnosticsTimeout:    nodeDiagnosticsTimeout,
+		EtcdEndpoints:             etcdEndpoints,
+		EtcdCAFile:                os.Getenv("ETCD_CA_FILE"),
+		EtcdCertFile:              os.Getenv("ETCD_CERT_FILE"),
+		EtcdKeyFile:               os.Getenv("ETCD_KEY_FILE"),
+		PrometheusURL:             os.Getenv("PROMETHEUS_URL"),
+		PrometheusQueriesFile:     os.Getenv("PROMETHEUS_QUERIES_FILE"),
+		PrometheusWindow:          prometheusWindow,
+		Namespaces:                namespaces,
+		RedactionRulesFile:        os.Getenv("REDACTION_RULES_FILE"),
+		AnalyzeRulesDir:           os.Getenv("ANALYZE_RULES_DIR"),
+		SupportMatrixFile:         os.Getenv("SUPPORT_MATRIX_FILE"),
+		UpgradeTarget:             os.Getenv("UPGRADE_TARGET"),
+		Profile:                   os.Getenv("COLLECT_PROFILE"),
+		Clusters:                  clusters,
+		BundleDir:                 bundleDir,
+		MaxConcurrentCollections:  maxConcurrentCollections,
+		Controller:                controller,
+		ControllerNamespace:       os.Getenv("SUPPORTBUNDLE_NAMESPACE"),
+		SupportBundleTTL:          supportBundleTTL,
+		SchedulesFile:             os.Getenv("SCHEDULES_FILE"),
+		TriggersFile:              os.Getenv("TRIGGERS_FILE"),
+		TriggerInterval:           triggerInterval,
+		StuckJobTimeout:           stuckJobTimeout,
+		JobHistoryTTL:             jobHistoryTTL,
+		JobHistoryLimit:           jobHistoryLimit,
+		ListenAddress:             listenAddress,
+		TLSCertFile:               os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:                os.Getenv("TLS_KEY_FILE"),
+		TLSClientCAFile:           os.Getenv("TLS_CLIENT_CA_FILE"),
+		TokenAuth:                 tokenAuth,
+		InsecureServe:             insecureServe,
+		UploadAllowedDestinations: uploadAllowedDestinations,
+		UploadDestination:         uploadDestination,
+		AgeRecipients:             ageRecipients,
+		PGPRecipientsFile:         os.Getenv("PGP_RECIPIENTS_FILE"),
+		SigningKeyFile:            os.Getenv("SIGNING_KEY_FILE"),
+		SigningKeySecret:          os.Getenv("SIGNING_KEY_SECRET"),
+		SigningKeyless:            signingKeyless,
+		ArchiveFormat:             archiveFormat,
+		ArchiveBufferSize:         int64(archiveBufferMB) * 1024 * 1024,
+		ArchiveCompressionLevel:   archiveCompressionLevel,
+		ArchiveCompressionThreads: archiveCompressionThreads,
+		ClusterName:               clusterName,
 	}
 
 	return settings
@@ -0,0 +1,131 @@
+package collect
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"time"
+
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	"github.com/mattmattox/supportability-collector/modules/kubernetes"
+	v1 "k8s.io/api/core/v1"
+	k8s "k8s.io/client-go/kubernetes"
+)
+
+// CertificateInfo describes one certificate found in a TLS Secret. Only the
+// public certificate is read; keys never leave the cluster.
+type CertificateInfo struct {
+	Namespace string    `json:"namespace"`
+	Secret    string    `json:"secret"`
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	DNSNames  []string  `json:"dnsNames,omitempty"`
+	IsCA      bool      `json:"isCA"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+}
+
+func CertificatesDir(dir string) string {
+	certificatesDir := dir + "/certificates"
+	err := mkdirAll(certificatesDir, 0755)
+	if err != nil {
+		log.Fatalln("Certificates directory creation failed")
+	}
+	return certificatesDir
+}
+
+// CollectCertificates records the subject and validity of every certificate
+// stored in TLS Secrets of the collected namespaces.
+func CollectCertificates(settings cli.Cli, tempDirRoot string) {
+	log.Infoln("Collecting certificate expiry dates")
+
+	certificatesDir := CertificatesDir(tempDirRoot)
+
+	client, err := kubernetes.GetClient()
+	if err != nil {
+		log.Fatalln("Failed to connect to upstream cluster")
+	}
+
+	certificates := []CertificateInfo{}
+	for _, namespace := range settings.Namespaces {
+		secrets, err := kubernetes.GetSecretsByType(client, namespace, v1.SecretTypeTLS)
+		if err != nil {
+			log.Warningf("List of TLS secrets in %s failed - Error %s", namespace, err)
+			continue
+		}
+		for _, secret := range secrets {
+			certificates = append(certificates, parseCertificates(namespace, secret.Name, secret.Data[v1.TLSCertKey])...)
+		}
+	}
+
+	data, err := json.MarshalIndent(certificates, "", "  ")
+	if err != nil {
+		log.Warningf("Certificate list marshalling failed - Error %s", err)
+		return
+	}
+	err = writeFile(certificatesDir+"/certificates.json", data, 0644)
+	if err != nil {
+		log.Warningf("Certificate list file write failed - Error %s", err)
+	}
+
+	CertificatesCertManager(client, certificatesDir)
+
+	log.Infoln("Certificate collection complete")
+}
+
+// CertificatesCertManager saves the cert-manager Deployments so that the
+// analyzer can check the installed version.
+func CertificatesCertManager(client *k8s.Clientset, dir string) {
+	deployments, err := kubernetes.GetDeployments(client, "cert-manager")
+	if err != nil {
+		log.Warningf("List of deployments in cert-manager failed - Error %s", err)
+		return
+	}
+	certManagerDir := dir + "/cert-manager"
+	err = mkdirAll(certManagerDir, 0755)
+	if err != nil {
+		log.Warningf("Folder creation for cert-manager failed - Error %s", err)
+		return
+	}
+	for _, deployment := range deployments {
+		log.Infof("Grabbing YAML for cert-manager deployment: %s", deployment)
+		deploymentData, err := kubernetes.GetDeploymentYaml(client, "cert-manager", deployment)
+		if err != nil {
+			log.Warningf("Deployment YAML collection failed - Error %s", err)
+			continue
+		}
+		err = WriteYaml(certManagerDir+"/"+deployment+".yaml", deploymentData)
+		if err != nil {
+			log.Warningf("Deployment YAML file write failed - Error %s", err)
+		}
+	}
+}
+
+func parseCertificates(namespace string, secret string, data []byte) []CertificateInfo {
+	var certificates []CertificateInfo
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certificates
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			log.Warningf("Certificate in %s/%s could not be parsed - Error %s", namespace, secret, err)
+			continue
+		}
+		certificates = append(certificates, CertificateInfo{
+			Namespace: namespace,
+			Secret:    secret,
+			Subject:   certificate.Subject.String(),
+			Issuer:    certificate.Issuer.String(),
+			DNSNames:  certificate.DNSNames,
+			IsCA:      certificate.IsCA,
+			NotBefore: certificate.NotBefore,
+			NotAfter:  certificate.NotAfter,
+		})
+	}
+}
@@ -1,57 +1,305 @@
 package collect
 
 import (
-	"archive/tar"
-	"compress/gzip"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"runtime/debug"
+	"strings"
 	"time"
 
+	"github.com/mattmattox/supportability-collector/modules/analyze"
+	"github.com/mattmattox/supportability-collector/modules/cli"
 	"github.com/mattmattox/supportability-collector/modules/logging"
+	"github.com/mattmattox/supportability-collector/modules/manifest"
+	"github.com/mattmattox/supportability-collector/modules/metrics"
+	"github.com/mattmattox/supportability-collector/modules/report"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
 )
 
 var log = logging.SetupLogging()
 
-func CollectData() {
+// Options select what a collection gathers and where the bundle is
+// written. Clusters, when set, replaces the Rancher clusters of the
+// settings. Progress, when set, is called before every collector runs.
+// Suffix, when set, is appended to the bundle root to name the bundle.
+type Options struct {
+	Profile   string
+	Clusters  []string
+	OutputDir string
+	Suffix    string
+	Progress  func(step string, done int, total int)
+}
+
+// fatalCollection is raised instead of exiting the process when a
+// collector calls log.Fatal and RecoverFatal is in effect.
+type fatalCollection struct{}
 
-	// Create temporary directory for data collection
-	tempDirRoot := CreateTmpDir()
+// RecoverFatal makes log.Fatal in the collectors abort only the running
+// collection instead of the process, for long running modes where exiting
+// would take the server down.
+func RecoverFatal() {
+	log.ExitFunc = func(int) {
+		panic(fatalCollection{})
+	}
+}
+
+func CollectData(settings cli.Cli) {
+	profile := settings.Profile
+	if profile == "" {
+		profile = DefaultProfile
+	}
+	metrics.CollectionsTotal.WithLabelValues(profile).Inc()
+	tarFile, err := Collect(settings, Options{Profile: profile})
+	if err != nil {
+		metrics.RecordCollection(profile, tarFile, err)
+		log.Fatalf("Collection failed - Error %s", err)
+	}
+
+	// Upload the bundle when a destination is configured
+	if settings.UploadDestination == "" {
+		metrics.RecordCollection(profile, tarFile, nil)
+		return
+	}
+	log.Infoln("Uploading bundle")
+	location, err := Upload(tarFile, settings.UploadDestination)
+	metrics.RecordCollection(profile, tarFile, err)
+	if err != nil {
+		log.Fatalf("Bundle upload failed - Error %s", err)
+	}
+	log.Infoln("Bundle uploaded to " + location)
+}
+
+// Collect runs the collectors of a profile, analyzes the result and
+// returns the path of the bundle. A panic in a collector fails the
+// collection instead of taking the process down.
+func Collect(settings cli.Cli, options Options) (tarFile string, err error) {
+	if len(options.Clusters) > 0 {
+		settings.Clusters = options.Clusters
+	}
+	collectors, err := ProfileCollectors(settings, options.Profile)
+	if err != nil {
+		return "", err
+	}
+	profile := options.Profile
+	if profile == "" {
+		profile = DefaultProfile
+	}
+	encryption, err := LoadEncryption(settings)
+	if err != nil {
+		return "", fmt.Errorf("encryption recipients could not be loaded: %w", err)
+	}
+
+	signer, err := manifest.LoadSigner(settings)
+	if err != nil {
+		return "", fmt.Errorf("signing key could not be loaded: %w", err)
+	}
+
+	recorder := errorsHook.record()
+	defer errorsHook.stop(recorder)
+
+	var sink Sink
+	var scratch string
+	defer func() {
+		if r := recover(); r != nil {
+			if sink != nil {
+				sink.Abort()
+			}
+			os.RemoveAll(scratch)
+			if _, ok := r.(fatalCollection); ok {
+				tarFile, err = "", fmt.Errorf("collection aborted: %s", lastEntry(recorder))
+				return
+			}
+			log.Errorf("Collection panicked - Error %v\n%s", r, debug.Stack())
+			tarFile, err = "", fmt.Errorf("collection panicked: %v", r)
+		}
+	}()
+
+	// Open the bundle the collectors write into
+	started := time.Now().UTC()
+	var root string
+	sink, root, scratch, tarFile, err = openSink(settings, options, encryption, started)
+	if err != nil {
+		os.RemoveAll(scratch)
+		return "", fmt.Errorf("bundle creation failed: %w", err)
+	}
+	bundle := openBundle(root, sink)
+	defer bundle.close()
 
 	// Create timestamp file
-	TimestampFile(tempDirRoot)
+	TimestampFile(root)
+
+	for i, collector := range collectors {
+		if options.Progress != nil {
+			options.Progress(collector.Name, i, len(collectors))
+		}
+		start := time.Now()
+		collector.Collect(settings, root)
+		metrics.CollectorDuration.WithLabelValues(collector.Name).Observe(time.Since(start).Seconds())
+	}
+	WriteCollectionErrors(root, recorder)
+
+	// Analyze the collected objects, which were loaded as they were written
+	if options.Progress != nil {
+		options.Progress("analyze", len(collectors), len(collectors))
+	}
+	log.Infoln("Analyzing collected data")
+	analysis, err := analyze.Analyze(bundle.bundle, analyze.Options{
+		RulesDir:          settings.AnalyzeRulesDir,
+		SupportMatrixFile: settings.SupportMatrixFile,
+		UpgradeTarget:     settings.UpgradeTarget,
+	})
+	if err != nil {
+		log.Warningf("Analysis failed - Error %s", err)
+	} else {
+		log.Infof("Analysis complete: %d finding(s) from %d rule(s) over %d object(s)", len(analysis.Findings), analysis.Rules, analysis.Objects)
+	}
+	countObjects(bundle.bundle)
 
-	CollectRancherData(tempDirRoot)
-	CollectUpstreamCluster(tempDirRoot)
+	// Summarize the bundle for humans
+	err = writeReport(root, bundle.bundle, analysis)
+	if err != nil {
+		log.Warningf("HTML report generation failed - Error %s", err)
+	}
+	if analysis != nil {
+		err = analyze.WriteReportFiles(bundle.create, analysis)
+		if err != nil {
+			log.Warningf("Analysis report write failed - Error %s", err)
+		}
+	}
+
+	// Record the checksum of every file, signed when configured
+	bundleManifest := manifest.New(profile, bundle.checksums())
+	bundleManifest.Cluster = settings.ClusterName
+	bundleManifest.Format = settings.ArchiveFormat
+	err = manifest.Write(bundle.create, bundleManifest, signer)
+	if err != nil {
+		sink.Abort()
+		os.RemoveAll(scratch)
+		return "", fmt.Errorf("bundle manifest creation failed: %w", err)
+	}
 
-	// Tar up the temporary directory
-	log.Infoln("Tarring up temporary directory")
-	tarFile := tempDirRoot + ".tar.gz"
-	err := TarGz(tempDirRoot, tarFile)
+	err = sink.Close()
+	os.RemoveAll(scratch)
 	if err != nil {
-		log.Fatalln("Temporary directory tar failed")
+		return "", fmt.Errorf("bundle archive failed: %w", err)
+	}
+	log.Infoln("Bundle: " + tarFile)
+	return tarFile, nil
+}
+
+// openSink opens the sink of a new bundle started at started, as
+// configured by the archive format. It returns the directory the
+// collectors write below, the scratch directory to remove once the bundle
+// is done and the path of the bundle. Archives and directories are named
+// after the bundle root followed by the suffix of options.
+func openSink(settings cli.Cli, options Options, encryption Encryption, started time.Time) (sink Sink, root string, scratch string, path string, err error) {
+	bundleRoot := BundleRoot(settings.ClusterName, started)
+	if settings.ArchiveFormat == FormatDir {
+		outputDir := options.OutputDir
+		if outputDir == "" {
+			outputDir = os.TempDir()
+		}
+		root = filepath.Join(outputDir, bundleRoot+options.Suffix)
+		err = os.Mkdir(root, 0755)
+		if err != nil {
+			return nil, "", "", "", err
+		}
+		log.Infoln("Writing bundle to directory " + root)
+		return NewDirSink(root), root, "", root, nil
 	}
-	log.Infoln("Successfully tarred up temporary directory")
-	log.Infoln("Tar: " + tarFile)
 
-	// Clean up temporary directory
-	log.Infoln("Cleaning up temporary directory")
-	err = os.RemoveAll(tempDirRoot)
+	scratch = CreateTmpDir()
+	outputDir := filepath.Dir(scratch)
+	if options.OutputDir != "" {
+		outputDir = options.OutputDir
+	}
+	extension := Extension(settings.ArchiveFormat)
+	if encryption != nil {
+		extension += encryption.Extension()
+		log.Infof("Encrypting bundle with %s", strings.TrimPrefix(encryption.Extension(), "."))
+	}
+	path = filepath.Join(outputDir, bundleRoot+options.Suffix+extension)
+	sink, err = NewArchiveSink(path, ArchiveOptions{
+		Format:      settings.ArchiveFormat,
+		Compression: Compression{Level: settings.ArchiveCompressionLevel, Threads: settings.ArchiveCompressionThreads},
+		Encryption:  encryption,
+		BufferSize:  settings.ArchiveBufferSize,
+		Scratch:     scratch,
+		Root:        bundleRoot,
+		ModTime:     started,
+	})
 	if err != nil {
-		log.Fatalln("Temporary directory cleanup failed")
+		return nil, "", scratch, "", err
 	}
-	log.Infoln("Temporary directory cleanup successful")
+	log.Infoln("Streaming bundle to " + path)
+	return sink, scratch, scratch, path, nil
+}
+
+// BundleTimeFormat is the layout of the timestamp of bundle roots.
+const BundleTimeFormat = "20060102T150405Z"
 
-	//Upload tar file to S3
-	log.Infoln("Uploading tar file to S3")
-	err = UploadToS3(tarFile)
+// BundleRoot returns the top-level directory of a bundle of cluster
+// started at started, supportbundle-<cluster>-<timestamp>.
+func BundleRoot(cluster string, started time.Time) string {
+	return BundlePrefix(cluster) + started.UTC().Format(BundleTimeFormat)
+}
+
+// BundlePrefix returns the part of the bundle roots of cluster preceding
+// the timestamp. The cluster name is lower cased and anything but letters,
+// digits, dots and dashes is replaced by dashes so the name is safe on
+// every filesystem.
+func BundlePrefix(cluster string) string {
+	cluster = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '.' || r == '-' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(cluster))
+	return "supportbundle-" + cluster + "-"
+}
+
+// writeReport renders the HTML report into the bundle.
+func writeReport(root string, bundle *analyze.Bundle, analysis *analyze.Report) error {
+	log.Infoln("Generating HTML report")
+	f, err := createFile(root + "/report.html")
 	if err != nil {
-		log.Fatalln("Tar file upload to S3 failed")
+		return err
 	}
-	log.Infoln("Tar file upload to S3 successful")
+	err = report.Render(f, report.Build(bundle, analysis))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// countObjects adds the objects of a collected bundle to the objects
+// collected metric.
+func countObjects(bundle *analyze.Bundle) {
+	kinds := map[string]int{}
+	for _, object := range bundle.Objects {
+		kinds[object.Kind()]++
+	}
+	for kind, count := range kinds {
+		metrics.ObjectsCollected.WithLabelValues(kind).Add(float64(count))
+	}
+}
+
+// lastEntry returns the last error recorded, which is the fatal one when
+// a collection is aborted.
+func lastEntry(recorder *errorRecorder) string {
+	errorsHook.mu.Lock()
+	defer errorsHook.mu.Unlock()
+	if len(recorder.entries) == 0 {
+		return "unknown error"
+	}
+	return recorder.entries[len(recorder.entries)-1]
 }
 
+// CreateTmpDir creates the scratch directory of a collection, which only
+// holds the files too large for the archive buffer while they are written.
 func CreateTmpDir() string {
 	log.Infoln("Creating temporary directory for data collection")
 	tempDirRoot, err := os.MkdirTemp("", "supportability-")
@@ -65,73 +313,49 @@ func CreateTmpDir() string {
 
 func TimestampFile(dir string) {
 	timestampFile := dir + "/timestamp"
-	f, err := os.Create(timestampFile)
+	f, err := createFile(timestampFile)
 	if err != nil {
 		log.Fatalln("Timestamp file creation failed")
 	}
-	fmt.Fprint(f, time.Now().Unix())
-	f.Close()
+	_, err = fmt.Fprint(f, time.Now().Unix())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatalln("Timestamp file write failed")
+	}
 	log.Infoln("Timestamp file created successfully")
 }
 
-func TarGz(src string, dst string) error {
-	// Create a new file for the tar.gz archive
-	out, err := os.Create(dst)
+// WriteYaml marshals obj using its JSON field names, so Kubernetes objects keep
+// their usual apiVersion/metadata/spec layout, and writes it to path.
+// Typed objects returned by client-go have an empty TypeMeta, so their
+// apiVersion and kind are filled in from the client-go scheme first.
+func WriteYaml(path string, obj interface{}) error {
+	if object, ok := obj.(runtime.Object); ok && object.GetObjectKind().GroupVersionKind().Kind == "" {
+		if gvks, _, err := scheme.Scheme.ObjectKinds(object); err == nil && len(gvks) > 0 {
+			object = object.DeepCopyObject()
+			object.GetObjectKind().SetGroupVersionKind(gvks[0])
+			obj = object
+		}
+	}
+	data, err := yaml.Marshal(obj)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
-
-	// Create a new gzip writer
-	gz := gzip.NewWriter(out)
-	defer gz.Close()
-
-	// Create a new tar writer
-	tw := tar.NewWriter(gz)
-	defer tw.Close()
-
-	// Walk the source directory and add each file to the tar.gz archive
-	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
-		if err != nil {
-			return err
-		}
-
-		// Create a new tar header for the current file
-		header, err := tar.FileInfoHeader(info, info.Name())
-		if err != nil {
-			return err
-		}
-
-		// Set the name of the current file in the tar header
-		header.Name = path
-
-		// Write the tar header
-		if err := tw.WriteHeader(header); err != nil {
-			return err
-		}
-
-		// Open the current file
-		f, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-
-		// Copy the contents of the file to the tar.gz archive
-		if _, err := io.Copy(tw, f); err != nil {
-			return err
-		}
-		return nil
-	})
+	return writeFile(path, data, 0644)
 }
 
-func UploadToS3(tarFile string) error {
-	// TODO
-	return nil
+// IsBundle reports whether a file name is the name of a bundle archive,
+// encrypted or not.
+func IsBundle(name string) bool {
+	for _, extension := range EncryptionExtensions {
+		name = strings.TrimSuffix(name, extension)
+	}
+	for _, extension := range BundleExtensions {
+		if strings.HasSuffix(name, extension) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,59 @@
+package collect
+
+import (
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	"github.com/mattmattox/supportability-collector/modules/kubernetes"
+)
+
+func ConfigMapsDir(dir string) string {
+	configMapsDir := dir + "/configmaps"
+	err := mkdirAll(configMapsDir, 0755)
+	if err != nil {
+		log.Fatalln("ConfigMaps directory creation failed")
+	}
+	return configMapsDir
+}
+
+// CollectConfigMaps saves the ConfigMaps of the collected namespaces with
+// every value passed through the redaction rules.
+func CollectConfigMaps(settings cli.Cli, tempDirRoot string) {
+	log.Infoln("Collecting ConfigMaps")
+
+	configMapsDir := ConfigMapsDir(tempDirRoot)
+
+	client, err := kubernetes.GetClient()
+	if err != nil {
+		log.Fatalln("Failed to connect to upstream cluster")
+	}
+
+	for _, namespace := range settings.Namespaces {
+		configmaps, err := kubernetes.GetConfigMaps(client, namespace)
+		if err != nil {
+			log.Warningf("List of configmaps in %s failed - Error %s", namespace, err)
+			continue
+		}
+		if len(configmaps) == 0 {
+			continue
+		}
+		namespaceDir := configMapsDir + "/" + namespace
+		err = mkdirAll(namespaceDir, 0755)
+		if err != nil {
+			log.Warningf("Folder creation for configmaps in %s failed - Error %s", namespace, err)
+		}
+		for _, configmap := range configmaps {
+			log.Infof("Grabbing YAML for configmap: %s/%s", namespace, configmap)
+			configmapData, err := kubernetes.GetConfigMapYaml(client, namespace, configmap)
+			if err != nil {
+				log.Warningf("ConfigMap YAML collection failed - Error %s", err)
+				continue
+			}
+			RedactConfigMap(configmapData)
+			err = WriteYaml(namespaceDir+"/"+configmap+".yaml", configmapData)
+			if err != nil {
+				log.Warningf("ConfigMap YAML file write failed - Error %s", err)
+			}
+		}
+	}
+
+	log.Infoln("ConfigMap collection complete")
+}
@@ -0,0 +1,165 @@
+package collect
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/mattmattox/supportability-collector/modules/analyze"
+	"github.com/mattmattox/supportability-collector/modules/kubernetes"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// deprecatedAPIsMetric is the apiserver metric counting requests to
+// deprecated APIs since the apiserver started.
+const deprecatedAPIsMetric = "apiserver_requested_deprecated_apis"
+
+func DeprecatedAPIsDir(dir string) string {
+	deprecatedAPIsDir := dir + "/deprecated-apis"
+	err := mkdirAll(deprecatedAPIsDir, 0755)
+	if err != nil {
+		log.Fatalln("Deprecated APIs directory creation failed")
+	}
+	return deprecatedAPIsDir
+}
+
+// CollectDeprecatedAPIs saves what the analyzer needs to find deprecated
+// API usage that isn't visible in the collected objects: the server
+// version, the apiserver's deprecated API request metric and the resources
+// of every deployed Helm release.
+func CollectDeprecatedAPIs(tempDirRoot string) {
+	log.Infoln("Collecting deprecated API usage")
+
+	deprecatedAPIsDir := DeprecatedAPIsDir(tempDirRoot)
+
+	client, err := kubernetes.GetClient()
+	if err != nil {
+		log.Fatalln("Failed to connect to upstream cluster")
+	}
+
+	serverVersion, err := kubernetes.GetServerVersion(client)
+	if err != nil {
+		log.Warningf("Server version collection failed - Error %s", err)
+	} else {
+		data, _ := json.MarshalIndent(serverVersion, "", "  ")
+		err = writeFile(deprecatedAPIsDir+"/server-version.json", data, 0644)
+		if err != nil {
+			log.Warningf("Server version file write failed - Error %s", err)
+		}
+	}
+
+	metrics, err := kubernetes.GetAPIServerMetrics(client)
+	if err != nil {
+		log.Warningf("Apiserver metrics collection failed - Error %s", err)
+	} else {
+		err = writeFile(deprecatedAPIsDir+"/"+deprecatedAPIsMetric+".txt", filterMetric(metrics, deprecatedAPIsMetric), 0644)
+		if err != nil {
+			log.Warningf("Apiserver deprecated API metric file write failed - Error %s", err)
+		}
+	}
+
+	secrets, err := kubernetes.GetHelmReleaseSecrets(client)
+	if err != nil {
+		log.Warningf("List of Helm releases failed - Error %s", err)
+	}
+	releases := []analyze.HelmRelease{}
+	for _, secret := range secrets {
+		log.Infof("Reading Helm release: %s/%s", secret.Namespace, secret.Labels["name"])
+		release, err := helmRelease(secret)
+		if err != nil {
+			log.Warningf("Helm release %s/%s could not be decoded - Error %s", secret.Namespace, secret.Name, err)
+			continue
+		}
+		releases = append(releases, release)
+	}
+	data, err := json.MarshalIndent(releases, "", "  ")
+	if err != nil {
+		log.Warningf("Helm release list marshalling failed - Error %s", err)
+		return
+	}
+	err = writeFile(deprecatedAPIsDir+"/helm-releases.json", data, 0644)
+	if err != nil {
+		log.Warningf("Helm release list file write failed - Error %s", err)
+	}
+
+	log.Infoln("Deprecated API usage collection complete")
+}
+
+// filterMetric keeps the samples and help of one metric from the
+// Prometheus text format.
+func filterMetric(metrics []byte, name string) []byte {
+	var filtered bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(metrics))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, name+"{") || strings.HasPrefix(line, name+" ") ||
+			strings.HasPrefix(line, "# HELP "+name+" ") || strings.HasPrefix(line, "# TYPE "+name+" ") {
+			filtered.WriteString(line + "\n")
+		}
+	}
+	return filtered.Bytes()
+}
+
+// helmRelease decodes a Helm 3 release Secret and keeps the identity of
+// the release and of the resources in its manifest. Values are dropped as
+// they often carry credentials.
+func helmRelease(secret v1.Secret) (analyze.HelmRelease, error) {
+	var release analyze.HelmRelease
+	data, err := base64.StdEncoding.DecodeString(string(secret.Data["release"]))
+	if err != nil {
+		return release, err
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return release, err
+		}
+		data, err = io.ReadAll(gz)
+		if err != nil {
+			return release, err
+		}
+	}
+
+	var helm struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+		Version   int    `json:"version"`
+		Manifest  string `json:"manifest"`
+		Chart     struct {
+			Metadata struct {
+				Name       string `json:"name"`
+				Version    string `json:"version"`
+				AppVersion string `json:"appVersion"`
+			} `json:"metadata"`
+		} `json:"chart"`
+	}
+	err = json.Unmarshal(data, &helm)
+	if err != nil {
+		return release, err
+	}
+
+	release = analyze.HelmRelease{
+		Name:         helm.Name,
+		Namespace:    helm.Namespace,
+		Revision:     helm.Version,
+		Chart:        helm.Chart.Metadata.Name,
+		ChartVersion: helm.Chart.Metadata.Version,
+		AppVersion:   helm.Chart.Metadata.AppVersion,
+		Resources:    []analyze.HelmResource{},
+	}
+	for _, document := range strings.Split(helm.Manifest, "\n---") {
+		var resource analyze.HelmResource
+		err := yaml.Unmarshal([]byte(document), &resource)
+		if err != nil || resource.APIVersion == "" || resource.Kind == "" {
+			continue
+		}
+		release.Resources = append(release.Resources, resource)
+	}
+	return release, nil
+}
@@ -0,0 +1,135 @@
+package collect
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/mattmattox/supportability-collector/modules/cli"
+)
+
+// Encryption encrypts bundles as they are written, so the plaintext
+// archive never reaches the disk.
+type Encryption interface {
+	// Extension is appended to the name of encrypted bundles.
+	Extension() string
+	// Encrypt returns a writer encrypting into w. Closing it flushes the
+	// encryption but doesn't close w.
+	Encrypt(w io.Writer) (io.WriteCloser, error)
+}
+
+const (
+	ageExtension = ".age"
+	pgpExtension = ".gpg"
+)
+
+// EncryptionExtensions are the extensions of encrypted bundles.
+var EncryptionExtensions = []string{ageExtension, pgpExtension}
+
+// ageHeader starts every age file.
+var ageHeader = []byte("age-encryption.org/")
+
+type ageEncryption struct {
+	recipients []age.Recipient
+}
+
+func (e ageEncryption) Extension() string {
+	return ageExtension
+}
+
+func (e ageEncryption) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	return age.Encrypt(w, e.recipients...)
+}
+
+type pgpEncryption struct {
+	recipients openpgp.EntityList
+}
+
+func (e pgpEncryption) Extension() string {
+	return pgpExtension
+}
+
+func (e pgpEncryption) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	return openpgp.Encrypt(w, e.recipients, nil, &openpgp.FileHints{IsBinary: true}, nil)
+}
+
+// LoadEncryption returns the encryption configured by settings, or nil
+// when bundles aren't encrypted.
+func LoadEncryption(settings cli.Cli) (Encryption, error) {
+	switch {
+	case len(settings.AgeRecipients) > 0:
+		var recipients []age.Recipient
+		for _, key := range settings.AgeRecipients {
+			recipient, err := age.ParseX25519Recipient(strings.TrimSpace(key))
+			if err != nil {
+				return nil, err
+			}
+			recipients = append(recipients, recipient)
+		}
+		return ageEncryption{recipients: recipients}, nil
+	case settings.PGPRecipientsFile != "":
+		f, err := os.Open(settings.PGPRecipientsFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		recipients, err := openpgp.ReadArmoredKeyRing(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", settings.PGPRecipientsFile, err)
+		}
+		return pgpEncryption{recipients: recipients}, nil
+	}
+	return nil, nil
+}
+
+// Decrypt returns a reader decrypting an age or OpenPGP encrypted bundle
+// with the age identities or the armored OpenPGP private keys in
+// identityFile. passphrase unlocks protected OpenPGP keys.
+func Decrypt(r io.Reader, identityFile string, passphrase []byte) (io.Reader, error) {
+	identities, err := os.ReadFile(identityFile)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewReader(r)
+	header, _ := buffered.Peek(len(ageHeader))
+	if bytes.Equal(header, ageHeader) {
+		parsed, err := age.ParseIdentities(bytes.NewReader(identities))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", identityFile, err)
+		}
+		return age.Decrypt(buffered, parsed...)
+	}
+
+	keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(identities))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", identityFile, err)
+	}
+	prompted := false
+	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		if len(passphrase) == 0 {
+			return nil, errors.New("private key is protected, a passphrase is required")
+		}
+		if prompted {
+			return nil, errors.New("private key passphrase is incorrect")
+		}
+		prompted = true
+		for _, key := range keys {
+			if key.PrivateKey != nil && key.PrivateKey.Encrypted {
+				key.PrivateKey.Decrypt(passphrase)
+			}
+		}
+		return nil, nil
+	}
+	message, err := openpgp.ReadMessage(buffered, keys, prompt, &packet.Config{})
+	if err != nil {
+		return nil, err
+	}
+	return message.UnverifiedBody, nil
+}
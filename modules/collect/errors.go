@@ -0,0 +1,94 @@
+package collect
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// collectionErrors records every warning and error logged by the collectors
+// so they can be shipped in the bundle as collection-errors.txt. Each
+// running collection has its own recorder. The collectors share one
+// logger but run on the goroutine of their collection, so entries are
+// recorded for the collection of the goroutine logging them and
+// overlapping collections don't see each other's errors.
+type collectionErrors struct {
+	mu        sync.Mutex
+	recorders map[uint64]*errorRecorder
+}
+
+type errorRecorder struct {
+	goroutine uint64
+	entries   []string
+}
+
+var errorsHook = &collectionErrors{recorders: map[uint64]*errorRecorder{}}
+
+func init() {
+	log.AddHook(errorsHook)
+}
+
+func (h *collectionErrors) Levels() []logrus.Level {
+	return []logrus.Level{logrus.WarnLevel, logrus.ErrorLevel, logrus.FatalLevel}
+}
+
+func (h *collectionErrors) Fire(entry *logrus.Entry) error {
+	goroutine := goroutineID()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	recorder, ok := h.recorders[goroutine]
+	if !ok {
+		return nil
+	}
+	line := entry.Time.UTC().Format("2006-01-02 15:04:05") + " " + entry.Level.String() + " " + strings.TrimSpace(entry.Message)
+	recorder.entries = append(recorder.entries, line)
+	return nil
+}
+
+// record starts recording the errors of the collection running on the
+// calling goroutine.
+func (h *collectionErrors) record() *errorRecorder {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	recorder := &errorRecorder{goroutine: goroutineID()}
+	h.recorders[recorder.goroutine] = recorder
+	return recorder
+}
+
+func (h *collectionErrors) stop(recorder *errorRecorder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.recorders, recorder.goroutine)
+}
+
+// goroutineID returns the id of the calling goroutine, read from the
+// "goroutine <id> [running]:" header of its stack trace.
+func goroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	fields := bytes.Fields(buf)
+	if len(fields) < 2 {
+		return 0
+	}
+	id, _ := strconv.ParseUint(string(fields[1]), 10, 64)
+	return id
+}
+
+// WriteCollectionErrors writes the warnings and errors recorded since the
+// collection started to collection-errors.txt.
+func WriteCollectionErrors(dir string, recorder *errorRecorder) {
+	errorsHook.mu.Lock()
+	content := strings.Join(recorder.entries, "\n")
+	errorsHook.mu.Unlock()
+	if content != "" {
+		content += "\n"
+	}
+	err := writeFile(dir+"/collection-errors.txt", []byte(content), 0644)
+	if err != nil {
+		log.Warningf("Collection errors file write failed - Error %s", err)
+	}
+}
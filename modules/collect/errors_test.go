@@ -0,0 +1,54 @@
+package collect
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCollectionErrorsOverlapping(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	recorded := make([][]string, 3)
+	var wg sync.WaitGroup
+	for i := range recorded {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			recorder := errorsHook.record()
+			defer errorsHook.stop(recorder)
+			for j := 0; j < 50; j++ {
+				log.Warningf("collection %d warning %d", i, j)
+				log.Infof("collection %d progress %d", i, j)
+			}
+			log.Errorf("collection %d failed", i)
+			errorsHook.mu.Lock()
+			recorded[i] = append(recorded[i], recorder.entries...)
+			errorsHook.mu.Unlock()
+			recorded[i] = append(recorded[i], lastEntry(recorder))
+		}(i)
+	}
+	log.Warningln("logged outside of any collection")
+	wg.Wait()
+
+	for i, entries := range recorded {
+		if len(entries) != 52 {
+			t.Errorf("collection %d recorded %d entries, want 52", i, len(entries))
+		}
+		for _, entry := range entries {
+			if !strings.Contains(entry, fmt.Sprintf("collection %d ", i)) {
+				t.Errorf("collection %d recorded %q", i, entry)
+			}
+		}
+		if last := entries[len(entries)-1]; !strings.HasSuffix(last, fmt.Sprintf("error collection %d failed", i)) {
+			t.Errorf("collection %d aborted with %q", i, last)
+		}
+	}
+	if len(errorsHook.recorders) != 0 {
+		t.Errorf("%d recorders left", len(errorsHook.recorders))
+	}
+}
@@ -0,0 +1,344 @@
+package collect
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	"github.com/mattmattox/supportability-collector/modules/etcd"
+	"github.com/mattmattox/supportability-collector/modules/kubernetes"
+	v1 "k8s.io/api/core/v1"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// EtcdMemberData is what was gathered from one etcd member, either with
+// the etcd v3 client or from the node diagnostics archive.
+type EtcdMemberData struct {
+	Source     string
+	MemberList *etcd.MemberListResponse
+	Status     *etcd.StatusResponse
+	Alarms     *etcd.AlarmResponse
+	Errors     []string
+}
+
+func EtcdDir(dir string) string {
+	etcdDir := dir + "/etcd"
+	err := mkdirAll(etcdDir, 0755)
+	if err != nil {
+		log.Fatalln("etcd directory creation failed")
+	}
+	return etcdDir
+}
+
+func CollectEtcd(settings cli.Cli, tempDirRoot string) {
+	log.Infoln("Collecting etcd information")
+
+	etcdDir := EtcdDir(tempDirRoot)
+
+	config, err := kubernetes.GetConfig()
+	if err != nil {
+		log.Fatalln("Failed to connect to upstream cluster")
+	}
+	client, err := kubernetes.GetClient()
+	if err != nil {
+		log.Fatalln("Failed to connect to upstream cluster")
+	}
+
+	pods := EtcdPods(client, etcdDir)
+	EtcdNodes(client, etcdDir)
+
+	var members []EtcdMemberData
+	if len(settings.EtcdEndpoints) > 0 {
+		members = EtcdFromEndpoints(settings, etcdDir)
+	} else {
+		members = EtcdFromNodeDiagnostics(tempDirRoot + "/nodes")
+		if len(members) == 0 {
+			members = EtcdFromPods(pods, PortForwarder(config, client), settings, etcdDir)
+		}
+	}
+
+	err = EtcdWriteSummary(etcdDir+"/etcd-summary.txt", members)
+	if err != nil {
+		log.Warningf("etcd summary write failed - Error %s", err)
+	}
+
+	log.Infoln("etcd collection complete")
+}
+
+// EtcdPods saves the etcd static pods run by RKE2 and kubeadm style
+// clusters along with their logs, and returns them.
+func EtcdPods(client k8s.Interface, dir string) []v1.Pod {
+	pods, err := kubernetes.GetPodsBySelector(client, "kube-system", "component=etcd")
+	if err != nil {
+		log.Warningf("List of etcd pods failed - Error %s", err)
+		return nil
+	}
+	if len(pods) == 0 {
+		log.Infoln("No etcd pods found, etcd is embedded or runs outside of Kubernetes")
+		return nil
+	}
+	podDir := dir + "/pods"
+	logDir := dir + "/logs"
+	for _, d := range []string{podDir, logDir} {
+		err = mkdirAll(d, 0755)
+		if err != nil {
+			log.Warningf("Folder creation for etcd pods failed - Error %s", err)
+		}
+	}
+	for i := range pods {
+		pod := &pods[i]
+		log.Infof("Grabbing YAML and logs for etcd pod: %s", pod.Name)
+		err = WriteYaml(podDir+"/"+pod.Name+".yaml", pod)
+		if err != nil {
+			log.Warningf("etcd pod YAML file write failed - Error %s", err)
+		}
+		logs, err := kubernetes.GetPodLogs(client, pod.Namespace, pod.Name, "")
+		if err != nil {
+			log.Warningf("etcd pod log collection failed - Error %s", err)
+			continue
+		}
+		err = writeFile(logDir+"/"+pod.Name+".log", logs, 0644)
+		if err != nil {
+			log.Warningf("etcd pod log file write failed - Error %s", err)
+		}
+	}
+	return pods
+}
+
+// EtcdNodes records which nodes carry the etcd role. For K3s and RKE1 this
+// is the only place etcd shows up in the Kubernetes API.
+func EtcdNodes(client k8s.Interface, dir string) {
+	nodes, err := kubernetes.GetNodesBySelector(client, "node-role.kubernetes.io/etcd")
+	if err != nil {
+		log.Warningf("List of etcd nodes failed - Error %s", err)
+		return
+	}
+	var lines []string
+	for _, node := range nodes {
+		address := ""
+		for _, nodeAddress := range node.Status.Addresses {
+			if nodeAddress.Type == "InternalIP" {
+				address = nodeAddress.Address
+			}
+		}
+		lines = append(lines, node.Name+"\t"+address)
+	}
+	err = writeFile(dir+"/etcd-nodes.txt", []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	if err != nil {
+		log.Warningf("etcd nodes file write failed - Error %s", err)
+	}
+}
+
+// EtcdFromEndpoints queries each configured endpoint directly, for example
+// a kubectl port-forward to an etcd pod, and saves the raw responses.
+func EtcdFromEndpoints(settings cli.Cli, dir string) []EtcdMemberData {
+	tlsConfig, err := etcd.TLSConfig(settings.EtcdCAFile, settings.EtcdCertFile, settings.EtcdKeyFile)
+	if err != nil {
+		log.Warningf("etcd TLS configuration failed - Error %s", err)
+		return nil
+	}
+	var members []EtcdMemberData
+	for _, endpoint := range settings.EtcdEndpoints {
+		log.Infof("Querying etcd endpoint: %s", endpoint)
+		source := endpoint
+		if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+			source = u.Host
+		}
+		endpointDir := dir + "/endpoints/" + strings.ReplaceAll(source, ":", "_")
+		err = mkdirAll(endpointDir, 0755)
+		if err != nil {
+			log.Warningf("Folder creation for etcd endpoint failed - Error %s", err)
+		}
+		members = append(members, etcdQuery(endpoint, tlsConfig, source, endpointDir))
+	}
+	return members
+}
+
+// PortForward forwards a local port to port of a pod until stop is called
+// and returns the local endpoint, such as 127.0.0.1:40123.
+type PortForward func(pod v1.Pod, port int) (endpoint string, stop func(), err error)
+
+// PortForwarder port-forwards to pods through the apiserver.
+func PortForwarder(config *rest.Config, client k8s.Interface) PortForward {
+	return func(pod v1.Pod, port int) (string, func(), error) {
+		local, stop, err := kubernetes.PortForwardPod(config, client, pod.Namespace, pod.Name, port)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("127.0.0.1:%d", local), stop, nil
+	}
+}
+
+// EtcdFromPods queries every etcd pod through a port-forward to its client
+// port, for when neither ETCD_ENDPOINTS nor node diagnostics are there. The
+// etcd certificates of ETCD_CA_FILE, ETCD_CERT_FILE and ETCD_KEY_FILE are
+// used as for endpoints, etcd serves 127.0.0.1 so they verify.
+func EtcdFromPods(pods []v1.Pod, forward PortForward, settings cli.Cli, dir string) []EtcdMemberData {
+	if len(pods) == 0 {
+		log.Infoln("No etcd pods to port-forward to, enable NODE_DIAGNOSTICS or set ETCD_ENDPOINTS for etcd member data")
+		return nil
+	}
+	tlsConfig, err := etcd.TLSConfig(settings.EtcdCAFile, settings.EtcdCertFile, settings.EtcdKeyFile)
+	if err != nil {
+		log.Warningf("etcd TLS configuration failed - Error %s", err)
+		return nil
+	}
+	var members []EtcdMemberData
+	for _, pod := range pods {
+		log.Infof("Querying etcd pod %s through a port-forward", pod.Name)
+		podDir := dir + "/endpoints/" + pod.Name
+		err = mkdirAll(podDir, 0755)
+		if err != nil {
+			log.Warningf("Folder creation for etcd pod failed - Error %s", err)
+		}
+		endpoint, stop, err := forward(pod, etcdClientPort)
+		if err != nil {
+			members = append(members, EtcdMemberData{Source: pod.Name, Errors: []string{"port-forward: " + err.Error()}})
+			continue
+		}
+		members = append(members, etcdQuery("https://"+endpoint, tlsConfig, pod.Name, podDir))
+		stop()
+	}
+	return members
+}
+
+// etcdClientPort is the client port etcd pods listen on.
+const etcdClientPort = 2379
+
+// etcdTimeout bounds connecting to an etcd member and each request to it.
+var etcdTimeout = 10 * time.Second
+
+// etcdQuery saves the member list, status and alarms of the member at
+// endpoint in dir, in the format of the etcd JSON gateway.
+func etcdQuery(endpoint string, tlsConfig *tls.Config, source string, dir string) EtcdMemberData {
+	member := EtcdMemberData{Source: source}
+	client, err := etcd.NewClient(endpoint, tlsConfig, etcdTimeout)
+	if err != nil {
+		member.Errors = append(member.Errors, "connect: "+err.Error())
+		return member
+	}
+	defer client.Close()
+	if response, err := client.MemberList(); err != nil {
+		member.Errors = append(member.Errors, "member list: "+err.Error())
+	} else {
+		member.MemberList = response
+		etcdWriteResponse(dir+"/member-list.json", response)
+	}
+	if response, err := client.Status(); err != nil {
+		member.Errors = append(member.Errors, "status: "+err.Error())
+	} else {
+		member.Status = response
+		etcdWriteResponse(dir+"/status.json", response)
+	}
+	if response, err := client.AlarmList(); err != nil {
+		member.Errors = append(member.Errors, "alarm list: "+err.Error())
+	} else {
+		member.Alarms = response
+		etcdWriteResponse(dir+"/alarm-list.json", response)
+	}
+	return member
+}
+
+func etcdWriteResponse(path string, response interface{}) {
+	data, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		log.Warningf("etcd response marshalling failed - Error %s", err)
+		return
+	}
+	err = writeFile(path, data, 0644)
+	if err != nil {
+		log.Warningf("etcd response file write failed - Error %s", err)
+	}
+}
+
+// EtcdFromNodeDiagnostics reads the responses the node diagnostics pods
+// gathered on each etcd node.
+func EtcdFromNodeDiagnostics(nodesDir string) []EtcdMemberData {
+	nodes, err := readDirNames(nodesDir)
+	if err != nil {
+		log.Infoln("No node diagnostics available, port-forwarding to the etcd pods")
+		return nil
+	}
+	var members []EtcdMemberData
+	for _, node := range nodes {
+		etcdDir := nodesDir + "/" + node + "/etcd"
+		if _, err := readDirNames(etcdDir); err != nil {
+			continue
+		}
+		member := EtcdMemberData{Source: node}
+		if data, err := readFile(etcdDir + "/member-list.json"); err == nil {
+			if member.MemberList, err = etcd.ParseMemberList(data); err != nil {
+				member.Errors = append(member.Errors, "member list: "+strings.TrimSpace(string(data)))
+			}
+		}
+		if data, err := readFile(etcdDir + "/status.json"); err == nil {
+			if member.Status, err = etcd.ParseStatus(data); err != nil {
+				member.Errors = append(member.Errors, "status: "+strings.TrimSpace(string(data)))
+			}
+		}
+		if data, err := readFile(etcdDir + "/alarm-list.json"); err == nil {
+			if member.Alarms, err = etcd.ParseAlarmList(data); err != nil {
+				member.Errors = append(member.Errors, "alarm list: "+strings.TrimSpace(string(data)))
+			}
+		}
+		members = append(members, member)
+	}
+	return members
+}
+
+func EtcdWriteSummary(path string, members []EtcdMemberData) (err error) {
+	f, err := createFile(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	if len(members) == 0 {
+		fmt.Fprintln(f, "No etcd member data was collected.")
+		return nil
+	}
+	for _, member := range members {
+		fmt.Fprintf(f, "== %s ==\n", member.Source)
+		if status := member.Status; status != nil {
+			fmt.Fprintf(f, "Version: %s\n", status.Version)
+			fmt.Fprintf(f, "Member ID: %x\n", uint64(status.Header.MemberID))
+			fmt.Fprintf(f, "Leader: %x (is leader: %t)\n", uint64(status.Leader), status.Leader == status.Header.MemberID)
+			fmt.Fprintf(f, "DB size: %d bytes, in use: %d bytes\n", uint64(status.DbSize), uint64(status.DbSizeInUse))
+			fmt.Fprintf(f, "Raft term: %d, index: %d, applied: %d\n", uint64(status.RaftTerm), uint64(status.RaftIndex), uint64(status.RaftAppliedIndex))
+			for _, statusErr := range status.Errors {
+				fmt.Fprintf(f, "Error: %s\n", statusErr)
+			}
+		}
+		if memberList := member.MemberList; memberList != nil {
+			fmt.Fprintln(f, "Members:")
+			for _, m := range memberList.Members {
+				fmt.Fprintf(f, "  %x %s peer=%s client=%s learner=%t\n", uint64(m.ID), m.Name, strings.Join(m.PeerURLs, ","), strings.Join(m.ClientURLs, ","), m.IsLearner)
+			}
+		}
+		if alarms := member.Alarms; alarms != nil {
+			if len(alarms.Alarms) == 0 {
+				fmt.Fprintln(f, "Alarms: none")
+			}
+			for _, alarm := range alarms.Alarms {
+				fmt.Fprintf(f, "Alarm: %s on member %x\n", alarm.Alarm, uint64(alarm.MemberID))
+			}
+		}
+		for _, hint := range etcd.DefragHints(member.Status, member.Alarms) {
+			fmt.Fprintf(f, "Hint: %s\n", hint)
+		}
+		for _, memberErr := range member.Errors {
+			fmt.Fprintf(f, "Collection error: %s\n", memberErr)
+		}
+		fmt.Fprintln(f)
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package collect
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	"github.com/mattmattox/supportability-collector/modules/etcd"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/api/v3/version"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// etcdStandIn starts a single etcd member serving TLS on 127.0.0.1 with a
+// backend quota of quota bytes, and returns it with the file of the CA its
+// certificate is signed by.
+func etcdStandIn(t *testing.T, quota int64) (*embed.Etcd, string) {
+	t.Helper()
+	dir := t.TempDir()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "etcd"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := embed.NewConfig()
+	config.Name = "server-1"
+	config.Dir = filepath.Join(dir, "data")
+	config.LogLevel = "error"
+	clientURL := url.URL{Scheme: "https", Host: "127.0.0.1:0"}
+	peerURL := url.URL{Scheme: "http", Host: "127.0.0.1:0"}
+	config.ListenClientUrls, config.AdvertiseClientUrls = []url.URL{clientURL}, []url.URL{clientURL}
+	config.ListenPeerUrls, config.AdvertisePeerUrls = []url.URL{peerURL}, []url.URL{peerURL}
+	config.InitialCluster = config.InitialClusterFromName(config.Name)
+	config.ClientTLSInfo = transport.TLSInfo{CertFile: certFile, KeyFile: keyFile}
+	config.QuotaBackendBytes = quota
+	server, err := embed.StartEtcd(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(30 * time.Second):
+		t.Fatal("etcd did not start")
+	}
+	return server, certFile
+}
+
+// etcdFillAndCompact writes to the stand-in until its quota raises the
+// NOSPACE alarm, then deletes and compacts everything so that most of the
+// database is free space.
+func etcdFillAndCompact(t *testing.T, endpoint string, caFile string) {
+	t.Helper()
+	tlsConfig, err := etcd.TLSConfig(caFile, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{endpoint}, TLS: tlsConfig, DialTimeout: 10 * time.Second, Logger: zap.NewNop()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	// bbolt only reuses the compacted pages a few commits later, so keep
+	// small keys around to delete once the alarm refuses any more puts
+	for i := 0; i < 50; i++ {
+		_, err := client.Put(ctx, fmt.Sprintf("marker-%d", i), "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	value := strings.Repeat("x", 64*1024)
+	for i := 0; ; i++ {
+		_, err := client.Put(ctx, fmt.Sprintf("key-%d", i), value)
+		if errors.Is(err, rpctypes.ErrNoSpace) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	deleted, err := client.Delete(ctx, "key-", clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Compact(ctx, deleted.Header.Revision, clientv3.WithCompactPhysical())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		_, err := client.Delete(ctx, fmt.Sprintf("marker-%d", i%50))
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, err := client.Status(ctx, endpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.DbSizeInUse*2 < status.DbSize {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("database never freed up, %d of %d bytes in use", status.DbSizeInUse, status.DbSize)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func TestEtcdFromPods(t *testing.T) {
+	timeout := etcdTimeout
+	etcdTimeout = 2 * time.Second
+	t.Cleanup(func() { etcdTimeout = timeout })
+
+	server, caFile := etcdStandIn(t, 1024*1024)
+	address := server.Clients[0].Addr().String()
+	etcdFillAndCompact(t, "https://"+address, caFile)
+	backend := server.Server.Backend()
+	memberID := fmt.Sprintf("%x", uint64(server.Server.ID()))
+
+	pods := []v1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "etcd-server-1", Namespace: "kube-system"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "etcd-server-2", Namespace: "kube-system"}},
+	}
+	tests := []struct {
+		name     string
+		settings cli.Cli
+		forward  func(pod v1.Pod, port int) (string, func(), error)
+		summary  []string
+		files    []string
+	}{
+		{
+			name:     "members answer through the port-forward",
+			settings: cli.Cli{EtcdCAFile: caFile},
+			summary: []string{
+				"== etcd-server-1 ==", "== etcd-server-2 ==",
+				"Version: " + version.Version,
+				"Leader: " + memberID + " (is leader: true)",
+				fmt.Sprintf("DB size: %d bytes, in use: %d bytes", backend.Size(), backend.SizeInUse()),
+				memberID + " server-1 peer=http://127.0.0.1:0",
+				"Alarm: NOSPACE on member " + memberID,
+				"of the database (",
+				"is free space; a defrag would reclaim it",
+				"Hint: member " + memberID + " raised NOSPACE; compact, defrag and then disarm the alarm",
+			},
+			files: []string{"etcd-server-1/member-list.json", "etcd-server-1/status.json", "etcd-server-2/alarm-list.json"},
+		},
+		{
+			name:     "failed port-forwards are reported",
+			settings: cli.Cli{EtcdCAFile: caFile},
+			forward: func(pod v1.Pod, port int) (string, func(), error) {
+				if pod.Name == "etcd-server-2" {
+					return "", nil, errors.New("pod not running")
+				}
+				return address, func() {}, nil
+			},
+			summary: []string{"== etcd-server-1 ==", "Version: " + version.Version, "== etcd-server-2 ==", "Collection error: port-forward: pod not running"},
+			files:   []string{"etcd-server-1/status.json"},
+		},
+		{
+			name:    "untrusted members are reported",
+			summary: []string{"Collection error: connect:", "certificate signed by unknown authority"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stopped := map[string]bool{}
+			forward := test.forward
+			if forward == nil {
+				forward = func(pod v1.Pod, port int) (string, func(), error) {
+					if port != 2379 {
+						t.Errorf("port-forward to port %d, want 2379", port)
+					}
+					return address, func() { stopped[pod.Name] = true }, nil
+				}
+			}
+			dir := t.TempDir()
+			members := EtcdFromPods(pods, forward, test.settings, dir)
+			err := EtcdWriteSummary(filepath.Join(dir, "etcd-summary.txt"), members)
+			if err != nil {
+				t.Fatal(err)
+			}
+			summary, err := os.ReadFile(filepath.Join(dir, "etcd-summary.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range test.summary {
+				if !strings.Contains(string(summary), line) {
+					t.Errorf("summary is missing %q:\n%s", line, summary)
+				}
+			}
+			for _, file := range test.files {
+				if _, err := os.Stat(filepath.Join(dir, "endpoints", file)); err != nil {
+					t.Errorf("%s was not saved - %v", file, err)
+				}
+			}
+			if test.forward == nil && len(stopped) != len(pods) {
+				t.Errorf("port-forwards stopped for %v, want every pod", stopped)
+			}
+		})
+	}
+
+	// The saved responses parse like the ones node diagnostics query
+	// from the JSON gateway
+	dir := t.TempDir()
+	member := etcdQuery("https://"+address, mustTLSConfig(t, caFile), "server-1", dir)
+	for name, parse := range map[string]func([]byte) error{
+		"member-list.json": func(data []byte) error { _, err := etcd.ParseMemberList(data); return err },
+		"status.json":      func(data []byte) error { _, err := etcd.ParseStatus(data); return err },
+		"alarm-list.json":  func(data []byte) error { _, err := etcd.ParseAlarmList(data); return err },
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := parse(data); err != nil {
+			t.Errorf("%s doesn't parse - %v", name, err)
+		}
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "status.json"))
+	status, _ := etcd.ParseStatus(data)
+	if status == nil || status.Version != member.Status.Version || status.DbSize != member.Status.DbSize {
+		t.Errorf("status.json %+v, want %+v", status, member.Status)
+	}
+}
+
+func mustTLSConfig(t *testing.T, caFile string) *tls.Config {
+	t.Helper()
+	tlsConfig, err := etcd.TLSConfig(caFile, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tlsConfig
+}
+
+func TestEtcdFromPodsWithoutPods(t *testing.T) {
+	forward := func(pod v1.Pod, port int) (string, func(), error) {
+		t.Error("port-forward without etcd pods")
+		return "", nil, errors.New("unexpected")
+	}
+	if members := EtcdFromPods(nil, forward, cli.Cli{}, t.TempDir()); members != nil {
+		t.Errorf("members %v without etcd pods", members)
+	}
+}
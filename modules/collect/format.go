@@ -0,0 +1,191 @@
+package collect
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+)
+
+// Archive formats, as set with ARCHIVE_FORMAT and recorded in manifests.
+const (
+	FormatTarGz  = "tar.gz"
+	FormatTarZst = "tar.zst"
+	FormatZip    = "zip"
+	FormatDir    = "dir"
+)
+
+// BundleExtensions are the extensions of the archive formats.
+var BundleExtensions = []string{".tar.gz", ".tar.zst", ".zip"}
+
+// pgzipBlockSize is the size of the blocks gzip compresses in parallel.
+const pgzipBlockSize = 1 << 20
+
+// Compression configures how archives are compressed. Level -1 is the
+// default level of the format. Threads compress tar.gz and tar.zst
+// archives in parallel; zip entries are compressed one at a time.
+type Compression struct {
+	Level   int
+	Threads int
+}
+
+// Modes of every archive entry, so archives don't depend on the umask or
+// the user running the collector.
+const (
+	fileMode = 0644
+	dirMode  = 0755
+)
+
+// archiveWriter writes the entries of an archive format.
+type archiveWriter interface {
+	// WriteDir adds a directory, name ends with a slash.
+	WriteDir(name string) error
+	// WriteFile adds a regular file of size bytes read from r.
+	WriteFile(name string, size int64, r io.Reader) error
+	// Close finishes the archive without closing the writer below it.
+	Close() error
+}
+
+// newArchiveWriter returns a writer of format into w. Every entry is
+// dated modTime.
+func newArchiveWriter(format string, w io.Writer, compression Compression, modTime time.Time) (archiveWriter, error) {
+	switch format {
+	case FormatTarGz:
+		level := compression.Level
+		if level < 0 {
+			level = pgzip.DefaultCompression
+		}
+		gz, err := pgzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		err = gz.SetConcurrency(pgzipBlockSize, compression.Threads)
+		if err != nil {
+			return nil, err
+		}
+		return &tarWriter{tw: tar.NewWriter(gz), compressor: gz, modTime: modTime}, nil
+	case FormatTarZst:
+		options := []zstd.EOption{zstd.WithEncoderConcurrency(compression.Threads)}
+		if compression.Level > 0 {
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(compression.Level)))
+		}
+		zw, err := zstd.NewWriter(w, options...)
+		if err != nil {
+			return nil, err
+		}
+		return &tarWriter{tw: tar.NewWriter(zw), compressor: zw, modTime: modTime}, nil
+	case FormatZip:
+		level := compression.Level
+		if level < 0 {
+			level = flate.DefaultCompression
+		}
+		zw := zip.NewWriter(w)
+		zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		})
+		return &zipWriter{zw: zw, modTime: modTime}, nil
+	}
+	return nil, fmt.Errorf("unsupported archive format %q", format)
+}
+
+// Extension returns the file extension of an archive format.
+func Extension(format string) string {
+	if format == FormatZip {
+		return ".zip"
+	}
+	return "." + format
+}
+
+// ContentType returns the media type of a bundle by its name.
+func ContentType(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		return "application/gzip"
+	case strings.HasSuffix(name, ".zst"):
+		return "application/zstd"
+	case strings.HasSuffix(name, ".zip"):
+		return "application/zip"
+	}
+	return "application/octet-stream"
+}
+
+type tarWriter struct {
+	tw         *tar.Writer
+	compressor io.WriteCloser
+	modTime    time.Time
+}
+
+func (w *tarWriter) WriteDir(name string) error {
+	return w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name,
+		Mode:     dirMode,
+		ModTime:  w.modTime,
+	})
+}
+
+func (w *tarWriter) WriteFile(name string, size int64, r io.Reader) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     fileMode,
+		ModTime:  w.modTime,
+	}
+	err := w.tw.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w.tw, r)
+	return err
+}
+
+func (w *tarWriter) Close() error {
+	err := w.tw.Close()
+	if closeErr := w.compressor.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+type zipWriter struct {
+	zw      *zip.Writer
+	modTime time.Time
+}
+
+func (w *zipWriter) WriteDir(name string) error {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: w.modTime,
+	}
+	header.SetMode(fs.ModeDir | dirMode)
+	_, err := w.zw.CreateHeader(header)
+	return err
+}
+
+func (w *zipWriter) WriteFile(name string, size int64, r io.Reader) error {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: w.modTime,
+	}
+	header.SetMode(fileMode)
+	f, err := w.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	return err
+}
+
+func (w *zipWriter) Close() error {
+	return w.zw.Close()
+}
@@ -0,0 +1,95 @@
+package collect
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveFormats(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		format      string
+		compression Compression
+	}{
+		{format: FormatTarGz, compression: Compression{Level: -1, Threads: 2}},
+		{format: FormatTarGz, compression: Compression{Level: 9, Threads: 1}},
+		{format: FormatTarZst, compression: Compression{Level: -1, Threads: 2}},
+		{format: FormatTarZst, compression: Compression{Level: 19, Threads: 1}},
+		{format: FormatZip, compression: Compression{Level: -1}},
+		{format: FormatZip, compression: Compression{Level: 1}},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s level %d", test.format, test.compression.Level), func(t *testing.T) {
+			dst := filepath.Join(t.TempDir(), "bundle"+Extension(test.format))
+			sink, err := NewArchiveSink(dst, ArchiveOptions{Format: test.format, Compression: test.compression, BufferSize: 1 << 20, Scratch: t.TempDir(), Root: "supportbundle-local-20240102T030405Z", ModTime: modTime})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, content := range map[string]string{"timestamp": "1704164645", "rancher-data/rancher-data.yaml": "version: v2.7.5\n"} {
+				w, err := sink.Create(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				io.WriteString(w, content)
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := sink.Mkdir("probes/server-1"); err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			entries := readArchive(t, dst)
+			if len(entries) == 0 || entries[0].name != "supportbundle-local-20240102T030405Z/" {
+				t.Fatalf("first entry isn't the top-level directory: %v", entries)
+			}
+			written := map[string]bool{}
+			for _, entry := range entries {
+				if !strings.HasPrefix(entry.name, "supportbundle-local-20240102T030405Z/") {
+					t.Errorf("%s is outside of the top-level directory", entry.name)
+				}
+				if parent := filepath.Dir(strings.TrimSuffix(entry.name, "/")) + "/"; parent != "./" && !written[parent] {
+					t.Errorf("%s is written before its directory", entry.name)
+				}
+				written[entry.name] = true
+				wantMode := fs.FileMode(fileMode)
+				if strings.HasSuffix(entry.name, "/") {
+					wantMode = fs.ModeDir | dirMode
+				}
+				if entry.mode != wantMode {
+					t.Errorf("%s has mode %v, want %v", entry.name, entry.mode, wantMode)
+				}
+				if !entry.modTime.Equal(modTime) {
+					t.Errorf("%s is dated %v, want %v", entry.name, entry.modTime, modTime)
+				}
+				if entry.owner != 0 {
+					t.Errorf("%s isn't owned by root", entry.name)
+				}
+			}
+			for _, name := range []string{"timestamp", "rancher-data/rancher-data.yaml", "probes/", "probes/server-1/"} {
+				if !written["supportbundle-local-20240102T030405Z/"+name] {
+					t.Errorf("%s is missing from %v", name, entries)
+				}
+			}
+		})
+	}
+}
+
+func TestUnsupportedArchiveFormat(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "bundle.rar")
+	_, err := NewArchiveSink(dst, ArchiveOptions{Format: "rar", Scratch: t.TempDir()})
+	if err == nil {
+		t.Fatal("rar archives were created")
+	}
+	if matches, _ := filepath.Glob(dst + "*"); len(matches) != 0 {
+		t.Errorf("failed archive left %v", matches)
+	}
+}
@@ -0,0 +1,387 @@
+package collect
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	"github.com/mattmattox/supportability-collector/modules/kubernetes"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	prometheusNamespace = "cattle-monitoring-system"
+	prometheusService   = "rancher-monitoring-prometheus"
+	prometheusPort      = "9090"
+)
+
+// DefaultPrometheusQueries are run against cattle-monitoring's Prometheus
+// when PROMETHEUS_QUERIES_FILE is not set.
+var DefaultPrometheusQueries = map[string]string{
+	"apiserver-request-rate":    `sum(rate(apiserver_request_total[5m])) by (code)`,
+	"apiserver-request-latency": `histogram_quantile(0.99, sum(rate(apiserver_request_duration_seconds_bucket{verb!="WATCH"}[5m])) by (le, verb))`,
+	"node-cpu-utilisation":      `1 - avg(rate(node_cpu_seconds_total{mode="idle"}[5m])) by (instance)`,
+	"node-memory-available":     `node_memory_MemAvailable_bytes`,
+	"container-restarts":        `sum(increase(kube_pod_container_status_restarts_total[1h])) by (namespace, pod) > 0`,
+	"etcd-db-size":              `etcd_mvcc_db_total_size_in_bytes`,
+}
+
+// metricsList holds the fields of a metrics.k8s.io NodeMetricsList or
+// PodMetricsList used for the text tables.
+type metricsList struct {
+	Items []struct {
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Usage      map[string]string `json:"usage"`
+		Containers []struct {
+			Name  string            `json:"name"`
+			Usage map[string]string `json:"usage"`
+		} `json:"containers"`
+	} `json:"items"`
+}
+
+// prometheusResponse is a Prometheus HTTP API range query response.
+type prometheusResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Values [][]interface{}   `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+func MetricsDir(dir string) string {
+	metricsDir := dir + "/metrics"
+	err := mkdirAll(metricsDir, 0755)
+	if err != nil {
+		log.Fatalln("Metrics directory creation failed")
+	}
+	return metricsDir
+}
+
+func CollectMetrics(settings cli.Cli, tempDirRoot string) {
+	log.Infoln("Collecting metrics snapshot")
+
+	metricsDir := MetricsDir(tempDirRoot)
+
+	config, err := kubernetes.GetConfig()
+	if err != nil {
+		log.Fatalln("Failed to connect to upstream cluster")
+	}
+	client, err := kubernetes.GetClient()
+	if err != nil {
+		log.Fatalln("Failed to connect to upstream cluster")
+	}
+
+	MetricsResourceUsage(config, metricsDir)
+	MetricsRancher(settings, config, metricsDir)
+	MetricsPrometheus(settings, client, metricsDir)
+
+	log.Infoln("Metrics snapshot collection complete")
+}
+
+// MetricsResourceUsage saves the metrics.k8s.io node and pod usage.
+func MetricsResourceUsage(config *rest.Config, dir string) {
+	nodeMetrics, err := kubernetes.GetNodeMetrics(config)
+	if err != nil {
+		log.Warningf("Node metrics collection failed, is metrics-server installed? - Error %s", err)
+	} else {
+		metricsWrite(dir, "node-metrics", nodeMetrics, false)
+	}
+	podMetrics, err := kubernetes.GetPodMetrics(config)
+	if err != nil {
+		log.Warningf("Pod metrics collection failed, is metrics-server installed? - Error %s", err)
+	} else {
+		metricsWrite(dir, "pod-metrics", podMetrics, true)
+	}
+}
+
+func metricsWrite(dir string, name string, data []byte, pods bool) {
+	err := writeFile(dir+"/"+name+".json", data, 0644)
+	if err != nil {
+		log.Warningf("%s JSON file write failed - Error %s", name, err)
+	}
+	var list metricsList
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		log.Warningf("%s parsing failed - Error %s", name, err)
+		return
+	}
+	f, err := createFile(dir + "/" + name + ".txt")
+	if err != nil {
+		log.Warningf("%s text file creation failed - Error %s", name, err)
+		return
+	}
+	w := tabwriter.NewWriter(f, 0, 0, 2, ' ', 0)
+	if pods {
+		fmt.Fprintln(w, "NAMESPACE\tPOD\tCONTAINER\tCPU\tMEMORY")
+		for _, item := range list.Items {
+			for _, container := range item.Containers {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", item.Metadata.Namespace, item.Metadata.Name, container.Name, container.Usage["cpu"], container.Usage["memory"])
+			}
+		}
+	} else {
+		fmt.Fprintln(w, "NODE\tCPU\tMEMORY")
+		for _, item := range list.Items {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", item.Metadata.Name, item.Usage["cpu"], item.Usage["memory"])
+		}
+	}
+	err = w.Flush()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Warningf("%s text file write failed - Error %s", name, err)
+	}
+}
+
+// MetricsRancher scrapes the Rancher server /metrics endpoint using the
+// Rancher API key from the settings.
+func MetricsRancher(settings cli.Cli, config *rest.Config, dir string) {
+	serverURL, err := kubernetes.GetRancherServerURL(config)
+	if err != nil {
+		log.Warningf("Rancher server URL lookup failed, skipping Rancher metrics - Error %s", err)
+		return
+	}
+	if serverURL == "" {
+		log.Infoln("Rancher server URL is not set, skipping Rancher metrics")
+		return
+	}
+	cacerts, err := kubernetes.GetRancherCACerts(config)
+	if err != nil {
+		log.Warningf("Rancher CA lookup failed, trusting the cluster and system CAs only - Error %s", err)
+	}
+	roots, err := rancherRootCAs(config, cacerts)
+	if err != nil {
+		log.Warningf("Rancher metrics CA setup failed - Error %s", err)
+		return
+	}
+	data, err := rancherMetrics(settings, serverURL, roots)
+	if err != nil {
+		log.Warningf("Rancher metrics collection failed - Error %s", err)
+		return
+	}
+	err = writeFile(dir+"/rancher-metrics.txt", data, 0644)
+	if err != nil {
+		log.Warningf("Rancher metrics file write failed - Error %s", err)
+	}
+}
+
+// rancherRootCAs returns the system CAs plus the CA of the cluster and the
+// private CA of Rancher, as Rancher is often served with either.
+func rancherRootCAs(config *rest.Config, cacerts string) (*x509.CertPool, error) {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	caData := config.TLSClientConfig.CAData
+	if len(caData) == 0 && config.TLSClientConfig.CAFile != "" {
+		caData, err = os.ReadFile(config.TLSClientConfig.CAFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	roots.AppendCertsFromPEM(caData)
+	roots.AppendCertsFromPEM([]byte(cacerts))
+	return roots, nil
+}
+
+func rancherMetrics(settings cli.Cli, serverURL string, roots *x509.CertPool) ([]byte, error) {
+	request, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(serverURL, "/")+"/metrics", nil)
+	if err != nil {
+		return nil, err
+	}
+	request.SetBasicAuth(settings.RancherAccessKey, settings.RancherSecretKey)
+	httpClient := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12},
+		},
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rancher returned %s", response.Status)
+	}
+	return data, nil
+}
+
+// MetricsPrometheus runs the configured PromQL range queries over the
+// recent window. Queries go to PROMETHEUS_URL when set, otherwise through
+// the API server service proxy to cattle-monitoring's Prometheus.
+func MetricsPrometheus(settings cli.Cli, client k8s.Interface, dir string) {
+	queries := DefaultPrometheusQueries
+	if settings.PrometheusQueriesFile != "" {
+		data, err := os.ReadFile(settings.PrometheusQueriesFile)
+		if err != nil {
+			log.Warningf("Prometheus queries file read failed - Error %s", err)
+			return
+		}
+		queries = map[string]string{}
+		err = yaml.Unmarshal(data, &queries)
+		if err != nil {
+			log.Warningf("Prometheus queries file parsing failed - Error %s", err)
+			return
+		}
+	}
+
+	var fetch func(params map[string]string) ([]byte, error)
+	if settings.PrometheusURL != "" {
+		fetch = func(params map[string]string) ([]byte, error) {
+			return prometheusQueryURL(settings.PrometheusURL, params)
+		}
+	} else {
+		_, err := kubernetes.GetServiceYaml(client, prometheusNamespace, prometheusService)
+		if err != nil {
+			log.Infoln("cattle-monitoring Prometheus not found, skipping PromQL queries")
+			return
+		}
+		fetch = func(params map[string]string) ([]byte, error) {
+			return kubernetes.ProxyGetService(client, prometheusNamespace, "http", prometheusService, prometheusPort, "api/v1/query_range", params)
+		}
+	}
+
+	prometheusDir := dir + "/prometheus"
+	err := mkdirAll(prometheusDir, 0755)
+	if err != nil {
+		log.Warningf("Prometheus metrics folder creation failed - Error %s", err)
+	}
+	end := time.Now()
+	start := end.Add(-settings.PrometheusWindow)
+	step := settings.PrometheusWindow / 60
+	if step < 15*time.Second {
+		step = 15 * time.Second
+	}
+	names := make([]string, 0, len(queries))
+	for name := range queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		log.Infof("Running Prometheus query: %s", name)
+		data, err := fetch(map[string]string{
+			"query": queries[name],
+			"start": strconv.FormatInt(start.Unix(), 10),
+			"end":   strconv.FormatInt(end.Unix(), 10),
+			"step":  strconv.Itoa(int(step.Seconds())),
+		})
+		if err != nil {
+			log.Warningf("Prometheus query %s failed - Error %s", name, err)
+			continue
+		}
+		err = writeFile(prometheusDir+"/"+name+".json", data, 0644)
+		if err != nil {
+			log.Warningf("Prometheus query %s JSON file write failed - Error %s", name, err)
+		}
+		err = prometheusWriteText(prometheusDir+"/"+name+".txt", queries[name], data)
+		if err != nil {
+			log.Warningf("Prometheus query %s text file write failed - Error %s", name, err)
+		}
+	}
+}
+
+func prometheusQueryURL(baseURL string, params map[string]string) ([]byte, error) {
+	values := url.Values{}
+	for key, value := range params {
+		values.Set(key, value)
+	}
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	response, err := httpClient.Get(strings.TrimSuffix(baseURL, "/") + "/api/v1/query_range?" + values.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("prometheus returned %s: %s", response.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// prometheusWriteText summarises each series of a range query as its
+// min, max and last value.
+func prometheusWriteText(path string, query string, data []byte) (err error) {
+	var response prometheusResponse
+	err = json.Unmarshal(data, &response)
+	if err != nil {
+		return err
+	}
+	f, err := createFile(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	fmt.Fprintf(f, "Query: %s\n", query)
+	if response.Status != "success" {
+		fmt.Fprintf(f, "Error: %s\n", response.Error)
+		return nil
+	}
+	w := tabwriter.NewWriter(f, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERIES\tSAMPLES\tMIN\tMAX\tLAST")
+	for _, series := range response.Data.Result {
+		labels := make([]string, 0, len(series.Metric))
+		for key, value := range series.Metric {
+			labels = append(labels, key+"="+value)
+		}
+		sort.Strings(labels)
+		var min, max, last float64
+		seen := false
+		for _, sample := range series.Values {
+			if len(sample) != 2 {
+				continue
+			}
+			raw, _ := sample[1].(string)
+			value, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				continue
+			}
+			if !seen || value < min {
+				min = value
+			}
+			if !seen || value > max {
+				max = value
+			}
+			last = value
+			seen = true
+		}
+		if !seen {
+			fmt.Fprintf(w, "{%s}\t%d\t-\t-\t-\n", strings.Join(labels, ","), len(series.Values))
+			continue
+		}
+		fmt.Fprintf(w, "{%s}\t%d\t%g\t%g\t%g\n", strings.Join(labels, ","), len(series.Values), min, max, last)
+	}
+	return w.Flush()
+}
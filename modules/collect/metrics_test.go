@@ -0,0 +1,169 @@
+package collect
+
+import (
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	"k8s.io/client-go/rest"
+)
+
+// prometheusStandIn answers range queries with canned responses keyed by
+// the PromQL query.
+func prometheusStandIn(t *testing.T, responses map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query_range" {
+			http.NotFound(w, r)
+			return
+		}
+		for _, param := range []string{"start", "end", "step"} {
+			if r.URL.Query().Get(param) == "" {
+				t.Errorf("query without %s: %s", param, r.URL.RawQuery)
+			}
+		}
+		response, ok := responses[r.URL.Query().Get("query")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"status":"error","errorType":"bad_data","error":"parse error"}`)
+			return
+		}
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestMetricsPrometheus(t *testing.T) {
+	server := prometheusStandIn(t, map[string]string{
+		"up": `{"status":"success","data":{"resultType":"matrix","result":[
+			{"metric":{"job":"apiserver","instance":"a"},"values":[[1700000000,"5"],[1700000015,"2"],[1700000030,"3"]]},
+			{"metric":{"job":"kubelet"},"values":[[1700000000,-1],[1700000015,"7"],[1700000030,"9"]]},
+			{"metric":{"job":"etcd"},"values":[[1700000000,"x"]]}
+		]}}`,
+		"negative": `{"status":"success","data":{"resultType":"matrix","result":[
+			{"metric":{},"values":[[1700000000,"bad"],[1700000015,"-4"],[1700000030,"-2"]]}
+		]}}`,
+	})
+	queriesFile := filepath.Join(t.TempDir(), "queries.yaml")
+	err := os.WriteFile(queriesFile, []byte("up: up\nnegative: negative\nbroken: broken(\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	settings := cli.Cli{PrometheusURL: server.URL, PrometheusQueriesFile: queriesFile, PrometheusWindow: time.Hour}
+	MetricsPrometheus(settings, nil, dir)
+
+	tests := []struct {
+		file  string
+		lines []string
+	}{
+		{
+			file: "up.txt",
+			lines: []string{
+				"Query: up",
+				"{instance=a,job=apiserver}  3        2    5    3",
+				"{job=kubelet}               3        7    9    9",
+				"{job=etcd}                  1        -    -    -",
+			},
+		},
+		{
+			// The first sample doesn't parse, so it mustn't count as a zero
+			file:  "negative.txt",
+			lines: []string{"{}      3        -4   -2   -2"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join(dir, "prometheus", test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range test.lines {
+				if !strings.Contains(string(data), line) {
+					t.Errorf("%s is missing %q:\n%s", test.file, line, data)
+				}
+			}
+		})
+	}
+	if _, err := os.Stat(filepath.Join(dir, "prometheus", "up.json")); err != nil {
+		t.Errorf("up.json was not saved - %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "prometheus", "broken.json")); !os.IsNotExist(err) {
+		t.Errorf("failed query was saved - %v", err)
+	}
+}
+
+func TestRancherMetrics(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if r.URL.Path != "/metrics" || !ok || user != "token-abc" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, "rancher_cluster_count 3\n")
+	}))
+	defer server.Close()
+	serverCA := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	err := os.WriteFile(caFile, []byte(serverCA), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := cli.Cli{RancherAccessKey: "token-abc", RancherSecretKey: "secret"}
+	tests := []struct {
+		name     string
+		config   rest.Config
+		cacerts  string
+		settings cli.Cli
+		err      bool
+	}{
+		{
+			name:     "Rancher CA",
+			cacerts:  serverCA,
+			settings: settings,
+		},
+		{
+			name:     "cluster CA data",
+			config:   rest.Config{TLSClientConfig: rest.TLSClientConfig{CAData: []byte(serverCA)}},
+			settings: settings,
+		},
+		{
+			name:     "cluster CA file",
+			config:   rest.Config{TLSClientConfig: rest.TLSClientConfig{CAFile: caFile}},
+			settings: settings,
+		},
+		{
+			name:     "untrusted certificate",
+			settings: settings,
+			err:      true,
+		},
+		{
+			name:    "wrong API key",
+			cacerts: serverCA,
+			err:     true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			roots, err := rancherRootCAs(&test.config, test.cacerts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := rancherMetrics(test.settings, server.URL+"/", roots)
+			if (err != nil) != test.err {
+				t.Fatalf("error %v, want error %v", err, test.err)
+			}
+			if !test.err && string(data) != "rancher_cluster_count 3\n" {
+				t.Errorf("metrics %q", data)
+			}
+		})
+	}
+}
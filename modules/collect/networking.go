@@ -0,0 +1,392 @@
+package collect
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	"github.com/mattmattox/supportability-collector/modules/kubernetes"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// CustomResource identifies a list of custom resources to collect when its
+// CRD is installed.
+type CustomResource struct {
+	Group    string
+	Version  string
+	Resource string
+}
+
+// gatewayAPIResources are tried at v1 first and then v1beta1.
+var gatewayAPIResources = []string{"gatewayclasses", "gateways", "httproutes", "grpcroutes", "referencegrants"}
+
+// cniResources cover the Calico (also used by Canal) and Cilium CRDs that
+// describe the pod network.
+var cniResources = []CustomResource{
+	{Group: "crd.projectcalico.org", Version: "v1", Resource: "ippools"},
+	{Group: "crd.projectcalico.org", Version: "v1", Resource: "felixconfigurations"},
+	{Group: "crd.projectcalico.org", Version: "v1", Resource: "bgpconfigurations"},
+	{Group: "crd.projectcalico.org", Version: "v1", Resource: "bgppeers"},
+	{Group: "crd.projectcalico.org", Version: "v1", Resource: "clusterinformations"},
+	{Group: "crd.projectcalico.org", Version: "v1", Resource: "globalnetworkpolicies"},
+	{Group: "cilium.io", Version: "v2", Resource: "ciliumnodes"},
+	{Group: "cilium.io", Version: "v2", Resource: "ciliumnetworkpolicies"},
+	{Group: "cilium.io", Version: "v2", Resource: "ciliumclusterwidenetworkpolicies"},
+}
+
+// cniDaemonSetNames match the DaemonSets of the CNIs shipped with RKE1,
+// RKE2 and K3s.
+var cniDaemonSetNames = []string{"canal", "calico", "cilium", "flannel", "weave", "multus"}
+
+// cniNamespaces are searched for CNI DaemonSets.
+var cniNamespaces = []string{"kube-system", "calico-system"}
+
+// corednsConfigMaps are the CoreDNS ConfigMap names used by RKE1/kubeadm and
+// by the RKE2 chart.
+var corednsConfigMaps = []string{"coredns", "rke2-coredns-rke2-coredns"}
+
+func NetworkingDir(dir string) string {
+	networkingDir := dir + "/networking"
+	err := mkdirAll(networkingDir, 0755)
+	if err != nil {
+		log.Fatalln("Networking directory creation failed")
+	}
+	return networkingDir
+}
+
+func CollectNetworking(settings cli.Cli, tempDirRoot string) {
+	log.Infoln("Collecting networking information")
+
+	networkingDir := NetworkingDir(tempDirRoot)
+
+	config, err := kubernetes.GetConfig()
+	if err != nil {
+		log.Fatalln("Failed to connect to upstream cluster")
+	}
+	client, err := kubernetes.GetClient()
+	if err != nil {
+		log.Fatalln("Failed to connect to upstream cluster")
+	}
+
+	NetworkingNetworkPolicies(client, settings.Namespaces, networkingDir)
+	NetworkingEndpointSlices(client, settings.Namespaces, networkingDir)
+	NetworkingIngressClasses(client, networkingDir)
+	NetworkingGatewayAPI(config, networkingDir)
+	NetworkingCNI(config, client, networkingDir)
+	NetworkingCoreDNS(client, networkingDir)
+
+	err = NetworkingWriteServiceMap(client, settings.Namespaces, networkingDir+"/service-map.txt")
+	if err != nil {
+		log.Warningf("Service map write failed - Error %s", err)
+	}
+
+	log.Infoln("Networking collection complete")
+}
+
+func NetworkingNetworkPolicies(client k8s.Interface, namespaces []string, dir string) {
+	for _, namespace := range namespaces {
+		networkpolicies, err := kubernetes.GetNetworkPolicies(client, namespace)
+		if err != nil {
+			log.Warningf("List of network policies in %s failed - Error %s", namespace, err)
+			continue
+		}
+		if len(networkpolicies) == 0 {
+			continue
+		}
+		networkpolicyDir := dir + "/networkpolicies/" + namespace
+		err = mkdirAll(networkpolicyDir, 0755)
+		if err != nil {
+			log.Warningf("Folder creation for network policies in %s failed - Error %s", namespace, err)
+		}
+		for _, networkpolicy := range networkpolicies {
+			log.Infof("Grabbing YAML for network policy: %s/%s", namespace, networkpolicy)
+			networkpolicyData, err := kubernetes.GetNetworkPolicyYaml(client, namespace, networkpolicy)
+			if err != nil {
+				log.Warningf("Network policy YAML collection failed - Error %s", err)
+				continue
+			}
+			err = WriteYaml(networkpolicyDir+"/"+networkpolicy+".yaml", networkpolicyData)
+			if err != nil {
+				log.Warningf("Network policy YAML file write failed - Error %s", err)
+			}
+		}
+	}
+}
+
+func NetworkingEndpointSlices(client k8s.Interface, namespaces []string, dir string) {
+	for _, namespace := range namespaces {
+		endpointslices, err := kubernetes.GetEndpointSlices(client, namespace)
+		if err != nil {
+			log.Warningf("List of endpoint slices in %s failed - Error %s", namespace, err)
+			continue
+		}
+		if len(endpointslices) == 0 {
+			continue
+		}
+		endpointsliceDir := dir + "/endpointslices/" + namespace
+		err = mkdirAll(endpointsliceDir, 0755)
+		if err != nil {
+			log.Warningf("Folder creation for endpoint slices in %s failed - Error %s", namespace, err)
+		}
+		for _, endpointslice := range endpointslices {
+			log.Infof("Grabbing YAML for endpoint slice: %s/%s", namespace, endpointslice)
+			endpointsliceData, err := kubernetes.GetEndpointSliceYaml(client, namespace, endpointslice)
+			if err != nil {
+				log.Warningf("Endpoint slice YAML collection failed - Error %s", err)
+				continue
+			}
+			err = WriteYaml(endpointsliceDir+"/"+endpointslice+".yaml", endpointsliceData)
+			if err != nil {
+				log.Warningf("Endpoint slice YAML file write failed - Error %s", err)
+			}
+		}
+	}
+}
+
+func NetworkingIngressClasses(client k8s.Interface, dir string) {
+	ingressclasses, err := kubernetes.GetIngressClasses(client)
+	if err != nil {
+		log.Warningf("List of ingress classes failed - Error %s", err)
+		return
+	}
+	ingressclassDir := dir + "/ingressclasses"
+	err = mkdirAll(ingressclassDir, 0755)
+	if err != nil {
+		log.Warningf("Folder creation for ingress classes failed - Error %s", err)
+	}
+	for _, ingressclass := range ingressclasses {
+		log.Infof("Grabbing YAML for ingress class: %s", ingressclass)
+		ingressclassData, err := kubernetes.GetIngressClassYaml(client, ingressclass)
+		if err != nil {
+			log.Warningf("Ingress class YAML collection failed - Error %s", err)
+			continue
+		}
+		err = WriteYaml(ingressclassDir+"/"+ingressclass+".yaml", ingressclassData)
+		if err != nil {
+			log.Warningf("Ingress class YAML file write failed - Error %s", err)
+		}
+	}
+}
+
+func NetworkingGatewayAPI(config *rest.Config, dir string) {
+	gatewayDir := dir + "/gateway-api"
+	for _, resource := range gatewayAPIResources {
+		var resources []CustomResource
+		for _, version := range []string{"v1", "v1beta1"} {
+			resources = append(resources, CustomResource{Group: "gateway.networking.k8s.io", Version: version, Resource: resource})
+		}
+		for _, customResource := range resources {
+			if CollectCustomResource(config, customResource, gatewayDir) {
+				break
+			}
+		}
+	}
+}
+
+func NetworkingCNI(config *rest.Config, client k8s.Interface, dir string) {
+	cniDir := dir + "/cni"
+	for _, customResource := range cniResources {
+		CollectCustomResource(config, customResource, cniDir)
+	}
+
+	daemonsetDir := cniDir + "/daemonsets"
+	var summary []string
+	for _, namespace := range cniNamespaces {
+		daemonsets, err := kubernetes.GetDaemonSets(client, namespace)
+		if err != nil {
+			log.Warningf("List of daemonsets in %s failed - Error %s", namespace, err)
+			continue
+		}
+		for _, daemonset := range daemonsets {
+			if !matchesAny(daemonset, cniDaemonSetNames) {
+				continue
+			}
+			log.Infof("Grabbing YAML for CNI daemonset: %s/%s", namespace, daemonset)
+			daemonsetData, err := kubernetes.GetDaemonSetYaml(client, namespace, daemonset)
+			if err != nil {
+				log.Warningf("CNI daemonset YAML collection failed - Error %s", err)
+				continue
+			}
+			err = mkdirAll(daemonsetDir, 0755)
+			if err != nil {
+				log.Warningf("Folder creation for CNI daemonsets failed - Error %s", err)
+			}
+			err = WriteYaml(daemonsetDir+"/"+namespace+"-"+daemonset+".yaml", daemonsetData)
+			if err != nil {
+				log.Warningf("CNI daemonset YAML file write failed - Error %s", err)
+			}
+			status := daemonsetData.Status
+			summary = append(summary, fmt.Sprintf("%s/%s desired=%d ready=%d available=%d updated=%d misscheduled=%d",
+				namespace, daemonset, status.DesiredNumberScheduled, status.NumberReady, status.NumberAvailable, status.UpdatedNumberScheduled, status.NumberMisscheduled))
+		}
+	}
+	if len(summary) == 0 {
+		summary = append(summary, "No known CNI DaemonSets found")
+	}
+	err := mkdirAll(cniDir, 0755)
+	if err != nil {
+		log.Warningf("Folder creation for CNI failed - Error %s", err)
+	}
+	err = writeFile(cniDir+"/cni-summary.txt", []byte(strings.Join(summary, "\n")+"\n"), 0644)
+	if err != nil {
+		log.Warningf("CNI summary write failed - Error %s", err)
+	}
+}
+
+func NetworkingCoreDNS(client k8s.Interface, dir string) {
+	corednsDir := dir + "/coredns"
+	err := mkdirAll(corednsDir+"/logs", 0755)
+	if err != nil {
+		log.Warningf("Folder creation for CoreDNS failed - Error %s", err)
+	}
+	for _, name := range corednsConfigMaps {
+		configmap, err := kubernetes.GetConfigMapYaml(client, "kube-system", name)
+		if err != nil {
+			continue
+		}
+		log.Infof("Grabbing CoreDNS config: %s", name)
+		err = WriteYaml(corednsDir+"/"+name+".yaml", configmap)
+		if err != nil {
+			log.Warningf("CoreDNS config file write failed - Error %s", err)
+		}
+	}
+	pods, err := kubernetes.GetPodsBySelector(client, "kube-system", "k8s-app=kube-dns")
+	if err != nil {
+		log.Warningf("List of CoreDNS pods failed - Error %s", err)
+		return
+	}
+	for _, pod := range pods {
+		log.Infof("Grabbing logs for CoreDNS pod: %s", pod.Name)
+		logs, err := kubernetes.GetPodLogs(client, pod.Namespace, pod.Name, "")
+		if err != nil {
+			log.Warningf("CoreDNS pod log collection failed - Error %s", err)
+			continue
+		}
+		err = writeFile(corednsDir+"/logs/"+pod.Name+".log", logs, 0644)
+		if err != nil {
+			log.Warningf("CoreDNS pod log file write failed - Error %s", err)
+		}
+	}
+}
+
+// CollectCustomResource writes the list of a custom resource to
+// dir/<group>-<resource>.yaml. It returns false when the resource isn't
+// served by the cluster.
+func CollectCustomResource(config *rest.Config, customResource CustomResource, dir string) bool {
+	resourceYaml, err := kubernetes.GetCustomResourceListYaml(config, customResource.Group, customResource.Version, customResource.Resource)
+	if apierrors.IsNotFound(err) {
+		return false
+	}
+	if err != nil {
+		log.Warningf("%s.%s YAML collection failed - Error %s", customResource.Resource, customResource.Group, err)
+		return false
+	}
+	log.Infof("Grabbing YAML for %s.%s", customResource.Resource, customResource.Group)
+	err = mkdirAll(dir, 0755)
+	if err != nil {
+		log.Warningf("Folder creation for %s failed - Error %s", dir, err)
+	}
+	err = writeFile(dir+"/"+customResource.Group+"-"+customResource.Resource+".yaml", []byte(resourceYaml), 0644)
+	if err != nil {
+		log.Warningf("%s.%s YAML file write failed - Error %s", customResource.Resource, customResource.Group, err)
+	}
+	return true
+}
+
+// NetworkingWriteServiceMap writes, for every Service in the collected
+// namespaces, its endpoints and the readiness of the pods behind them.
+func NetworkingWriteServiceMap(client k8s.Interface, namespaces []string, path string) (err error) {
+	f, err := createFile(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	for _, namespace := range namespaces {
+		services, err := kubernetes.GetServices(client, namespace)
+		if err != nil {
+			fmt.Fprintf(f, "%s: listing services failed: %s\n\n", namespace, err)
+			continue
+		}
+		pods, err := kubernetes.GetPodsBySelector(client, namespace, "")
+		if err != nil {
+			fmt.Fprintf(f, "%s: listing pods failed: %s\n\n", namespace, err)
+			continue
+		}
+		podsByName := map[string]v1.Pod{}
+		for _, pod := range pods {
+			podsByName[pod.Name] = pod
+		}
+		for _, service := range services {
+			serviceData, err := kubernetes.GetServiceYaml(client, namespace, service)
+			if err != nil {
+				continue
+			}
+			var ports []string
+			for _, port := range serviceData.Spec.Ports {
+				ports = append(ports, fmt.Sprintf("%d/%s", port.Port, port.Protocol))
+			}
+			fmt.Fprintf(f, "%s/%s (%s %s) ports %s\n", namespace, service, serviceData.Spec.Type, serviceData.Spec.ClusterIP, strings.Join(ports, ","))
+			if serviceData.Spec.Type == v1.ServiceTypeExternalName {
+				fmt.Fprintf(f, "  external name %s\n\n", serviceData.Spec.ExternalName)
+				continue
+			}
+			endpoints, err := kubernetes.GetEndpointYaml(client, namespace, service)
+			if err != nil {
+				fmt.Fprintf(f, "  no endpoints: %s\n\n", err)
+				continue
+			}
+			ready, notReady := 0, 0
+			var lines []string
+			for _, subset := range endpoints.Subsets {
+				ready += len(subset.Addresses)
+				notReady += len(subset.NotReadyAddresses)
+				for _, address := range subset.Addresses {
+					lines = append(lines, serviceMapLine(address, "ready", podsByName))
+				}
+				for _, address := range subset.NotReadyAddresses {
+					lines = append(lines, serviceMapLine(address, "not ready", podsByName))
+				}
+			}
+			fmt.Fprintf(f, "  endpoints: %d ready, %d not ready\n", ready, notReady)
+			for _, line := range lines {
+				fmt.Fprintln(f, line)
+			}
+			fmt.Fprintln(f)
+		}
+	}
+	return nil
+}
+
+func serviceMapLine(address v1.EndpointAddress, endpointState string, pods map[string]v1.Pod) string {
+	if address.TargetRef == nil || address.TargetRef.Kind != "Pod" {
+		return fmt.Sprintf("  %s (%s)", address.IP, endpointState)
+	}
+	pod, ok := pods[address.TargetRef.Name]
+	if !ok {
+		return fmt.Sprintf("  %s (%s) -> pod %s (not found)", address.IP, endpointState, address.TargetRef.Name)
+	}
+	podReady := "NotReady"
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady && condition.Status == v1.ConditionTrue {
+			podReady = "Ready"
+		}
+	}
+	return fmt.Sprintf("  %s (%s) -> pod %s on %s phase=%s %s", address.IP, endpointState, pod.Name, pod.Spec.NodeName, pod.Status.Phase, podReady)
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.Contains(name, pattern) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,398 @@
+package collect
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	"github.com/mattmattox/supportability-collector/modules/kubernetes"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// nodeArchivePath is where node scripts leave their archive. The pod
+// turns ready once it exists and it's then copied out with exec.
+const nodeArchivePath = "/tmp/supportability-collector.tar.gz"
+
+var (
+	// nodeDaemonSetInterval is how often the DaemonSet pods are checked.
+	nodeDaemonSetInterval = 5 * time.Second
+	// maxNodeArchiveSize bounds the archive copied from a single node.
+	maxNodeArchiveSize int64 = 512 << 20
+)
+
+// maxNodeConfigSize bounds the /etc/rancher files read for redaction.
+const maxNodeConfigSize = 1 << 20
+
+// nodeArchiveScript ends every node script: it archives $OUT to
+// nodeArchivePath, renaming it into place so a ready pod always has a
+// complete archive.
+const nodeArchiveScript = `
+tar czf ` + nodeArchivePath + `.partial -C $OUT . && mv ` + nodeArchivePath + `.partial ` + nodeArchivePath + `
+rm -rf $OUT
+sleep infinity
+`
+
+// nodeDiagnosticsScript runs inside the privileged DaemonSet pod with the host
+// filesystem mounted at /host. It gathers everything into $OUT, which
+// nodeArchiveScript archives.
+const nodeDiagnosticsScript = `
+OUT=$(mktemp -d)
+mkdir -p $OUT/logs $OUT/etc-rancher $OUT/system
+
+for unit in kubelet containerd docker rke2-server rke2-agent k3s k3s-agent; do
+  chroot /host journalctl -u $unit --since "-24h" --no-pager -n 20000 > $OUT/logs/$unit.log 2>/dev/null || rm -f $OUT/logs/$unit.log
+  [ -s $OUT/logs/$unit.log ] || rm -f $OUT/logs/$unit.log
+done
+for container in kubelet kube-proxy etcd kube-apiserver; do
+  chroot /host docker logs --tail 20000 $container > $OUT/logs/rke-$container.log 2>&1 || rm -f $OUT/logs/rke-$container.log
+done
+for file in /var/lib/rancher/rke2/agent/containerd/containerd.log /var/lib/rancher/k3s/agent/containerd/containerd.log; do
+  [ -f /host$file ] && tail -n 20000 /host$file > $OUT/logs/$(echo $file | cut -d/ -f5)-containerd.log
+done
+
+for config in /host/etc/rancher/*/config.yaml /host/etc/rancher/*/config.yaml.d/*.yaml /host/etc/rancher/*/registries.yaml; do
+  [ -f "$config" ] || continue
+  mkdir -p "$OUT/etc-rancher/$(dirname "${config#/host/etc/rancher/}")"
+  cp -L "$config" "$OUT/etc-rancher/${config#/host/etc/rancher/}"
+done
+
+chroot /host df -h > $OUT/system/df.txt 2>&1
+chroot /host df -i > $OUT/system/df-inodes.txt 2>&1
+chroot /host free -m > $OUT/system/free.txt 2>&1
+cat /host/proc/meminfo > $OUT/system/meminfo.txt 2>&1
+chroot /host sysctl -a > $OUT/system/sysctl.txt 2>/dev/null
+chroot /host iptables-save > $OUT/system/iptables.txt 2>&1
+chroot /host ip6tables-save > $OUT/system/ip6tables.txt 2>&1
+chroot /host nft list ruleset > $OUT/system/nftables.txt 2>&1
+cat /host/proc/mounts > $OUT/system/mounts.txt 2>&1
+
+ETCD_TLS=""
+for tls in /var/lib/rancher/rke2/server/tls/etcd /var/lib/rancher/k3s/server/tls/etcd; do
+  [ -f /host$tls/server-client.crt ] && ETCD_TLS="--cacert /host$tls/server-ca.crt --cert /host$tls/server-client.crt --key /host$tls/server-client.key"
+done
+RKE1_CERT=$(ls /host/etc/kubernetes/ssl/kube-etcd-*.pem 2>/dev/null | grep -v -- -key.pem | head -n 1)
+[ -n "$RKE1_CERT" ] && ETCD_TLS="--cacert /host/etc/kubernetes/ssl/kube-ca.pem --cert $RKE1_CERT --key ${RKE1_CERT%.pem}-key.pem"
+if [ -n "$ETCD_TLS" ]; then
+  mkdir -p $OUT/etcd
+  curl -s --max-time 10 $ETCD_TLS -X POST https://127.0.0.1:2379/v3/cluster/member/list -d '{}' > $OUT/etcd/member-list.json 2>&1
+  curl -s --max-time 10 $ETCD_TLS -X POST https://127.0.0.1:2379/v3/maintenance/status -d '{}' > $OUT/etcd/status.json 2>&1
+  curl -s --max-time 10 $ETCD_TLS -X POST https://127.0.0.1:2379/v3/maintenance/alarm -d '{"action":"GET"}' > $OUT/etcd/alarm-list.json 2>&1
+fi
+
+` + nodeArchiveScript
+
+func NodeDiagnosticsDir(dir string) string {
+	nodesDir := dir + "/nodes"
+	err := mkdirAll(nodesDir, 0755)
+	if err != nil {
+		log.Fatalln("Node diagnostics directory creation failed")
+	}
+	return nodesDir
+}
+
+func CollectNodeDiagnostics(settings cli.Cli, tempDirRoot string) {
+	log.Infoln("Collecting node diagnostics")
+
+	nodesDir := NodeDiagnosticsDir(tempDirRoot)
+
+	config, err := kubernetes.GetConfig()
+	if err != nil {
+		log.Fatalln("Failed to connect to upstream cluster")
+	}
+	client, err := kubernetes.GetClient()
+	if err != nil {
+		log.Fatalln("Failed to connect to upstream cluster")
+	}
+
+	NodeDiagnostics(client, ExecCopier(config, client), settings, nodesDir)
+
+	log.Infoln("Node diagnostics collection complete")
+}
+
+// NodeDiagnostics runs nodeDiagnosticsScript on every node and extracts
+// the archive of each node below nodesDir while it's copied.
+func NodeDiagnostics(client k8s.Interface, copyArchive ArchiveCopier, settings cli.Cli, nodesDir string) {
+	nodes, err := RunNodeDaemonSet(client, copyArchive, settings, NodeScript{Prefix: "nodediag", Script: nodeDiagnosticsScript}, func(node string, archive io.Reader) error {
+		log.Infof("Extracting node diagnostics for node: %s", node)
+		return NodeDiagnosticsExtract(archive, nodesDir+"/"+node)
+	})
+	if err != nil {
+		log.Warningf("Node diagnostics DaemonSet failed - Error %s", err)
+	}
+	log.Infof("Node diagnostics finished on %d node(s)", len(nodes))
+}
+
+// NodeScript is a script run on every node by RunNodeDaemonSet. Env is set
+// in the container instead of being formatted into Script, so values read
+// from the cluster can't change what runs on the nodes.
+type NodeScript struct {
+	Prefix string
+	Script string
+	Env    []v1.EnvVar
+}
+
+// ArchiveCopier copies the archive a node script left in pod to w.
+type ArchiveCopier func(pod v1.Pod, w io.Writer) error
+
+// ArchiveHandler reads the archive of node while it's being copied.
+type ArchiveHandler func(node string, archive io.Reader) error
+
+// ExecCopier copies node archives out of the DaemonSet pods with exec.
+func ExecCopier(config *rest.Config, client k8s.Interface) ArchiveCopier {
+	return func(pod v1.Pod, w io.Writer) error {
+		return kubernetes.ExecPod(config, client, pod.Namespace, pod.Name, "collector", []string{"cat", nodeArchivePath}, w)
+	}
+}
+
+// NodeDaemonSet returns a privileged, host-networked DaemonSet that runs
+// script on every node with the host filesystem mounted read-only at /host.
+// Its pods are ready once the script left its archive at nodeArchivePath.
+func NodeDaemonSet(name string, image string, script NodeScript) *appsv1.DaemonSet {
+	labels := map[string]string{"app": name}
+	privileged := true
+	gracePeriod := int64(0)
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"app.kubernetes.io/managed-by": "supportability-collector"},
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: v1.PodSpec{
+					HostNetwork:                   true,
+					HostPID:                       true,
+					DNSPolicy:                     v1.DNSClusterFirstWithHostNet,
+					TerminationGracePeriodSeconds: &gracePeriod,
+					Tolerations:                   []v1.Toleration{{Operator: v1.TolerationOpExists}},
+					Containers: []v1.Container{
+						{
+							Name:            "collector",
+							Image:           image,
+							Command:         []string{"/bin/bash", "-c", script.Script},
+							Env:             script.Env,
+							SecurityContext: &v1.SecurityContext{Privileged: &privileged},
+							VolumeMounts:    []v1.VolumeMount{{Name: "host", MountPath: "/host", ReadOnly: true}},
+							ReadinessProbe: &v1.Probe{
+								ProbeHandler:  v1.ProbeHandler{Exec: &v1.ExecAction{Command: []string{"test", "-f", nodeArchivePath}}},
+								PeriodSeconds: 2,
+							},
+						},
+					},
+					Volumes: []v1.Volume{
+						{
+							Name:         "host",
+							VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/"}},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// RunNodeDaemonSet deploys a short-lived DaemonSet running script, waits
+// for every pod to turn ready and hands the archive of each node to handle
+// as it's copied out, so no archive is held in memory. It returns the
+// nodes that finished, sorted. The DaemonSet is always removed before
+// returning; nodes that finished before NODE_DIAGNOSTICS_TIMEOUT are
+// returned even when others did not. A node whose archive couldn't be
+// copied or handled is logged and still counts as finished.
+func RunNodeDaemonSet(client k8s.Interface, copyArchive ArchiveCopier, settings cli.Cli, script NodeScript, handle ArchiveHandler) ([]string, error) {
+	namespace := settings.NodeDiagnosticsNamespace
+	name := "supportability-" + script.Prefix + "-" + rand.String(5)
+	log.Infof("Deploying DaemonSet %s/%s", namespace, name)
+	_, err := kubernetes.CreateDaemonSet(client, namespace, NodeDaemonSet(name, settings.NodeDiagnosticsImage, script))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		log.Infof("Cleaning up DaemonSet %s/%s", namespace, name)
+		if err := kubernetes.DeleteDaemonSet(client, namespace, name); err != nil {
+			log.Warningf("DaemonSet cleanup failed - Error %s", err)
+		}
+	}()
+
+	finished := map[string]bool{}
+	deadline := time.Now().Add(settings.NodeDiagnosticsTimeout)
+	for {
+		status, err := kubernetes.GetDaemonSetStatus(client, namespace, name)
+		if err != nil {
+			return sortedNodes(finished), err
+		}
+		pods, err := kubernetes.GetPodsBySelector(client, namespace, "app="+name)
+		if err != nil {
+			return sortedNodes(finished), err
+		}
+		sort.Slice(pods, func(i, j int) bool { return pods[i].Spec.NodeName < pods[j].Spec.NodeName })
+		for _, pod := range pods {
+			if finished[pod.Spec.NodeName] || !podReady(pod) {
+				continue
+			}
+			finished[pod.Spec.NodeName] = true
+			err := streamNodeArchive(copyArchive, pod, handle)
+			if err != nil {
+				log.Warningf("Copying the archive of node %s failed - Error %s", pod.Spec.NodeName, err)
+			}
+		}
+		if status.DesiredNumberScheduled > 0 && len(finished) >= int(status.DesiredNumberScheduled) {
+			return sortedNodes(finished), nil
+		}
+		if !time.Now().Before(deadline) {
+			return sortedNodes(finished), fmt.Errorf("timed out after %s with %d node(s) finished", settings.NodeDiagnosticsTimeout, len(finished))
+		}
+		time.Sleep(nodeDaemonSetInterval)
+	}
+}
+
+func sortedNodes(nodes map[string]bool) []string {
+	names := make([]string, 0, len(nodes))
+	for name := range nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func podReady(pod v1.Pod) bool {
+	if pod.Status.Phase != v1.PodRunning {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// streamNodeArchive pipes the archive of pod into handle, failing once
+// it's larger than maxNodeArchiveSize.
+func streamNodeArchive(copyArchive ArchiveCopier, pod v1.Pod, handle ArchiveHandler) error {
+	r, w := io.Pipe()
+	copied := make(chan error, 1)
+	go func() {
+		err := copyArchive(pod, &limitWriter{w: w, remaining: maxNodeArchiveSize})
+		w.CloseWithError(err)
+		copied <- err
+	}()
+	err := handle(pod.Spec.NodeName, r)
+	if err == nil {
+		// Drain what follows the end of the archive so the copy completes
+		_, err = io.Copy(io.Discard, r)
+	}
+	// Closing the reader stops a copy handle gave up on
+	r.Close()
+	copyErr := <-copied
+	if err != nil {
+		return err
+	}
+	return copyErr
+}
+
+// limitWriter fails writes beyond remaining bytes.
+type limitWriter struct {
+	w         io.Writer
+	remaining int64
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > w.remaining {
+		return 0, fmt.Errorf("archive is larger than %d bytes", maxNodeArchiveSize)
+	}
+	w.remaining -= int64(len(p))
+	return w.w.Write(p)
+}
+
+// nodeConfigFile matches the /etc/rancher files kept from a node archive.
+// Only the RKE2 and K3s configuration and registries are copied; anything
+// else, such as node/password and the admin kubeconfig, is a credential
+// as a whole that redaction can't mask.
+var nodeConfigFile = regexp.MustCompile(`^etc-rancher/[^/]+/(config\.yaml|config\.yaml\.d/[^/]+\.yaml|registries\.yaml)$`)
+
+// NodeDiagnosticsExtract unpacks a node archive into dir as it's read,
+// keeping only the configuration files from /etc/rancher and redacting
+// them.
+func NodeDiagnosticsExtract(archive io.Reader, dir string) error {
+	gz, err := gzip.NewReader(archive)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Clean(header.Name)
+		if filepath.IsAbs(name) || strings.HasPrefix(name, "..") {
+			continue
+		}
+		if strings.HasPrefix(name, "etc-rancher/") && header.Typeflag == tar.TypeReg && !nodeConfigFile.MatchString(name) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := mkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := mkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if strings.HasPrefix(name, "etc-rancher/") {
+				err = extractNodeConfig(tr, header.Size, path)
+			} else {
+				err = extractNodeFile(tr, path)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// extractNodeConfig writes a redacted configuration file. They are read
+// whole to be redacted, so larger ones are skipped.
+func extractNodeConfig(r io.Reader, size int64, path string) error {
+	if size > maxNodeConfigSize {
+		log.Warningf("Skipping %s, larger than %d bytes", path, maxNodeConfigSize)
+		return nil
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, Redact(data), 0644)
+}
+
+// extractNodeFile streams a file into the bundle.
+func extractNodeFile(r io.Reader, path string) error {
+	f, err := createFile(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
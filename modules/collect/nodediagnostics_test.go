@@ -0,0 +1,184 @@
+package collect
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// fakeNodes returns a fake clientset that schedules a pod on every node
+// when a DaemonSet is created, ready when ready says so.
+func fakeNodes(t *testing.T, nodes []string, ready func(node string) bool) *fake.Clientset {
+	t.Helper()
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "daemonsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		ds := action.(k8stesting.CreateAction).GetObject().(*appsv1.DaemonSet)
+		ds.Status.DesiredNumberScheduled = int32(len(nodes))
+		for _, node := range nodes {
+			condition := v1.ConditionFalse
+			if ready(node) {
+				condition = v1.ConditionTrue
+			}
+			pod := &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: ds.Name + "-" + node, Namespace: action.GetNamespace(), Labels: ds.Spec.Template.Labels},
+				Spec:       *ds.Spec.Template.Spec.DeepCopy(),
+				Status: v1.PodStatus{
+					Phase:      v1.PodRunning,
+					Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: condition}},
+				},
+			}
+			pod.Spec.NodeName = node
+			err := client.Tracker().Add(pod)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return false, nil, nil
+	})
+	return client
+}
+
+func TestRunNodeDaemonSet(t *testing.T) {
+	nodeDaemonSetInterval = 10 * time.Millisecond
+	maxNodeArchiveSize = 1 << 20
+	archive := nodeArchive(t, map[string]string{"summary.txt": "ok"})
+	tests := []struct {
+		name     string
+		ready    func(node string) bool
+		copy     func(pod v1.Pod, w io.Writer) error
+		finished []string
+		copied   []string
+		err      bool
+	}{
+		{
+			name:     "every node finishes",
+			ready:    func(string) bool { return true },
+			finished: []string{"node-1", "node-2", "node-3"},
+			copied:   []string{"node-1", "node-2", "node-3"},
+		},
+		{
+			name:     "nodes that don't finish time out",
+			ready:    func(node string) bool { return node != "node-2" },
+			finished: []string{"node-1", "node-3"},
+			copied:   []string{"node-1", "node-3"},
+			err:      true,
+		},
+		{
+			name:  "failed copies finish without an archive",
+			ready: func(string) bool { return true },
+			copy: func(pod v1.Pod, w io.Writer) error {
+				if pod.Spec.NodeName == "node-3" {
+					return errors.New("exec failed")
+				}
+				_, err := w.Write(archive)
+				return err
+			},
+			finished: []string{"node-1", "node-2", "node-3"},
+			copied:   []string{"node-1", "node-2"},
+		},
+		{
+			name:  "archives are bounded",
+			ready: func(string) bool { return true },
+			copy: func(pod v1.Pod, w io.Writer) error {
+				_, err := w.Write(make([]byte, maxNodeArchiveSize+1))
+				return err
+			},
+			finished: []string{"node-1", "node-2", "node-3"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := fakeNodes(t, []string{"node-1", "node-2", "node-3"}, test.ready)
+			copyArchive := test.copy
+			if copyArchive == nil {
+				copyArchive = func(pod v1.Pod, w io.Writer) error {
+					_, err := w.Write(archive)
+					return err
+				}
+			}
+			settings := cli.Cli{NodeDiagnosticsNamespace: "cattle-system", NodeDiagnosticsImage: "image", NodeDiagnosticsTimeout: 50 * time.Millisecond}
+			var copied []string
+			nodes, err := RunNodeDaemonSet(client, copyArchive, settings, NodeScript{Prefix: "test", Script: "true", Env: []v1.EnvVar{{Name: "VALUE", Value: "$(reboot)"}}}, func(node string, r io.Reader) error {
+				data, err := io.ReadAll(r)
+				if err != nil {
+					return err
+				}
+				if !bytes.Equal(data, archive) {
+					t.Errorf("archive of %s changed on its way", node)
+				}
+				copied = append(copied, node)
+				return nil
+			})
+			if (err != nil) != test.err {
+				t.Fatalf("error %v, want error %v", err, test.err)
+			}
+			if strings.Join(nodes, ",") != strings.Join(test.finished, ",") {
+				t.Errorf("finished nodes %v, want %v", nodes, test.finished)
+			}
+			if strings.Join(copied, ",") != strings.Join(test.copied, ",") {
+				t.Errorf("copied nodes %v, want %v", copied, test.copied)
+			}
+
+			var created *appsv1.DaemonSet
+			for _, action := range client.Actions() {
+				if create, ok := action.(k8stesting.CreateAction); ok && action.GetResource().Resource == "daemonsets" {
+					created = create.GetObject().(*appsv1.DaemonSet)
+				}
+			}
+			if created == nil {
+				t.Fatal("no DaemonSet was created")
+			}
+			container := created.Spec.Template.Spec.Containers[0]
+			if container.Command[2] != "true" || len(container.Env) != 1 || container.Env[0].Value != "$(reboot)" {
+				t.Errorf("script or environment not passed as is: %v %v", container.Command, container.Env)
+			}
+			if container.ReadinessProbe == nil || container.ReadinessProbe.Exec == nil {
+				t.Error("DaemonSet pods have no readiness probe")
+			}
+			_, err = client.AppsV1().DaemonSets("cattle-system").Get(context.Background(), created.Name, metav1.GetOptions{})
+			if err == nil {
+				t.Error("DaemonSet was not cleaned up")
+			}
+		})
+	}
+}
+
+func TestNodeDiagnostics(t *testing.T) {
+	nodeDaemonSetInterval = 10 * time.Millisecond
+	client := fakeNodes(t, []string{"server-1", "agent-1"}, func(string) bool { return true })
+	copyArchive := func(pod v1.Pod, w io.Writer) error {
+		_, err := w.Write(nodeArchive(t, map[string]string{
+			"system/free.txt":              "Mem: " + pod.Spec.NodeName,
+			"etc-rancher/rke2/config.yaml": "token: K10secret\n",
+		}))
+		return err
+	}
+	dir := t.TempDir()
+	settings := cli.Cli{NodeDiagnosticsNamespace: "cattle-system", NodeDiagnosticsImage: "image", NodeDiagnosticsTimeout: time.Second}
+	NodeDiagnostics(client, copyArchive, settings, dir)
+
+	for _, node := range []string{"server-1", "agent-1"} {
+		data, err := os.ReadFile(filepath.Join(dir, node, "system/free.txt"))
+		if err != nil || string(data) != "Mem: "+node {
+			t.Errorf("%s free.txt is %q - %v", node, data, err)
+		}
+		data, err = os.ReadFile(filepath.Join(dir, node, "etc-rancher/rke2/config.yaml"))
+		if err != nil || NeedsRedaction(data) {
+			t.Errorf("%s config.yaml wasn't extracted redacted - %v:\n%s", node, err, data)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package collect
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	"github.com/mattmattox/supportability-collector/modules/kubernetes"
+	v1 "k8s.io/api/core/v1"
+)
+
+// probeScript tests, from every node, the path an agent takes to the
+// Rancher server plus cluster DNS to the apiserver. Each check writes its own
+// file and appends a key=value pair to summary.txt. The Rancher server URL,
+// host and port are read from the environment set by probeEnv, never
+// formatted into the script; the archive is returned the same way as node
+// diagnostics.
+const probeScript = `
+OUT=$(mktemp -d)
+URL="$RANCHER_URL"
+HOST="$RANCHER_HOST"
+PORT="$RANCHER_PORT"
+SUMMARY=""
+
+if getent hosts "$HOST" > $OUT/dns.txt 2>&1; then SUMMARY="$SUMMARY dns=ok"; else SUMMARY="$SUMMARY dns=failed"; fi
+cat /etc/resolv.conf >> $OUT/dns.txt 2>&1
+
+if timeout 5 bash -c '</dev/tcp/$1/$2' probe "$HOST" "$PORT" > $OUT/tcp.txt 2>&1; then
+  echo "tcp $HOST:$PORT reachable" >> $OUT/tcp.txt; SUMMARY="$SUMMARY tcp=ok"
+else
+  echo "tcp $HOST:$PORT unreachable" >> $OUT/tcp.txt; SUMMARY="$SUMMARY tcp=failed"
+fi
+
+echo | timeout 10 openssl s_client -connect "$HOST:$PORT" -servername "$HOST" -showcerts > $OUT/tls.txt 2>&1
+if grep -q "Verify return code: 0" $OUT/tls.txt; then SUMMARY="$SUMMARY tls=ok"; else SUMMARY="$SUMMARY tls=$(grep -o 'Verify return code: [0-9]*' $OUT/tls.txt | awk '{print "verify-" $4}' | head -n 1)"; fi
+
+for i in 1 2 3 4 5; do
+  curl -sk --max-time 10 -o /dev/null -w "http_code=%{http_code} dns=%{time_namelookup} connect=%{time_connect} tls=%{time_appconnect} total=%{time_total}\n" "$URL/ping" >> $OUT/http-ping.txt 2>&1
+done
+SUMMARY="$SUMMARY http=$(head -n 1 $OUT/http-ping.txt | sed -E 's/^http_code=([0-9]+).*$/\1/')"
+SUMMARY="$SUMMARY total=$(awk -F'total=' '{print $2}' $OUT/http-ping.txt | sort -n | awk '{a[NR]=$1} END {print a[int((NR+1)/2)]}')s"
+
+if getent hosts kubernetes.default.svc.cluster.local > $OUT/cluster-dns.txt 2>&1; then SUMMARY="$SUMMARY cluster-dns=ok"; else SUMMARY="$SUMMARY cluster-dns=failed"; fi
+curl -sk --max-time 10 -w "\nhttp_code=%{http_code} total=%{time_total}\n" https://kubernetes.default.svc.cluster.local/healthz >> $OUT/cluster-dns.txt 2>&1
+
+for link in /sys/class/net/*; do echo "$(basename $link) $(cat $link/mtu)"; done > $OUT/mtu.txt 2>&1
+DEFAULT_DEV=$(ip route show default 2>/dev/null | awk '{print $5; exit}')
+[ -n "$DEFAULT_DEV" ] && SUMMARY="$SUMMARY mtu=$(cat /sys/class/net/$DEFAULT_DEV/mtu)"
+if ping -M do -s 1472 -c 2 -W 2 "$HOST" > $OUT/path-mtu.txt 2>&1; then SUMMARY="$SUMMARY path-mtu-1500=ok"; else SUMMARY="$SUMMARY path-mtu-1500=failed"; fi
+ping -c 5 -W 2 "$HOST" > $OUT/latency.txt 2>&1
+SUMMARY="$SUMMARY rtt=$(tail -n 1 $OUT/latency.txt | awk -F'/' '/rtt|round-trip/ {print $5 "ms"}')"
+
+echo "$SUMMARY" | sed 's/^ //' > $OUT/summary.txt
+
+` + nodeArchiveScript
+
+func ProbesDir(dir string) string {
+	probesDir := dir + "/probes"
+	err := mkdirAll(probesDir, 0755)
+	if err != nil {
+		log.Fatalln("Probes directory creation failed")
+	}
+	return probesDir
+}
+
+func CollectProbes(settings cli.Cli, tempDirRoot string) {
+	log.Infoln("Running connectivity probes")
+
+	probesDir := ProbesDir(tempDirRoot)
+
+	config, err := kubernetes.GetConfig()
+	if err != nil {
+		log.Fatalln("Failed to connect to upstream cluster")
+	}
+	client, err := kubernetes.GetClient()
+	if err != nil {
+		log.Fatalln("Failed to connect to upstream cluster")
+	}
+
+	serverURL, err := kubernetes.GetRancherServerURL(config)
+	if err != nil || serverURL == "" {
+		log.Warningf("Rancher server URL lookup failed, skipping probes - Error %v", err)
+		return
+	}
+	env, err := probeEnv(serverURL)
+	if err != nil {
+		log.Warningf("Rancher server URL %q can't be probed, skipping probes - Error %s", serverURL, err)
+		return
+	}
+	log.Infof("Probing Rancher server URL: %s", serverURL)
+
+	nodes, err := RunNodeDaemonSet(client, ExecCopier(config, client), settings, NodeScript{Prefix: "probe", Script: probeScript, Env: env}, func(node string, archive io.Reader) error {
+		return NodeDiagnosticsExtract(archive, probesDir+"/"+node)
+	})
+	if err != nil {
+		log.Warningf("Probe DaemonSet failed - Error %s", err)
+	}
+
+	var summary []string
+	for _, node := range nodes {
+		nodeSummary, err := readFile(probesDir + "/" + node + "/summary.txt")
+		if err != nil {
+			nodeSummary = []byte("no summary")
+		}
+		summary = append(summary, node+" "+strings.TrimSpace(string(nodeSummary)))
+	}
+	sort.Strings(summary)
+	summary = append([]string{"Rancher server URL: " + serverURL}, summary...)
+	err = writeFile(probesDir+"/probe-summary.txt", []byte(strings.Join(summary, "\n")+"\n"), 0644)
+	if err != nil {
+		log.Warningf("Probe summary write failed - Error %s", err)
+	}
+
+	log.Infoln("Connectivity probes complete")
+}
+
+// validProbeHost matches the host names and IP addresses probeScript
+// accepts, so a host can't be taken for an option of the tools it runs.
+var validProbeHost = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9.-]*[a-zA-Z0-9])?|[0-9a-fA-F:]*:[0-9a-fA-F:.]*)$`)
+
+// probeEnv returns the environment of probeScript for the Rancher server
+// URL.
+func probeEnv(serverURL string) ([]v1.EnvVar, error) {
+	target, err := url.Parse(strings.TrimSuffix(serverURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if target.Scheme != "https" && target.Scheme != "http" {
+		return nil, fmt.Errorf("scheme %q isn't http or https", target.Scheme)
+	}
+	if !validProbeHost.MatchString(target.Hostname()) {
+		return nil, fmt.Errorf("host %q isn't a host name or IP address", target.Hostname())
+	}
+	port := target.Port()
+	if port == "" {
+		port = "443"
+		if target.Scheme == "http" {
+			port = "80"
+		}
+	}
+	return []v1.EnvVar{
+		{Name: "RANCHER_URL", Value: target.String()},
+		{Name: "RANCHER_HOST", Value: target.Hostname()},
+		{Name: "RANCHER_PORT", Value: port},
+	}, nil
+}
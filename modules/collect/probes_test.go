@@ -0,0 +1,99 @@
+package collect
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProbeEnv(t *testing.T) {
+	tests := []struct {
+		name      string
+		serverURL string
+		url       string
+		host      string
+		port      string
+		err       bool
+	}{
+		{
+			name:      "https defaults to 443",
+			serverURL: "https://rancher.example.com/",
+			url:       "https://rancher.example.com",
+			host:      "rancher.example.com",
+			port:      "443",
+		},
+		{
+			name:      "http defaults to 80",
+			serverURL: "http://10.0.0.1",
+			url:       "http://10.0.0.1",
+			host:      "10.0.0.1",
+			port:      "80",
+		},
+		{
+			name:      "explicit port",
+			serverURL: "https://rancher.example.com:8443",
+			url:       "https://rancher.example.com:8443",
+			host:      "rancher.example.com",
+			port:      "8443",
+		},
+		{
+			name:      "IPv6",
+			serverURL: "https://[fd00::1]:8443",
+			url:       "https://[fd00::1]:8443",
+			host:      "fd00::1",
+			port:      "8443",
+		},
+		{
+			name:      "shell in the URL stays data",
+			serverURL: `https://rancher.example.com/"$(reboot)"`,
+			url:       `https://rancher.example.com/%22$%28reboot%29%22`,
+			host:      "rancher.example.com",
+			port:      "443",
+		},
+		{
+			name:      "other schemes",
+			serverURL: "file:///etc/shadow",
+			err:       true,
+		},
+		{
+			name:      "hosts that aren't names",
+			serverURL: "https://-oProxyCommand=reboot",
+			err:       true,
+		},
+		{
+			name:      "no host",
+			serverURL: "https://",
+			err:       true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env, err := probeEnv(test.serverURL)
+			if (err != nil) != test.err {
+				t.Fatalf("error %v, want error %v", err, test.err)
+			}
+			if test.err {
+				return
+			}
+			got := map[string]string{}
+			for _, variable := range env {
+				got[variable.Name] = variable.Value
+			}
+			if got["RANCHER_URL"] != test.url || got["RANCHER_HOST"] != test.host || got["RANCHER_PORT"] != test.port {
+				t.Errorf("environment %v, want %s %s %s", got, test.url, test.host, test.port)
+			}
+		})
+	}
+}
+
+// TestProbeScriptQuotesEnvironment checks the probe script only reads the
+// Rancher server URL from its environment and never formats it in.
+func TestProbeScriptQuotesEnvironment(t *testing.T) {
+	for _, variable := range []string{"RANCHER_URL", "RANCHER_HOST", "RANCHER_PORT"} {
+		if !strings.Contains(probeScript, `"$`+variable+`"`) {
+			t.Errorf("probe script doesn't read %s quoted", variable)
+		}
+	}
+	if strings.Contains(probeScript, "%[") || strings.Contains(probeScript, "%%") {
+		t.Error("probe script has format verbs")
+	}
+}
@@ -0,0 +1,88 @@
+package collect
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/mattmattox/supportability-collector/modules/cli"
+)
+
+// Collector is one step of a collection. Enabled, when set, gates
+// collectors that change the cluster behind their own setting.
+type Collector struct {
+	Name    string
+	Enabled func(settings cli.Cli) bool
+	Collect func(settings cli.Cli, tempDirRoot string)
+}
+
+// Collectors run in this order in every profile.
+var Collectors = []Collector{
+	{Name: "rancher", Collect: CollectRancherData},
+	{Name: "upstream", Collect: func(_ cli.Cli, dir string) { CollectUpstreamCluster(dir) }},
+	{Name: "webhooks", Collect: func(_ cli.Cli, dir string) { CollectWebhooks(dir) }},
+	{Name: "node-diagnostics", Collect: CollectNodeDiagnostics, Enabled: func(settings cli.Cli) bool { return settings.NodeDiagnostics }},
+	{Name: "etcd", Collect: CollectEtcd},
+	{Name: "metrics", Collect: CollectMetrics},
+	{Name: "storage", Collect: func(_ cli.Cli, dir string) { CollectStorage(dir) }},
+	{Name: "configmaps", Collect: CollectConfigMaps},
+	{Name: "networking", Collect: CollectNetworking},
+	{Name: "probes", Collect: CollectProbes, Enabled: func(settings cli.Cli) bool { return settings.Probes }},
+	{Name: "deprecated-apis", Collect: func(_ cli.Cli, dir string) { CollectDeprecatedAPIs(dir) }},
+	{Name: "certificates", Collect: CollectCertificates},
+}
+
+// DefaultProfile collects everything.
+const DefaultProfile = "full"
+
+// Profiles name the collectors run for common kinds of cases. A nil list
+// runs every collector.
+var Profiles = map[string][]string{
+	DefaultProfile: nil,
+	"minimal":      {"rancher", "upstream", "certificates"},
+	"networking":   {"rancher", "upstream", "webhooks", "networking", "probes"},
+	"performance":  {"rancher", "upstream", "etcd", "metrics"},
+	"storage":      {"rancher", "upstream", "storage"},
+	"upgrade":      {"rancher", "upstream", "webhooks", "deprecated-apis", "certificates"},
+}
+
+// ProfileNames returns the known profiles in order.
+func ProfileNames() []string {
+	var names []string
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProfileCollectors returns the collectors of a profile that the settings
+// allow to run. An empty profile is the default one.
+func ProfileCollectors(settings cli.Cli, profile string) ([]Collector, error) {
+	if profile == "" {
+		profile = DefaultProfile
+	}
+	names, ok := Profiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q", profile)
+	}
+	var collectors []Collector
+	for _, collector := range Collectors {
+		if names != nil && !containsString(names, collector.Name) {
+			continue
+		}
+		if collector.Enabled != nil && !collector.Enabled(settings) {
+			continue
+		}
+		collectors = append(collectors, collector)
+	}
+	return collectors, nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/mattmattox/supportability-collector/modules/cli"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
@@ -16,7 +17,7 @@ type RancherInfo struct {
 	EulaDate  string    `yaml:"eulaDate"`
 }
 
-func CollectRancherData(tempDirRoot string) {
+func CollectRancherData(settings cli.Cli, tempDirRoot string) {
 	log.Infoln("Collecting Rancher Data")
 
 	rancherDataDir := RancherDataDir(tempDirRoot)
@@ -68,10 +69,11 @@ func CollectRancherData(tempDirRoot string) {
 
 	// Collecting Rancher resources
 	rancherResourceDir := RancherResourcesDir(tempDirRoot)
-	RancherResourcesClusters(config, rancherResourceDir)
-	RancherResourcesClusterNodes(config, rancherResourceDir)
-	RancherResourcesClusterNodePools(config, rancherResourceDir)
-	//RancherResourcesClusterNodeTemplates(config, rancherResourceDir)
+	RancherResourcesClusters(config, rancherResourceDir, settings.Clusters)
+	RancherResourcesClusterNodes(config, rancherResourceDir, settings.Clusters)
+	RancherResourcesClusterNodePools(config, rancherResourceDir, settings.Clusters)
+	CollectCustomResource(config, CustomResource{Group: "management.cattle.io", Version: "v3", Resource: "settings"}, rancherResourceDir)
+	//RancherResourcesClusterNodeTemplates(config, rancherResourceDir, settings.Clusters)
 	//RancherResourcesClusterTemplates(config, rancherResourceDir)
 	//RancherResourcesClusterTemplateRevisions(config, rancherResourceDir)
 	//RancherResourcesFeatures(config, rancherResourceDir)
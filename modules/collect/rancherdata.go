@@ -2,7 +2,6 @@ package collect
 
 import (
 	"encoding/json"
-	"os"
 
 	"github.com/mattmattox/supportability-collector/modules/kubernetes"
 	"gopkg.in/yaml.v3"
@@ -11,7 +10,7 @@ import (
 
 func RancherDataDir(dir string) string {
 	rancherDataDir := dir + "/rancher-data"
-	err := os.MkdirAll(rancherDataDir, 0755)
+	err := mkdirAll(rancherDataDir, 0755)
 	if err != nil {
 		log.Fatalln("Rancher install YAML folder creation failed")
 	}
@@ -19,28 +18,32 @@ func RancherDataDir(dir string) string {
 }
 
 func RancherDataWriteYaml(dir string, RancherData *RancherInfo) {
-	rancherDataYaml, err := os.Create(dir + "/rancher-data.yaml")
+	rancherDataYaml, err := createFile(dir + "/rancher-data.yaml")
 	if err != nil {
 		log.Fatalln("Rancher install YAML file creation failed")
 	}
-	defer rancherDataYaml.Close()
 	yamlEncoder := yaml.NewEncoder(rancherDataYaml)
 	yamlEncoder.SetIndent(2)
 	err = yamlEncoder.Encode(RancherData)
+	if closeErr := rancherDataYaml.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		log.Fatalln("Rancher install YAML file write failed")
 	}
 }
 
 func RancherDataWriteJson(dir string, RancherData *RancherInfo) {
-	rancherDataJson, err := os.Create(dir + "/rancher-data.json")
+	rancherDataJson, err := createFile(dir + "/rancher-data.json")
 	if err != nil {
 		log.Fatalln("Rancher install JSON file creation failed")
 	}
-	defer rancherDataJson.Close()
 	jsonEncoder := json.NewEncoder(rancherDataJson)
 	jsonEncoder.SetIndent("", "  ")
 	err = jsonEncoder.Encode(RancherData)
+	if closeErr := rancherDataJson.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		log.Fatalln("Rancher install JSON file write failed")
 	}
@@ -49,7 +52,7 @@ func RancherDataWriteJson(dir string, RancherData *RancherInfo) {
 func RancherDataVersion(config *rest.Config, dir string) string {
 	rancherVersion, err := kubernetes.GetRancherVersion(config)
 	if err != nil {
-		log.Fatalln("Rancher version collection failed")
+		log.Fatalf("Rancher version collection failed - Error %s", err)
 	}
 	log.Infof("Rancher version: %s", rancherVersion)
 	return rancherVersion
@@ -58,7 +61,7 @@ func RancherDataVersion(config *rest.Config, dir string) string {
 func RancherDataUUID(config *rest.Config, dir string) string {
 	rancherUUID, err := kubernetes.GetRancherUUID(config)
 	if err != nil {
-		log.Fatalln("Rancher UUID collection failed")
+		log.Fatalf("Rancher UUID collection failed - Error %s", err)
 	}
 	log.Infof("Rancher UUID: %s", rancherUUID)
 	return rancherUUID
@@ -67,7 +70,7 @@ func RancherDataUUID(config *rest.Config, dir string) string {
 func RancherDataServerUrl(config *rest.Config, dir string) string {
 	rancherURL, err := kubernetes.GetRancherServerURL(config)
 	if err != nil {
-		log.Fatalln("Rancher Server URL collection failed")
+		log.Fatalf("Rancher Server URL collection failed - Error %s", err)
 	}
 	log.Infof("Rancher Server URL: %s", rancherURL)
 	return rancherURL
@@ -76,7 +79,7 @@ func RancherDataServerUrl(config *rest.Config, dir string) string {
 func RancherDataEulaDate(config *rest.Config, dir string) string {
 	rancherEulaDate, err := kubernetes.GetRancherEulaDate(config)
 	if err != nil {
-		log.Fatalln("Rancher EULA date collection failed")
+		log.Fatalf("Rancher EULA date collection failed - Error %s", err)
 	}
 	log.Infof("Rancher EULA date: %s", rancherEulaDate)
 	return rancherEulaDate
@@ -1,105 +1,123 @@
 package collect
 
 import (
-	"os"
-
 	"github.com/mattmattox/supportability-collector/modules/kubernetes"
-	"gopkg.in/yaml.v2"
 	"k8s.io/client-go/rest"
 )
 
 func RancherResourcesDir(dir string) string {
 	rancherDataDir := dir + "/rancher-resources"
-	err := os.Mkdir(rancherDataDir, 0755)
+	err := mkdirAll(rancherDataDir, 0755)
 	if err != nil {
-		log.Warningln("Rancher install YAML folder creation failed - Error %s", err)
+		log.Warningf("Rancher install YAML folder creation failed - Error %s", err)
 	}
 	return rancherDataDir
 }
 
-func RancherResourcesClusters(config *rest.Config, dir string) {
+// RancherClusters returns the IDs of the Rancher clusters to collect: all
+// of them, or those in selected when it isn't empty.
+func RancherClusters(config *rest.Config, selected []string) ([]string, error) {
 	clusters, err := kubernetes.GetRancherClusters(config)
+	if err != nil || len(selected) == 0 {
+		return clusters, err
+	}
+	var filtered []string
+	for _, cluster := range clusters {
+		if containsString(selected, cluster) {
+			filtered = append(filtered, cluster)
+		}
+	}
+	for _, cluster := range selected {
+		if !containsString(clusters, cluster) {
+			log.Warningf("Rancher cluster %s was requested but doesn't exist", cluster)
+		}
+	}
+	return filtered, nil
+}
+
+func RancherResourcesClusters(config *rest.Config, dir string, selected []string) {
+	clusters, err := RancherClusters(config, selected)
 	if err != nil {
-		log.Warningln("Rancher get clusters failed - Error %s", err)
+		log.Warningf("Rancher get clusters failed - Error %s", err)
 	}
 	clusterDir := dir + "/clusters"
-	err = os.Mkdir(clusterDir, 0755)
+	err = mkdirAll(clusterDir, 0755)
 	if err != nil {
-		log.Warningln("Rancher clusters YAML folder creation failed - Error %s", err)
+		log.Warningf("Rancher clusters YAML folder creation failed - Error %s", err)
 	}
 	for _, cluster := range clusters {
 		log.Infof("Grabbing Rancher cluster: %s", cluster)
 		clusterData, err := kubernetes.GetRancherClusterYaml(config, cluster)
 		if err != nil {
-			log.Warningln("Rancher cluster YAML collection failed - Error %s", err)
+			log.Warningf("Rancher cluster YAML collection failed - Error %s", err)
 		}
-		clusterYaml, err := yaml.Marshal(clusterData)
+		clusterYaml := []byte(clusterData)
+		file, err := createFile(clusterDir + "/" + cluster + ".yaml")
 		if err != nil {
-			log.Warningln("Rancher cluster YAML marshalling failed - Error %s", err)
+			log.Warningf("Rancher cluster YAML file creation failed - Error %s", err)
+			continue
 		}
-		file, err := os.Create(clusterDir + "/" + cluster + ".yaml")
-		if err != nil {
-			log.Warningln("Rancher cluster YAML file creation failed - Error %s", err)
-		}
-		defer file.Close()
 		_, err = file.Write(clusterYaml)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
 		if err != nil {
-			log.Warningln("Rancher cluster YAML file write failed - Error %s", err)
+			log.Warningf("Rancher cluster YAML file write failed - Error %s", err)
 		}
 	}
 }
 
-func RancherResourcesClusterNodes(config *rest.Config, dir string) {
-	clusters, err := kubernetes.GetRancherClusters(config)
+func RancherResourcesClusterNodes(config *rest.Config, dir string, selected []string) {
+	clusters, err := RancherClusters(config, selected)
 	if err != nil {
-		log.Warningln("Rancher cluster nodes failed - Error %s", err)
+		log.Warningf("Rancher cluster nodes failed - Error %s", err)
 	}
 	clusterDir := dir + "/cluster-nodes"
-	err = os.Mkdir(clusterDir, 0755)
+	err = mkdirAll(clusterDir, 0755)
 	if err != nil {
-		log.Warningln("Rancher cluster node YAML folder creation failed - Error %s", err)
+		log.Warningf("Rancher cluster node YAML folder creation failed - Error %s", err)
 	}
 	for _, cluster := range clusters {
 		log.Infof("Grabbing Rancher cluster nodes: %s", cluster)
 		clusterNodes, err := kubernetes.GetRancherClusterNodes(config, cluster)
 		if err != nil {
-			log.Warningln("Rancher cluster node collection failed - Error %s", err)
+			log.Warningf("Rancher cluster node collection failed - Error %s", err)
 		}
 		clusterNodeDir := clusterDir + "/" + cluster
-		err = os.Mkdir(clusterNodeDir, 0755)
+		err = mkdirAll(clusterNodeDir, 0755)
 		if err != nil {
-			log.Warningln("Rancher cluster node YAML folder creation failed - Error %s", err)
+			log.Warningf("Rancher cluster node YAML folder creation failed - Error %s", err)
 		}
 		for _, node := range clusterNodes {
 			log.Infof("Grabbing Rancher cluster node: %s", node)
 			nodeData, err := kubernetes.GetRancherClusterNodeYaml(config, cluster, node)
 			if err != nil {
-				log.Warningln("Rancher cluster node YAML collection failed - Error %s", err)
+				log.Warningf("Rancher cluster node YAML collection failed - Error %s", err)
 			}
-			nodeYaml, err := yaml.Marshal(nodeData)
+			nodeYaml := []byte(nodeData)
+			file, err := createFile(clusterNodeDir + "/" + node + ".yaml")
 			if err != nil {
-				log.Warningln("Rancher cluster node YAML marshalling failed - Error %s", err)
+				log.Warningf("Rancher cluster node YAML file creation failed - Error %s", err)
+				continue
 			}
-			file, err := os.Create(clusterNodeDir + "/" + node + ".yaml")
-			if err != nil {
-				log.Warningln("Rancher cluster node YAML file creation failed - Error %s", err)
-			}
-			defer file.Close()
 			_, err = file.Write(nodeYaml)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
 			if err != nil {
-				log.Warningln("Rancher cluster node YAML file write failed - Error %s", err)
+				log.Warningf("Rancher cluster node YAML file write failed - Error %s", err)
 			}
 		}
 	}
 }
 
-func RancherResourcesClusterNodePools(config *rest.Config, dir string) {
-	clusters, err := kubernetes.GetRancherClusters(config)
+func RancherResourcesClusterNodePools(config *rest.Config, dir string, selected []string) {
+	clusters, err := RancherClusters(config, selected)
 	if err != nil {
 		log.Warningln("Rancher cluster collection failed")
 	}
 	clusterDir := dir + "/cluster-node-pools"
-	err = os.Mkdir(clusterDir, 0755)
+	err = mkdirAll(clusterDir, 0755)
 	if err != nil {
 		log.Warningln("Rancher cluster node pool YAML folder creation failed")
 	}
@@ -110,7 +128,7 @@ func RancherResourcesClusterNodePools(config *rest.Config, dir string) {
 			log.Warningln("Rancher cluster node pool collection failed")
 		}
 		clusterNodePoolDir := clusterDir + "/" + cluster
-		err = os.Mkdir(clusterNodePoolDir, 0755)
+		err = mkdirAll(clusterNodePoolDir, 0755)
 		if err != nil {
 			log.Warningln("Rancher cluster node pool YAML folder creation failed")
 		}
@@ -120,16 +138,16 @@ func RancherResourcesClusterNodePools(config *rest.Config, dir string) {
 			if err != nil {
 				log.Warningln("Rancher cluster node pool YAML collection failed")
 			}
-			nodePoolYaml, err := yaml.Marshal(nodePoolData)
-			if err != nil {
-				log.Warningln("Rancher cluster node pool YAML marshalling failed")
-			}
-			file, err := os.Create(clusterNodePoolDir + "/" + nodePool + ".yaml")
+			nodePoolYaml := []byte(nodePoolData)
+			file, err := createFile(clusterNodePoolDir + "/" + nodePool + ".yaml")
 			if err != nil {
 				log.Warningln("Rancher cluster node pool YAML file creation failed")
+				continue
 			}
-			defer file.Close()
 			_, err = file.Write(nodePoolYaml)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
 			if err != nil {
 				log.Warningln("Rancher cluster node pool YAML file write failed")
 			}
@@ -137,13 +155,13 @@ func RancherResourcesClusterNodePools(config *rest.Config, dir string) {
 	}
 }
 
-func RancherResourcesClusterNodeTemplates(config *rest.Config, dir string) {
-	clusters, err := kubernetes.GetRancherClusters(config)
+func RancherResourcesClusterNodeTemplates(config *rest.Config, dir string, selected []string) {
+	clusters, err := RancherClusters(config, selected)
 	if err != nil {
 		log.Warningln("Rancher cluster collection failed")
 	}
 	clusterDir := dir + "/cluster-node-templates"
-	err = os.Mkdir(clusterDir, 0755)
+	err = mkdirAll(clusterDir, 0755)
 	if err != nil {
 		log.Warningln("Rancher cluster node template YAML folder creation failed")
 	}
@@ -154,7 +172,7 @@ func RancherResourcesClusterNodeTemplates(config *rest.Config, dir string) {
 			log.Warningln("Rancher cluster node template collection failed")
 		}
 		clusterNodeTemplateDir := clusterDir + "/" + cluster
-		err = os.Mkdir(clusterNodeTemplateDir, 0755)
+		err = mkdirAll(clusterNodeTemplateDir, 0755)
 		if err != nil {
 			log.Warningln("Rancher cluster node template YAML folder creation failed")
 		}
@@ -164,16 +182,16 @@ func RancherResourcesClusterNodeTemplates(config *rest.Config, dir string) {
 			if err != nil {
 				log.Warningln("Rancher cluster node template YAML collection failed")
 			}
-			nodeTemplateYaml, err := yaml.Marshal(nodeTemplateData)
-			if err != nil {
-				log.Warningln("Rancher cluster node template YAML marshalling failed")
-			}
-			file, err := os.Create(clusterNodeTemplateDir + "/" + nodeTemplate + ".yaml")
+			nodeTemplateYaml := []byte(nodeTemplateData)
+			file, err := createFile(clusterNodeTemplateDir + "/" + nodeTemplate + ".yaml")
 			if err != nil {
 				log.Warningln("Rancher cluster node template YAML file creation failed")
+				continue
 			}
-			defer file.Close()
 			_, err = file.Write(nodeTemplateYaml)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
 			if err != nil {
 				log.Warningln("Rancher cluster node template YAML file write failed")
 			}
@@ -187,7 +205,7 @@ func RancherResourcesClusterTemplates(config *rest.Config, dir string) {
 		log.Warningln("Rancher cluster template collection failed")
 	}
 	clusterTemplateDir := dir + "/cluster-templates"
-	err = os.Mkdir(clusterTemplateDir, 0755)
+	err = mkdirAll(clusterTemplateDir, 0755)
 	if err != nil {
 		log.Warningln("Rancher cluster template YAML folder creation failed")
 	}
@@ -197,16 +215,16 @@ func RancherResourcesClusterTemplates(config *rest.Config, dir string) {
 		if err != nil {
 			log.Warningln("Rancher cluster template YAML collection failed")
 		}
-		clusterTemplateYaml, err := yaml.Marshal(clusterTemplateData)
-		if err != nil {
-			log.Warningln("Rancher cluster template YAML marshalling failed")
-		}
-		file, err := os.Create(clusterTemplateDir + "/" + clusterTemplate + ".yaml")
+		clusterTemplateYaml := []byte(clusterTemplateData)
+		file, err := createFile(clusterTemplateDir + "/" + clusterTemplate + ".yaml")
 		if err != nil {
 			log.Warningln("Rancher cluster template YAML file creation failed")
+			continue
 		}
-		defer file.Close()
 		_, err = file.Write(clusterTemplateYaml)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
 		if err != nil {
 			log.Warningln("Rancher cluster template YAML file write failed")
 		}
@@ -219,7 +237,7 @@ func RancherResourcesClusterTemplateRevisions(config *rest.Config, dir string) {
 		log.Warningln("Rancher cluster template collection failed")
 	}
 	clusterTemplateRevisionDir := dir + "/cluster-template-revisions"
-	err = os.Mkdir(clusterTemplateRevisionDir, 0755)
+	err = mkdirAll(clusterTemplateRevisionDir, 0755)
 	if err != nil {
 		log.Warningln("Rancher cluster template revision YAML folder creation failed")
 	}
@@ -230,7 +248,7 @@ func RancherResourcesClusterTemplateRevisions(config *rest.Config, dir string) {
 			log.Warningln("Rancher cluster template revision collection failed")
 		}
 		clusterTemplateRevisionTemplateDir := clusterTemplateRevisionDir + "/" + clusterTemplate
-		err = os.Mkdir(clusterTemplateRevisionTemplateDir, 0755)
+		err = mkdirAll(clusterTemplateRevisionTemplateDir, 0755)
 		if err != nil {
 			log.Warningln("Rancher cluster template revision YAML folder creation failed")
 		}
@@ -240,16 +258,16 @@ func RancherResourcesClusterTemplateRevisions(config *rest.Config, dir string) {
 			if err != nil {
 				log.Warningln("Rancher cluster template revision YAML collection failed")
 			}
-			clusterTemplateRevisionYaml, err := yaml.Marshal(clusterTemplateRevisionData)
-			if err != nil {
-				log.Warningln("Rancher cluster template revision YAML marshalling failed")
-			}
-			file, err := os.Create(clusterTemplateRevisionTemplateDir + "/" + clusterTemplateRevision + ".yaml")
+			clusterTemplateRevisionYaml := []byte(clusterTemplateRevisionData)
+			file, err := createFile(clusterTemplateRevisionTemplateDir + "/" + clusterTemplateRevision + ".yaml")
 			if err != nil {
 				log.Warningln("Rancher cluster template revision YAML file creation failed")
+				continue
 			}
-			defer file.Close()
 			_, err = file.Write(clusterTemplateRevisionYaml)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
 			if err != nil {
 				log.Warningln("Rancher cluster template revision YAML file write failed")
 			}
@@ -263,7 +281,7 @@ func RancherResourcesFeatures(config *rest.Config, dir string) {
 		log.Warningln("Rancher feature collection failed")
 	}
 	featureDir := dir + "/features"
-	err = os.Mkdir(featureDir, 0755)
+	err = mkdirAll(featureDir, 0755)
 	if err != nil {
 		log.Warningln("Rancher feature YAML folder creation failed")
 	}
@@ -273,16 +291,16 @@ func RancherResourcesFeatures(config *rest.Config, dir string) {
 		if err != nil {
 			log.Warningln("Rancher feature YAML collection failed")
 		}
-		featureYaml, err := yaml.Marshal(featureData)
-		if err != nil {
-			log.Warningln("Rancher feature YAML marshalling failed")
-		}
-		file, err := os.Create(featureDir + "/" + featureid + ".yaml")
+		featureYaml := []byte(featureData)
+		file, err := createFile(featureDir + "/" + featureid + ".yaml")
 		if err != nil {
 			log.Warningln("Rancher feature YAML file creation failed")
+			continue
 		}
-		defer file.Close()
 		_, err = file.Write(featureYaml)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
 		if err != nil {
 			log.Warningln("Rancher feature YAML file write failed")
 		}
@@ -0,0 +1,76 @@
+package collect
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"regexp"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const redacted = "REDACTED"
+
+// RedactionRules are applied to configuration files before they are written
+// into the bundle. Each rule keeps its first capture group and replaces the
+// rest of the match.
+var RedactionRules = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)((?:^|[^a-z0-9])(?:token|password|passwd|secret|credential|access[-_]?key|private[-_]?key)[\w-]*["']?\s*[:=]\s*["']?)[^"'\s,}]+`),
+	regexp.MustCompile(`(-----BEGIN [A-Z ]*PRIVATE KEY-----)[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`),
+	// kubeconfig credentials, such as the admin kubeconfig of RKE2 and K3s
+	regexp.MustCompile(`(?i)((?:^|[^a-z0-9])client-(?:key|certificate)-data["']?\s*:\s*["']?)[^"'\s,}]+`),
+	// registry credentials, such as the registries.yaml of RKE2 and K3s and
+	// Docker config.json
+	regexp.MustCompile(`(?i)((?:^|[^a-z0-9])(?:auth|identity[-_]?token)["']?[ \t]*:[ \t]*["']?)[^"'\s,}]+`),
+}
+
+// Redact returns data with every RedactionRules match masked.
+func Redact(data []byte) []byte {
+	for _, rule := range RedactionRules {
+		data = rule.ReplaceAll(data, []byte("${1}"+redacted))
+	}
+	return data
+}
+
+// LoadRedactionRules appends the rules in path, one regular expression per
+// line, to RedactionRules. Blank lines and lines starting with # are ignored.
+// It's called once at start-up, Redact reads RedactionRules without locking
+// while collections run.
+func LoadRedactionRules(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := regexp.Compile(line)
+		if err != nil {
+			return err
+		}
+		RedactionRules = append(RedactionRules, rule)
+	}
+	return scanner.Err()
+}
+
+// RedactConfigMap masks every data value of configmap in place. Binary
+// values are dropped as they can't be inspected.
+func RedactConfigMap(configmap *v1.ConfigMap) {
+	for key, value := range configmap.Data {
+		configmap.Data[key] = string(Redact([]byte(value)))
+	}
+	for key := range configmap.BinaryData {
+		configmap.BinaryData[key] = []byte(redacted)
+	}
+}
+
+// NeedsRedaction reports whether data still contains anything the
+// redaction rules would mask.
+func NeedsRedaction(data []byte) bool {
+	return !bytes.Equal(Redact(data), data)
+}
@@ -0,0 +1,519 @@
+package collect
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattmattox/supportability-collector/modules/analyze"
+	"github.com/mattmattox/supportability-collector/modules/manifest"
+)
+
+// Sink receives the files of a bundle as the collectors write them.
+type Sink interface {
+	// Create returns a writer for the file name, relative to the bundle
+	// root. The file is part of the bundle once the writer is closed.
+	Create(name string) (io.WriteCloser, error)
+	// Mkdir creates the directory name and its parents.
+	Mkdir(name string) error
+	// Close completes the bundle.
+	Close() error
+	// Abort discards a partially written bundle.
+	Abort()
+}
+
+// DirSink writes a bundle into a plain directory, for browsing it without
+// extracting and for debugging collectors.
+type DirSink struct {
+	root string
+}
+
+func NewDirSink(root string) *DirSink {
+	return &DirSink{root: root}
+}
+
+func (s *DirSink) Create(name string) (io.WriteCloser, error) {
+	path := filepath.Join(s.root, filepath.FromSlash(name))
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+func (s *DirSink) Mkdir(name string) error {
+	return os.MkdirAll(filepath.Join(s.root, filepath.FromSlash(name)), 0755)
+}
+
+func (s *DirSink) Close() error {
+	return nil
+}
+
+func (s *DirSink) Abort() {
+	os.RemoveAll(s.root)
+}
+
+// ArchiveSink streams a bundle into an archive, encrypted on the fly when
+// encryption isn't nil. Archive entries are written one at a time, so each
+// open file is held until it is closed: in memory while the files being
+// written fit in the buffer size, in a scratch file otherwise. Entries are
+// rooted under a single directory and each directory is written before
+// its first entry. The archive is written next to dst with a .partial
+// suffix and renamed once complete.
+type ArchiveSink struct {
+	dst     string
+	scratch string
+	limit   int64
+	root    string
+
+	out       *os.File
+	encrypted io.WriteCloser
+	archive   archiveWriter
+	// mu serializes the entries written to the archive and guards dirs.
+	mu   sync.Mutex
+	dirs map[string]bool
+
+	bufferMu sync.Mutex
+	buffered int64
+}
+
+// ArchiveOptions configure an ArchiveSink. Files that don't fit in
+// BufferSize bytes of memory spill into Scratch. Root is the top-level
+// directory of the archive and ModTime the date of every entry.
+type ArchiveOptions struct {
+	Format      string
+	Compression Compression
+	Encryption  Encryption
+	BufferSize  int64
+	Scratch     string
+	Root        string
+	ModTime     time.Time
+}
+
+// NewArchiveSink creates the archive for dst.
+func NewArchiveSink(dst string, options ArchiveOptions) (*ArchiveSink, error) {
+	out, err := os.Create(dst + ".partial")
+	if err != nil {
+		return nil, err
+	}
+	s := &ArchiveSink{dst: dst, scratch: options.Scratch, limit: options.BufferSize, root: options.Root, out: out, dirs: map[string]bool{}}
+	var w io.Writer = out
+	if options.Encryption != nil {
+		s.encrypted, err = options.Encryption.Encrypt(out)
+		if err != nil {
+			out.Close()
+			os.Remove(out.Name())
+			return nil, err
+		}
+		w = s.encrypted
+	}
+	s.archive, err = newArchiveWriter(options.Format, w, options.Compression, options.ModTime)
+	if err == nil {
+		err = s.mkdir("")
+	}
+	if err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *ArchiveSink) Create(name string) (io.WriteCloser, error) {
+	return &archiveEntry{sink: s, name: name}, nil
+}
+
+// Mkdir writes the directory entries of name and its parents, so empty
+// directories are kept like in a directory bundle.
+func (s *ArchiveSink) Mkdir(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mkdir(name)
+}
+
+// mkdir writes the directory entries missing for name, parents first.
+func (s *ArchiveSink) mkdir(name string) error {
+	if name == "." {
+		name = ""
+	}
+	if name != "" {
+		err := s.mkdir(path.Dir(name))
+		if err != nil {
+			return err
+		}
+	}
+	entry := path.Join(s.root, name) + "/"
+	if s.dirs[entry] {
+		return nil
+	}
+	s.dirs[entry] = true
+	return s.archive.WriteDir(entry)
+}
+
+func (s *ArchiveSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.archive.Close()
+	if s.encrypted != nil {
+		if closeErr := s.encrypted.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := s.out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(s.out.Name(), s.dst)
+	}
+	if err != nil {
+		os.Remove(s.out.Name())
+	}
+	return err
+}
+
+func (s *ArchiveSink) Abort() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.out.Close()
+	os.Remove(s.out.Name())
+}
+
+// reserve takes n bytes of the memory buffer, reporting false when they
+// don't fit.
+func (s *ArchiveSink) reserve(n int64) bool {
+	s.bufferMu.Lock()
+	defer s.bufferMu.Unlock()
+	if s.buffered+n > s.limit {
+		return false
+	}
+	s.buffered += n
+	return true
+}
+
+func (s *ArchiveSink) release(n int64) {
+	s.bufferMu.Lock()
+	defer s.bufferMu.Unlock()
+	s.buffered -= n
+}
+
+// archiveEntry holds a file until it is closed and written to the archive.
+type archiveEntry struct {
+	sink   *ArchiveSink
+	name   string
+	buffer bytes.Buffer
+	spill  *os.File
+	size   int64
+	closed bool
+}
+
+func (e *archiveEntry) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, os.ErrClosed
+	}
+	if e.spill == nil && !e.sink.reserve(int64(len(p))) {
+		spill, err := os.CreateTemp(e.sink.scratch, "spill-")
+		if err != nil {
+			return 0, err
+		}
+		e.spill = spill
+		_, err = e.buffer.WriteTo(spill)
+		e.sink.release(e.size)
+		e.buffer = bytes.Buffer{}
+		if err != nil {
+			return 0, err
+		}
+	}
+	var n int
+	var err error
+	if e.spill != nil {
+		n, err = e.spill.Write(p)
+	} else {
+		n, err = e.buffer.Write(p)
+	}
+	e.size += int64(n)
+	return n, err
+}
+
+func (e *archiveEntry) Close() error {
+	if e.closed {
+		return os.ErrClosed
+	}
+	e.closed = true
+	var r io.Reader = &e.buffer
+	if e.spill != nil {
+		defer os.Remove(e.spill.Name())
+		defer e.spill.Close()
+		_, err := e.spill.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		r = e.spill
+	} else {
+		defer e.sink.release(e.size)
+	}
+
+	e.sink.mu.Lock()
+	defer e.sink.mu.Unlock()
+	err := e.sink.mkdir(path.Dir(e.name))
+	if err != nil {
+		return err
+	}
+	return e.sink.archive.WriteFile(path.Join(e.sink.root, e.name), e.size, r)
+}
+
+// bundleWriter is the bundle a collection is writing. The collectors keep
+// addressing files by path below root, the file helpers below route those
+// paths to the sink. Every file is checksummed for the manifest and, when
+// the analyzer uses it, loaded into the bundle as it is written, so the
+// bundle is never read back. Files matching readBack are kept for the
+// collectors that read them.
+type bundleWriter struct {
+	root string
+	sink Sink
+
+	mu     sync.Mutex
+	bundle *analyze.Bundle
+	files  []manifest.File
+	kept   map[string][]byte
+}
+
+// readBack matches the files collectors read after another collector or
+// node wrote them: the etcd responses of the node diagnostics and the
+// probe summary of every node.
+var readBack = regexp.MustCompile(`^(nodes/[^/]+/etcd/(member-list|status|alarm-list)\.json|probes/[^/]+/summary\.txt)$`)
+
+// bundleWriters are the bundles being written, by root.
+var bundleWriters = struct {
+	sync.Mutex
+	roots map[string]*bundleWriter
+}{roots: map[string]*bundleWriter{}}
+
+// openBundle routes the files written below root to sink until the
+// bundle is closed.
+func openBundle(root string, sink Sink) *bundleWriter {
+	b := &bundleWriter{root: filepath.Clean(root), sink: sink, bundle: analyze.NewBundle(), kept: map[string][]byte{}}
+	bundleWriters.Lock()
+	defer bundleWriters.Unlock()
+	bundleWriters.roots[b.root] = b
+	return b
+}
+
+// close stops routing files to the sink, which is left to the caller to
+// close or abort.
+func (b *bundleWriter) close() {
+	bundleWriters.Lock()
+	defer bundleWriters.Unlock()
+	delete(bundleWriters.roots, b.root)
+}
+
+// bundleFor returns the bundle path is in and its name in the bundle, or
+// nil when path is outside of every bundle being written.
+func bundleFor(path string) (*bundleWriter, string) {
+	path = filepath.Clean(path)
+	bundleWriters.Lock()
+	defer bundleWriters.Unlock()
+	for root, b := range bundleWriters.roots {
+		if path == root {
+			return b, ""
+		}
+		if strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return b, filepath.ToSlash(path[len(root)+1:])
+		}
+	}
+	return nil, ""
+}
+
+func (b *bundleWriter) create(name string) (io.WriteCloser, error) {
+	w, err := b.sink.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	f := &bundleFile{writer: b, name: name, w: w, hash: sha256.New()}
+	if b.loads(name) {
+		f.data = &bytes.Buffer{}
+	}
+	return f, nil
+}
+
+// loads reports whether the content of the file name has to be kept, for
+// the analyzer or for readFile.
+func (b *bundleWriter) loads(name string) bool {
+	if readBack.MatchString(name) {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return analyze.Loads(name) && !b.bundle.Full()
+}
+
+func (b *bundleWriter) add(file manifest.File, data *bytes.Buffer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.files = append(b.files, file)
+	if data == nil {
+		return
+	}
+	if readBack.MatchString(file.Path) {
+		b.kept[file.Path] = data.Bytes()
+	}
+	b.bundle.AddFile(file.Path, data.Bytes())
+}
+
+// checksums returns the checksums of the files written so far.
+func (b *bundleWriter) checksums() []manifest.File {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]manifest.File(nil), b.files...)
+}
+
+// readFile returns a file written earlier. Only the files matching
+// readBack are kept.
+func (b *bundleWriter) readFile(name string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.kept[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: filepath.Join(b.root, name), Err: fs.ErrNotExist}
+	}
+	return data, nil
+}
+
+// readDirNames returns the sorted names of the files and directories
+// written directly below the directory name.
+func (b *bundleWriter) readDirNames(name string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	prefix := name + "/"
+	seen := map[string]bool{}
+	var names []string
+	for _, file := range b.files {
+		if !strings.HasPrefix(file.Path, prefix) {
+			continue
+		}
+		child, _, _ := strings.Cut(file.Path[len(prefix):], "/")
+		if !seen[child] {
+			seen[child] = true
+			names = append(names, child)
+		}
+	}
+	if len(names) == 0 {
+		return nil, &fs.PathError{Op: "open", Path: filepath.Join(b.root, name), Err: fs.ErrNotExist}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// bundleFile checksums and loads a file on its way to the sink.
+type bundleFile struct {
+	writer *bundleWriter
+	name   string
+	w      io.WriteCloser
+	hash   hash.Hash
+	size   int64
+	// data is the content kept for the analyzer or readFile, nil once the
+	// file is larger than the analyzer loads.
+	data *bytes.Buffer
+}
+
+func (f *bundleFile) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.hash.Write(p[:n])
+	f.size += int64(n)
+	if f.data != nil {
+		if f.data.Len()+n > analyze.MaxFileSize {
+			f.data = nil
+		} else {
+			f.data.Write(p[:n])
+		}
+	}
+	return n, err
+}
+
+func (f *bundleFile) Close() error {
+	err := f.w.Close()
+	if err != nil {
+		return err
+	}
+	f.writer.add(manifest.File{Path: f.name, Size: f.size, SHA256: hex.EncodeToString(f.hash.Sum(nil))}, f.data)
+	return nil
+}
+
+// createFile creates a file like os.Create, in the sink of the bundle the
+// path is in.
+func createFile(path string) (io.WriteCloser, error) {
+	b, name := bundleFor(path)
+	if b == nil {
+		return os.Create(path)
+	}
+	if name == "" {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: errors.New("is the bundle root")}
+	}
+	return b.create(name)
+}
+
+// writeFile writes a file like os.WriteFile, in the sink of the bundle the
+// path is in.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	b, _ := bundleFor(path)
+	if b == nil {
+		return os.WriteFile(path, data, perm)
+	}
+	f, err := createFile(path)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// mkdirAll creates a directory like os.MkdirAll, in the sink of the bundle
+// the path is in.
+func mkdirAll(path string, perm os.FileMode) error {
+	b, name := bundleFor(path)
+	if b == nil {
+		return os.MkdirAll(path, perm)
+	}
+	return b.sink.Mkdir(name)
+}
+
+// readFile reads a file like os.ReadFile, from the bundle the path is in.
+func readFile(path string) ([]byte, error) {
+	b, name := bundleFor(path)
+	if b == nil {
+		return os.ReadFile(path)
+	}
+	return b.readFile(name)
+}
+
+// readDirNames returns the sorted names of the entries of a directory,
+// from the bundle the path is in.
+func readDirNames(path string) ([]string, error) {
+	b, name := bundleFor(path)
+	if b == nil {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		return names, nil
+	}
+	return b.readDirNames(name)
+}
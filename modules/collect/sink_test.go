@@ -0,0 +1,238 @@
+package collect
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// archivedEntry is an entry read back from an archive.
+type archivedEntry struct {
+	name    string
+	mode    fs.FileMode
+	modTime time.Time
+	owner   int
+	content string
+}
+
+// readArchive returns the entries of a tar.gz, tar.zst or zip archive in
+// the order they were written.
+func readArchive(t *testing.T, path string) []archivedEntry {
+	t.Helper()
+	var entries []archivedEntry
+	if strings.HasSuffix(path, ".zip") {
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			r, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries = append(entries, archivedEntry{name: f.Name, mode: f.Mode(), modTime: f.Modified, content: string(content)})
+		}
+		return entries
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var r io.Reader
+	if strings.HasSuffix(path, ".tar.zst") {
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	} else {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, archivedEntry{name: header.Name, mode: header.FileInfo().Mode(), modTime: header.ModTime, owner: header.Uid + header.Gid, content: string(content)})
+	}
+}
+
+func TestArchiveSinkBuffer(t *testing.T) {
+	small := "small file"
+	large := strings.Repeat("large file ", 1000)
+	tests := []struct {
+		name       string
+		bufferSize int64
+		spills     int
+	}{
+		{name: "files fit in memory", bufferSize: 1 << 20},
+		{name: "files spill to scratch", bufferSize: 0, spills: 2},
+		{name: "large files spill while small ones fit", bufferSize: 4096, spills: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			scratch := t.TempDir()
+			dst := filepath.Join(dir, "bundle.tar.gz")
+			sink, err := NewArchiveSink(dst, ArchiveOptions{Format: FormatTarGz, Compression: Compression{Level: -1, Threads: 1}, BufferSize: test.bufferSize, Scratch: scratch, Root: "bundle"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Files written at the same time are archived in the order
+			// they are closed, each in one piece.
+			a, err := sink.Create("a/large.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := sink.Create("b/small.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < len(large); i += 1000 {
+				io.WriteString(a, large[i:i+1000])
+				if i == 0 {
+					io.WriteString(b, small)
+				}
+			}
+			spilled, err := os.ReadDir(scratch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(spilled) != test.spills {
+				t.Errorf("%d files spilled, want %d", len(spilled), test.spills)
+			}
+			for _, w := range []io.WriteCloser{b, a} {
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := a.Write([]byte("late")); err != os.ErrClosed {
+				t.Errorf("write after close returned %v", err)
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if spilled, _ := os.ReadDir(scratch); len(spilled) != 0 {
+				t.Errorf("spill files left in scratch: %v", spilled)
+			}
+			if sink.buffered != 0 {
+				t.Errorf("%d buffered bytes never released", sink.buffered)
+			}
+			if _, err := os.Stat(dst + ".partial"); !os.IsNotExist(err) {
+				t.Errorf("partial archive left behind - %v", err)
+			}
+			var got []string
+			for _, entry := range readArchive(t, dst) {
+				got = append(got, entry.name)
+				if entry.name == "bundle/a/large.txt" && entry.content != large || entry.name == "bundle/b/small.txt" && entry.content != small {
+					t.Errorf("%s has %d bytes of other content", entry.name, len(entry.content))
+				}
+			}
+			want := "bundle/ bundle/b/ bundle/b/small.txt bundle/a/ bundle/a/large.txt"
+			if strings.Join(got, " ") != want {
+				t.Errorf("entries %v, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestArchiveSinkAbort(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "bundle.tar.gz")
+	sink, err := NewArchiveSink(dst, ArchiveOptions{Format: FormatTarGz, Compression: Compression{Level: -1, Threads: 1}, BufferSize: 1 << 20, Scratch: t.TempDir(), Root: "bundle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := sink.Create("file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(w, bytes.NewBufferString("content"))
+	w.Close()
+	sink.Abort()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("aborted bundle left %v", entries)
+	}
+}
+
+func TestBundleWriterLoads(t *testing.T) {
+	root := t.TempDir()
+	b := openBundle(root, NewDirSink(root))
+	defer b.close()
+	tests := []struct {
+		name     string
+		content  string
+		readBack bool
+		analyzed bool
+	}{
+		{name: "nodes/node-1/etcd/status.json", content: `{"version":"3.5.9"}`, readBack: true},
+		{name: "probes/node-1/summary.txt", content: "dns=ok", readBack: true},
+		{name: "rancher-data/rancher-data.json", content: `{"RancherVersion":"v2.7.5"}`, analyzed: true},
+		{name: "collection-errors.txt", content: "none", analyzed: true},
+		{name: "nodes/node-1/journal/kubelet.txt", content: "kubelet log"},
+		{name: "metrics/pod-metrics.json", content: `{"items":[]}`},
+	}
+	for _, test := range tests {
+		err := writeFile(filepath.Join(root, test.name), []byte(test.content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := readFile(filepath.Join(root, test.name))
+			if test.readBack && (err != nil || string(data) != test.content) {
+				t.Errorf("read back %q - %v, want %q", data, err, test.content)
+			}
+			if !test.readBack && !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("read back %q - %v, want not exist", data, err)
+			}
+			if _, ok := b.bundle.Files[test.name]; ok != test.analyzed {
+				t.Errorf("loaded for the analyzer %t, want %t", ok, test.analyzed)
+			}
+			onDisk, err := os.ReadFile(filepath.Join(root, test.name))
+			if err != nil || string(onDisk) != test.content {
+				t.Errorf("written %q - %v, want %q", onDisk, err, test.content)
+			}
+		})
+	}
+}
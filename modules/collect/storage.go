@@ -0,0 +1,302 @@
+package collect
+
+import (
+	"fmt"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/mattmattox/supportability-collector/modules/kubernetes"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// volumeSnapshotResources are the snapshot.storage.k8s.io/v1 resources
+// collected when the external snapshotter CRDs are installed.
+var volumeSnapshotResources = []string{"volumesnapshots", "volumesnapshotcontents", "volumesnapshotclasses"}
+
+func StorageDir(dir string) string {
+	storageDir := dir + "/storage"
+	err := mkdirAll(storageDir, 0755)
+	if err != nil {
+		log.Fatalln("Storage directory creation failed")
+	}
+	return storageDir
+}
+
+func CollectStorage(tempDirRoot string) {
+	log.Infoln("Collecting storage information")
+
+	storageDir := StorageDir(tempDirRoot)
+
+	config, err := kubernetes.GetConfig()
+	if err != nil {
+		log.Fatalln("Failed to connect to upstream cluster")
+	}
+	client, err := kubernetes.GetClient()
+	if err != nil {
+		log.Fatalln("Failed to connect to upstream cluster")
+	}
+
+	pvs := StoragePersistentVolumes(client, storageDir)
+	pvcs := StoragePersistentVolumeClaims(client, storageDir)
+	StorageClasses(client, storageDir)
+	StorageVolumeAttachments(client, storageDir)
+	StorageCSIDrivers(client, storageDir)
+	StorageCSINodes(client, storageDir)
+	StorageVolumeSnapshots(config, storageDir)
+
+	err = StorageWriteSummary(storageDir+"/storage-summary.txt", pvs, pvcs)
+	if err != nil {
+		log.Warningf("Storage summary write failed - Error %s", err)
+	}
+
+	log.Infoln("Storage collection complete")
+}
+
+func StoragePersistentVolumes(client *k8s.Clientset, dir string) []*v1.PersistentVolume {
+	pvs, err := kubernetes.GetPersistentVolumes(client)
+	if err != nil {
+		log.Warningf("List of persistent volumes failed - Error %s", err)
+		return nil
+	}
+	pvDir := dir + "/persistentvolumes"
+	err = mkdirAll(pvDir, 0755)
+	if err != nil {
+		log.Warningf("Folder creation for persistent volumes failed - Error %s", err)
+	}
+	var pvList []*v1.PersistentVolume
+	for _, pv := range pvs {
+		log.Infof("Grabbing YAML for persistent volume: %s", pv)
+		pvData, err := kubernetes.GetPersistentVolumeYaml(client, pv)
+		if err != nil {
+			log.Warningf("Persistent volume YAML collection failed - Error %s", err)
+			continue
+		}
+		pvList = append(pvList, pvData)
+		err = WriteYaml(pvDir+"/"+pv+".yaml", pvData)
+		if err != nil {
+			log.Warningf("Persistent volume YAML file write failed - Error %s", err)
+		}
+	}
+	return pvList
+}
+
+func StoragePersistentVolumeClaims(client *k8s.Clientset, dir string) []*v1.PersistentVolumeClaim {
+	namespaces, err := kubernetes.GetNamespaces(client)
+	if err != nil {
+		log.Warningf("List of namespaces failed - Error %s", err)
+		return nil
+	}
+	var pvcList []*v1.PersistentVolumeClaim
+	for _, namespace := range namespaces {
+		pvcs, err := kubernetes.GetPersistentVolumeClaims(client, namespace)
+		if err != nil {
+			log.Warningf("List of persistent volume claims in %s failed - Error %s", namespace, err)
+			continue
+		}
+		if len(pvcs) == 0 {
+			continue
+		}
+		pvcDir := dir + "/persistentvolumeclaims/" + namespace
+		err = mkdirAll(pvcDir, 0755)
+		if err != nil {
+			log.Warningf("Folder creation for persistent volume claims in %s failed - Error %s", namespace, err)
+		}
+		for _, pvc := range pvcs {
+			log.Infof("Grabbing YAML for persistent volume claim: %s/%s", namespace, pvc)
+			pvcData, err := kubernetes.GetPersistentVolumeClaimYaml(client, namespace, pvc)
+			if err != nil {
+				log.Warningf("Persistent volume claim YAML collection failed - Error %s", err)
+				continue
+			}
+			pvcList = append(pvcList, pvcData)
+			err = WriteYaml(pvcDir+"/"+pvc+".yaml", pvcData)
+			if err != nil {
+				log.Warningf("Persistent volume claim YAML file write failed - Error %s", err)
+			}
+		}
+	}
+	return pvcList
+}
+
+func StorageClasses(client k8s.Interface, dir string) {
+	storageClasses, err := kubernetes.GetStorageClasses(client)
+	if err != nil {
+		log.Warningf("List of storage classes failed - Error %s", err)
+		return
+	}
+	storageClassDir := dir + "/storageclasses"
+	err = mkdirAll(storageClassDir, 0755)
+	if err != nil {
+		log.Warningf("Folder creation for storage classes failed - Error %s", err)
+	}
+	for _, storageClass := range storageClasses {
+		log.Infof("Grabbing YAML for storage class: %s", storageClass)
+		storageClassData, err := kubernetes.GetStorageClassYaml(client, storageClass)
+		if err != nil {
+			log.Warningf("Storage class YAML collection failed - Error %s", err)
+			continue
+		}
+		err = WriteYaml(storageClassDir+"/"+storageClass+".yaml", storageClassData)
+		if err != nil {
+			log.Warningf("Storage class YAML file write failed - Error %s", err)
+		}
+	}
+}
+
+func StorageVolumeAttachments(client k8s.Interface, dir string) {
+	volumeAttachments, err := kubernetes.GetVolumeAttachments(client)
+	if err != nil {
+		log.Warningf("List of volume attachments failed - Error %s", err)
+		return
+	}
+	volumeAttachmentDir := dir + "/volumeattachments"
+	err = mkdirAll(volumeAttachmentDir, 0755)
+	if err != nil {
+		log.Warningf("Folder creation for volume attachments failed - Error %s", err)
+	}
+	for _, volumeAttachment := range volumeAttachments {
+		log.Infof("Grabbing YAML for volume attachment: %s", volumeAttachment)
+		volumeAttachmentData, err := kubernetes.GetVolumeAttachmentYaml(client, volumeAttachment)
+		if err != nil {
+			log.Warningf("Volume attachment YAML collection failed - Error %s", err)
+			continue
+		}
+		err = WriteYaml(volumeAttachmentDir+"/"+volumeAttachment+".yaml", volumeAttachmentData)
+		if err != nil {
+			log.Warningf("Volume attachment YAML file write failed - Error %s", err)
+		}
+	}
+}
+
+func StorageCSIDrivers(client k8s.Interface, dir string) {
+	csiDrivers, err := kubernetes.GetCSIDrivers(client)
+	if err != nil {
+		log.Warningf("List of CSI drivers failed - Error %s", err)
+		return
+	}
+	csiDriverDir := dir + "/csidrivers"
+	err = mkdirAll(csiDriverDir, 0755)
+	if err != nil {
+		log.Warningf("Folder creation for CSI drivers failed - Error %s", err)
+	}
+	for _, csiDriver := range csiDrivers {
+		log.Infof("Grabbing YAML for CSI driver: %s", csiDriver)
+		csiDriverData, err := kubernetes.GetCSIDriverYaml(client, csiDriver)
+		if err != nil {
+			log.Warningf("CSI driver YAML collection failed - Error %s", err)
+			continue
+		}
+		err = WriteYaml(csiDriverDir+"/"+csiDriver+".yaml", csiDriverData)
+		if err != nil {
+			log.Warningf("CSI driver YAML file write failed - Error %s", err)
+		}
+	}
+}
+
+func StorageCSINodes(client k8s.Interface, dir string) {
+	csiNodes, err := kubernetes.GetCSINodes(client)
+	if err != nil {
+		log.Warningf("List of CSI nodes failed - Error %s", err)
+		return
+	}
+	csiNodeDir := dir + "/csinodes"
+	err = mkdirAll(csiNodeDir, 0755)
+	if err != nil {
+		log.Warningf("Folder creation for CSI nodes failed - Error %s", err)
+	}
+	for _, csiNode := range csiNodes {
+		log.Infof("Grabbing YAML for CSI node: %s", csiNode)
+		csiNodeData, err := kubernetes.GetCSINodeYaml(client, csiNode)
+		if err != nil {
+			log.Warningf("CSI node YAML collection failed - Error %s", err)
+			continue
+		}
+		err = WriteYaml(csiNodeDir+"/"+csiNode+".yaml", csiNodeData)
+		if err != nil {
+			log.Warningf("CSI node YAML file write failed - Error %s", err)
+		}
+	}
+}
+
+func StorageVolumeSnapshots(config *rest.Config, dir string) {
+	for _, resource := range volumeSnapshotResources {
+		log.Infof("Grabbing YAML for %s", resource)
+		resourceYaml, err := kubernetes.GetCustomResourceListYaml(config, "snapshot.storage.k8s.io", "v1", resource)
+		if apierrors.IsNotFound(err) {
+			log.Infof("%s are not available, skipping", resource)
+			continue
+		}
+		if err != nil {
+			log.Warningf("%s YAML collection failed - Error %s", resource, err)
+			continue
+		}
+		err = writeFile(dir+"/"+resource+".yaml", []byte(resourceYaml), 0644)
+		if err != nil {
+			log.Warningf("%s YAML file write failed - Error %s", resource, err)
+		}
+	}
+}
+
+// StorageWriteSummary counts volumes and claims by phase and lists every
+// claim that isn't bound, since those are usually what the case is about.
+func StorageWriteSummary(path string, pvs []*v1.PersistentVolume, pvcs []*v1.PersistentVolumeClaim) (err error) {
+	f, err := createFile(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	pvPhases := map[string]int{}
+	for _, pv := range pvs {
+		pvPhases[string(pv.Status.Phase)]++
+	}
+	pvcPhases := map[string]int{}
+	for _, pvc := range pvcs {
+		pvcPhases[string(pvc.Status.Phase)]++
+	}
+
+	fmt.Fprintf(f, "Persistent volumes: %d\n", len(pvs))
+	for _, phase := range sortedKeys(pvPhases) {
+		fmt.Fprintf(f, "  %s: %d\n", phase, pvPhases[phase])
+	}
+	fmt.Fprintf(f, "Persistent volume claims: %d\n", len(pvcs))
+	for _, phase := range sortedKeys(pvcPhases) {
+		fmt.Fprintf(f, "  %s: %d\n", phase, pvcPhases[phase])
+	}
+
+	fmt.Fprintln(f)
+	w := tabwriter.NewWriter(f, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tCLAIM\tPHASE\tSTORAGECLASS\tVOLUME")
+	for _, pvc := range pvcs {
+		if pvc.Status.Phase == v1.ClaimBound {
+			continue
+		}
+		storageClass := ""
+		if pvc.Spec.StorageClassName != nil {
+			storageClass = *pvc.Spec.StorageClassName
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", pvc.Namespace, pvc.Name, pvc.Status.Phase, storageClass, pvc.Spec.VolumeName)
+	}
+	for _, pv := range pvs {
+		if pv.Status.Phase == v1.VolumeAvailable || pv.Status.Phase == v1.VolumeReleased || pv.Status.Phase == v1.VolumeFailed {
+			fmt.Fprintf(w, "-\t%s (volume)\t%s\t%s\t-\n", pv.Name, pv.Status.Phase, pv.Spec.StorageClassName)
+		}
+	}
+	return w.Flush()
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,163 @@
+package collect
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mattmattox/supportability-collector/modules/metrics"
+)
+
+// Upload sends a bundle to a destination URL and returns where it can be
+// found. file:// destinations copy the bundle into a directory and
+// http(s):// destinations PUT it to the URL, which works with presigned
+// object storage URLs. An empty destination leaves the bundle in place.
+func Upload(tarFile string, destination string) (location string, err error) {
+	if destination == "" {
+		return tarFile, nil
+	}
+	start := time.Now()
+	defer func() {
+		metrics.UploadDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			metrics.UploadFailures.Inc()
+		}
+	}()
+	target, err := url.Parse(destination)
+	if err != nil {
+		return "", err
+	}
+	switch target.Scheme {
+	case "file":
+		return uploadFile(tarFile, target.Path)
+	case "http", "https":
+		return uploadHTTP(tarFile, target)
+	}
+	return "", fmt.Errorf("unsupported upload destination scheme %q", target.Scheme)
+}
+
+// CheckDestination returns an error unless destination is empty or an
+// http(s) URL under one of the allowed URL prefixes. It guards the
+// destinations chosen by API callers and SupportBundle authors, who may
+// neither write to the file system of the collector nor send bundles to
+// hosts the operator didn't allow. Operator schedules and triggers are
+// not checked.
+func CheckDestination(destination string, allowed []string) error {
+	if destination == "" {
+		return nil
+	}
+	target, err := url.Parse(destination)
+	if err != nil {
+		return fmt.Errorf("upload destination is not a URL")
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return fmt.Errorf("upload destination scheme %q is not allowed, only http and https are", target.Scheme)
+	}
+	for _, prefix := range allowed {
+		if underPrefix(target, prefix) {
+			return nil
+		}
+	}
+	// The query is left out as presigned URLs carry credentials in it
+	return fmt.Errorf("upload destination %s://%s%s is not allowed by UPLOAD_ALLOWED_DESTINATIONS", target.Scheme, target.Host, target.Path)
+}
+
+// underPrefix reports whether target is prefix or below it. A prefix
+// without a trailing slash also allows the paths below it.
+func underPrefix(target *url.URL, prefix string) bool {
+	allowed, err := url.Parse(prefix)
+	if err != nil || target.User != nil {
+		return false
+	}
+	if target.Scheme != allowed.Scheme || !strings.EqualFold(target.Host, allowed.Host) {
+		return false
+	}
+	for _, segment := range strings.Split(target.Path, "/") {
+		if segment == "." || segment == ".." {
+			return false
+		}
+	}
+	if allowed.Path == "" || strings.HasSuffix(allowed.Path, "/") {
+		return strings.HasPrefix(target.Path, allowed.Path)
+	}
+	return target.Path == allowed.Path || strings.HasPrefix(target.Path, allowed.Path+"/")
+}
+
+func uploadFile(tarFile string, dir string) (string, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+	in, err := os.Open(tarFile)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	path := filepath.Join(dir, filepath.Base(tarFile))
+	out, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return "file://" + path, nil
+}
+
+func uploadHTTP(tarFile string, target *url.URL) (string, error) {
+	f, err := os.Open(tarFile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	request, err := http.NewRequest(http.MethodPut, target.String(), f)
+	if err != nil {
+		return "", err
+	}
+	request.ContentLength = info.Size()
+	request.Header.Set("Content-Type", ContentType(tarFile))
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return "", fmt.Errorf("upload to %s returned %s", target.Host, response.Status)
+	}
+	// Presigned URLs carry credentials in the query, keep them out of the
+	// reported location.
+	location := *target
+	location.RawQuery = ""
+	return location.String(), nil
+}
+
+// Checksum returns the SHA-256 of a bundle as sha256:<hex>.
+func Checksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
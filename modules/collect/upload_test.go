@@ -0,0 +1,99 @@
+package collect
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckDestination(t *testing.T) {
+	allowed := []string{"https://bucket.s3.amazonaws.com/support/", "https://uploads.example.com/bundles"}
+	tests := []struct {
+		name        string
+		destination string
+		allowed     []string
+		err         bool
+	}{
+		{
+			name: "no upload",
+		},
+		{
+			name:        "presigned URL under a prefix",
+			destination: "https://bucket.s3.amazonaws.com/support/bundle.tar.gz?X-Amz-Signature=secret",
+			allowed:     allowed,
+		},
+		{
+			name:        "prefix without a trailing slash",
+			destination: "https://uploads.example.com/bundles/team/bundle.zip",
+			allowed:     allowed,
+		},
+		{
+			name:        "host names are case insensitive",
+			destination: "https://Bucket.S3.amazonaws.com/support/bundle.tar.gz",
+			allowed:     allowed,
+		},
+		{
+			name:        "file URLs",
+			destination: "file:///etc/cron.d",
+			allowed:     []string{"file:///"},
+			err:         true,
+		},
+		{
+			name:        "nothing allowed",
+			destination: "https://bucket.s3.amazonaws.com/support/bundle.tar.gz",
+			err:         true,
+		},
+		{
+			name:        "other hosts",
+			destination: "https://attacker.example.com/support/bundle.tar.gz",
+			allowed:     allowed,
+			err:         true,
+		},
+		{
+			name:        "user info hiding another host",
+			destination: "https://bucket.s3.amazonaws.com@attacker.example.com/support/",
+			allowed:     allowed,
+			err:         true,
+		},
+		{
+			name:        "other schemes",
+			destination: "http://bucket.s3.amazonaws.com/support/bundle.tar.gz",
+			allowed:     allowed,
+			err:         true,
+		},
+		{
+			name:        "other ports",
+			destination: "https://bucket.s3.amazonaws.com:8443/support/bundle.tar.gz",
+			allowed:     allowed,
+			err:         true,
+		},
+		{
+			name:        "paths extending the prefix",
+			destination: "https://uploads.example.com/bundles-other/bundle.zip",
+			allowed:     allowed,
+			err:         true,
+		},
+		{
+			name:        "paths escaping the prefix",
+			destination: "https://bucket.s3.amazonaws.com/support/../private/bundle.tar.gz",
+			allowed:     allowed,
+			err:         true,
+		},
+		{
+			name:        "encoded paths escaping the prefix",
+			destination: "https://bucket.s3.amazonaws.com/support/%2e%2e/private/bundle.tar.gz",
+			allowed:     allowed,
+			err:         true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckDestination(test.destination, test.allowed)
+			if (err != nil) != test.err {
+				t.Fatalf("error %v, want error %v", err, test.err)
+			}
+			if err != nil && strings.Contains(err.Error(), "secret") {
+				t.Errorf("error leaks the query: %s", err)
+			}
+		})
+	}
+}
@@ -1,16 +1,13 @@
 package collect
 
 import (
-	"fmt"
-	"os"
-
 	"github.com/mattmattox/supportability-collector/modules/kubernetes"
 )
 
 func UpstreamDataDir(dir string) string {
 	log.Infoln("Collecting upstream cluster information")
 	upstreamDir := dir + "/upstream"
-	err := os.MkdirAll(upstreamDir, 0755)
+	err := mkdirAll(upstreamDir, 0755)
 	if err != nil {
 		log.Fatalln("Upstream cluster directory creation failed")
 	}
@@ -25,7 +22,7 @@ func UpstreamClusterNodes(dir string) {
 		log.Fatalln("Failed to connect to upstream cluster")
 	}
 	dir = dir + "/nodes"
-	err = os.MkdirAll(dir, 0755)
+	err = mkdirAll(dir, 0755)
 	if err != nil {
 		log.Fatalln("Upstream cluster nodes directory creation failed")
 	}
@@ -40,12 +37,10 @@ func UpstreamClusterNodes(dir string) {
 		if err != nil {
 			log.Fatalln("Failed to get upstream cluster node data")
 		}
-		f, err := os.Create(dir + "/" + node + ".yaml")
+		err = WriteYaml(dir+"/"+node+".yaml", nodeData)
 		if err != nil {
 			log.Fatalln("Upstream cluster node file creation failed")
 		}
-		fmt.Fprint(f, nodeData)
-		f.Close()
 		log.Infoln("Upstream cluster node file created successfully")
 	}
 }
@@ -0,0 +1,285 @@
+package collect
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mattmattox/supportability-collector/modules/kubernetes"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+)
+
+// WebhookSummary is one line of webhooks-summary.txt, describing a single
+// admission webhook or APIService and whether anything is serving it.
+type WebhookSummary struct {
+	Kind           string
+	Name           string
+	Webhook        string
+	Target         string
+	ReadyBackends  int
+	NotReady       int
+	FailurePolicy  string
+	TimeoutSeconds string
+	Status         string
+}
+
+// apiService holds the fields of an apiregistration.k8s.io/v1 APIService
+// needed for the summary.
+type apiService struct {
+	Spec struct {
+		Service *struct {
+			Namespace string `json:"namespace"`
+			Name      string `json:"name"`
+			Port      *int32 `json:"port"`
+		} `json:"service"`
+	} `json:"spec"`
+	Status struct {
+		Conditions []struct {
+			Type    string `json:"type"`
+			Status  string `json:"status"`
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+func WebhooksDir(dir string) string {
+	webhooksDir := dir + "/webhooks"
+	err := mkdirAll(webhooksDir, 0755)
+	if err != nil {
+		log.Fatalln("Webhooks directory creation failed")
+	}
+	return webhooksDir
+}
+
+func CollectWebhooks(tempDirRoot string) {
+	log.Infoln("Collecting admission webhooks and API services")
+
+	webhooksDir := WebhooksDir(tempDirRoot)
+
+	config, err := kubernetes.GetConfig()
+	if err != nil {
+		log.Fatalln("Failed to connect to upstream cluster")
+	}
+	client, err := kubernetes.GetClient()
+	if err != nil {
+		log.Fatalln("Failed to connect to upstream cluster")
+	}
+
+	var summaries []WebhookSummary
+	summaries = append(summaries, WebhooksValidating(client, webhooksDir)...)
+	summaries = append(summaries, WebhooksMutating(client, webhooksDir)...)
+	summaries = append(summaries, WebhooksAPIServices(config, client, webhooksDir)...)
+
+	err = WebhooksWriteSummary(webhooksDir+"/webhooks-summary.txt", summaries)
+	if err != nil {
+		log.Warningf("Webhooks summary write failed - Error %s", err)
+	}
+
+	log.Infoln("Admission webhook and API service collection complete")
+}
+
+func WebhooksValidating(client *k8s.Clientset, dir string) []WebhookSummary {
+	webhooks, err := kubernetes.GetValidatingWebhookConfigurations(client)
+	if err != nil {
+		log.Warningf("List of validating webhook configurations failed - Error %s", err)
+		return nil
+	}
+	validatingDir := dir + "/validating"
+	err = mkdirAll(validatingDir, 0755)
+	if err != nil {
+		log.Warningf("Folder creation for validating webhook configurations failed - Error %s", err)
+	}
+	var summaries []WebhookSummary
+	for _, name := range webhooks {
+		log.Infof("Grabbing YAML for validating webhook configuration: %s", name)
+		webhookData, err := kubernetes.GetValidatingWebhookConfigurationYaml(client, name)
+		if err != nil {
+			log.Warningf("Validating webhook configuration YAML collection failed - Error %s", err)
+			continue
+		}
+		err = WriteYaml(validatingDir+"/"+name+".yaml", webhookData)
+		if err != nil {
+			log.Warningf("Validating webhook configuration YAML file write failed - Error %s", err)
+		}
+		for _, webhook := range webhookData.Webhooks {
+			summary := webhookSummary(client, webhook.ClientConfig, webhook.FailurePolicy, webhook.TimeoutSeconds)
+			summary.Kind = "ValidatingWebhookConfiguration"
+			summary.Name = name
+			summary.Webhook = webhook.Name
+			summaries = append(summaries, summary)
+		}
+	}
+	return summaries
+}
+
+func WebhooksMutating(client *k8s.Clientset, dir string) []WebhookSummary {
+	webhooks, err := kubernetes.GetMutatingWebhookConfigurations(client)
+	if err != nil {
+		log.Warningf("List of mutating webhook configurations failed - Error %s", err)
+		return nil
+	}
+	mutatingDir := dir + "/mutating"
+	err = mkdirAll(mutatingDir, 0755)
+	if err != nil {
+		log.Warningf("Folder creation for mutating webhook configurations failed - Error %s", err)
+	}
+	var summaries []WebhookSummary
+	for _, name := range webhooks {
+		log.Infof("Grabbing YAML for mutating webhook configuration: %s", name)
+		webhookData, err := kubernetes.GetMutatingWebhookConfigurationYaml(client, name)
+		if err != nil {
+			log.Warningf("Mutating webhook configuration YAML collection failed - Error %s", err)
+			continue
+		}
+		err = WriteYaml(mutatingDir+"/"+name+".yaml", webhookData)
+		if err != nil {
+			log.Warningf("Mutating webhook configuration YAML file write failed - Error %s", err)
+		}
+		for _, webhook := range webhookData.Webhooks {
+			summary := webhookSummary(client, webhook.ClientConfig, webhook.FailurePolicy, webhook.TimeoutSeconds)
+			summary.Kind = "MutatingWebhookConfiguration"
+			summary.Name = name
+			summary.Webhook = webhook.Name
+			summaries = append(summaries, summary)
+		}
+	}
+	return summaries
+}
+
+func WebhooksAPIServices(config *rest.Config, client *k8s.Clientset, dir string) []WebhookSummary {
+	apiServices, err := kubernetes.GetAPIServices(config)
+	if err != nil {
+		log.Warningf("List of API services failed - Error %s", err)
+		return nil
+	}
+	apiServiceDir := dir + "/apiservices"
+	err = mkdirAll(apiServiceDir, 0755)
+	if err != nil {
+		log.Warningf("Folder creation for API services failed - Error %s", err)
+	}
+	var summaries []WebhookSummary
+	for _, name := range apiServices {
+		log.Infof("Grabbing YAML for API service: %s", name)
+		apiServiceYaml, err := kubernetes.GetAPIServiceYaml(config, name)
+		if err != nil {
+			log.Warningf("API service YAML collection failed - Error %s", err)
+			continue
+		}
+		err = writeFile(apiServiceDir+"/"+name+".yaml", []byte(apiServiceYaml), 0644)
+		if err != nil {
+			log.Warningf("API service YAML file write failed - Error %s", err)
+		}
+
+		var apiServiceData apiService
+		err = yaml.Unmarshal([]byte(apiServiceYaml), &apiServiceData)
+		if err != nil {
+			log.Warningf("API service YAML parsing failed - Error %s", err)
+			continue
+		}
+		summary := WebhookSummary{
+			Kind:           "APIService",
+			Name:           name,
+			Webhook:        "-",
+			Target:         "Local",
+			FailurePolicy:  "-",
+			TimeoutSeconds: "-",
+			Status:         "OK",
+		}
+		if service := apiServiceData.Spec.Service; service != nil {
+			summary.Target = serviceTarget(service.Namespace, service.Name, service.Port)
+			summary.ReadyBackends, summary.NotReady, summary.Status = serviceBackends(client, service.Namespace, service.Name)
+		}
+		for _, condition := range apiServiceData.Status.Conditions {
+			if condition.Type == "Available" && condition.Status != "True" {
+				summary.Status = "UNAVAILABLE (" + condition.Reason + ")"
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// webhookSummary resolves the Service behind a webhook client config and
+// fills in the backend, failure policy and timeout columns.
+func webhookSummary(client *k8s.Clientset, clientConfig admissionregistrationv1.WebhookClientConfig, failurePolicy *admissionregistrationv1.FailurePolicyType, timeoutSeconds *int32) WebhookSummary {
+	summary := WebhookSummary{
+		FailurePolicy:  "Fail",
+		TimeoutSeconds: "10",
+	}
+	if failurePolicy != nil {
+		summary.FailurePolicy = string(*failurePolicy)
+	}
+	if timeoutSeconds != nil {
+		summary.TimeoutSeconds = fmt.Sprint(*timeoutSeconds)
+	}
+	if clientConfig.URL != nil {
+		summary.Target = *clientConfig.URL
+		summary.Status = "EXTERNAL URL"
+		return summary
+	}
+	if service := clientConfig.Service; service != nil {
+		summary.Target = serviceTarget(service.Namespace, service.Name, service.Port)
+		summary.ReadyBackends, summary.NotReady, summary.Status = serviceBackends(client, service.Namespace, service.Name)
+	}
+	return summary
+}
+
+func serviceTarget(namespace string, name string, port *int32) string {
+	target := namespace + "/" + name
+	if port != nil {
+		target = fmt.Sprintf("%s:%d", target, *port)
+	}
+	return target
+}
+
+// serviceBackends counts the ready and not-ready addresses behind a Service
+// and returns a short status for the summary.
+func serviceBackends(client *k8s.Clientset, namespace string, name string) (int, int, string) {
+	_, err := kubernetes.GetServiceYaml(client, namespace, name)
+	if apierrors.IsNotFound(err) {
+		return 0, 0, "SERVICE NOT FOUND"
+	}
+	if err != nil {
+		return 0, 0, "SERVICE LOOKUP FAILED"
+	}
+	endpoints, err := kubernetes.GetEndpointYaml(client, namespace, name)
+	if apierrors.IsNotFound(err) {
+		return 0, 0, "NO ENDPOINTS"
+	}
+	if err != nil {
+		return 0, 0, "ENDPOINTS LOOKUP FAILED"
+	}
+	ready, notReady := 0, 0
+	for _, subset := range endpoints.Subsets {
+		ready += len(subset.Addresses)
+		notReady += len(subset.NotReadyAddresses)
+	}
+	if ready == 0 {
+		return ready, notReady, "NO READY BACKENDS"
+	}
+	return ready, notReady, "OK"
+}
+
+func WebhooksWriteSummary(path string, summaries []WebhookSummary) (err error) {
+	f, err := createFile(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	w := tabwriter.NewWriter(f, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join([]string{"KIND", "NAME", "WEBHOOK", "TARGET", "READY", "NOT READY", "FAILURE POLICY", "TIMEOUT", "STATUS"}, "\t"))
+	for _, s := range summaries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\n", s.Kind, s.Name, s.Webhook, s.Target, s.ReadyBackends, s.NotReady, s.FailurePolicy, s.TimeoutSeconds, s.Status)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,288 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/mattmattox/supportability-collector/modules/apis/v1alpha1"
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	"github.com/mattmattox/supportability-collector/modules/jobs"
+	"github.com/mattmattox/supportability-collector/modules/logging"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+var log = logging.SetupLogging()
+
+// pollInterval is how often the status of a running collection is
+// copied into its SupportBundle.
+const pollInterval = 5 * time.Second
+
+// SupportBundleReconciler starts a collection for every new SupportBundle
+// and mirrors the job into its status until it finishes, then deletes the
+// SupportBundle once its TTL has passed.
+type SupportBundleReconciler struct {
+	client.Client
+	Jobs       *jobs.Manager
+	DefaultTTL time.Duration
+
+	// started remembers the job of each SupportBundle so a failed status
+	// update doesn't start a second collection.
+	started map[types.UID]string
+}
+
+func (r *SupportBundleReconciler) Reconcile(ctx context.Context, request ctrl.Request) (ctrl.Result, error) {
+	var bundle v1alpha1.SupportBundle
+	err := r.Get(ctx, request.NamespacedName, &bundle)
+	if err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !bundle.DeletionTimestamp.IsZero() {
+		delete(r.started, bundle.UID)
+		return ctrl.Result{}, nil
+	}
+	if bundle.Status.Finished() {
+		return r.expire(ctx, &bundle)
+	}
+
+	status := bundle.Status.DeepCopy()
+	if status.JobID == "" {
+		status.JobID = r.started[bundle.UID]
+	}
+	if status.JobID == "" {
+		r.start(ctx, &bundle, status)
+	} else {
+		job, err := r.Jobs.Get(status.JobID)
+		if err != nil {
+			fail(status, "collection job was lost, the collector restarted while it ran")
+		} else {
+			mirror(status, job)
+		}
+	}
+
+	bundle.Status = *status
+	err = r.Status().Update(ctx, &bundle)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if status.Finished() {
+		delete(r.started, bundle.UID)
+		log.Infof("SupportBundle %s/%s %s", bundle.Namespace, bundle.Name, status.Phase)
+		return r.expire(ctx, &bundle)
+	}
+	return ctrl.Result{RequeueAfter: pollInterval}, nil
+}
+
+func (r *SupportBundleReconciler) start(ctx context.Context, bundle *v1alpha1.SupportBundle, status *v1alpha1.SupportBundleStatus) {
+	now := metav1.Now()
+	status.StartTime = &now
+	upload, err := r.uploadURL(ctx, bundle)
+	if err == nil {
+		err = r.Jobs.CheckUpload(upload)
+	}
+	if err != nil {
+		fail(status, err.Error())
+		return
+	}
+	job, err := r.Jobs.Start(jobs.Request{
+		Profile:  bundle.Spec.Profile,
+		Clusters: bundle.Spec.Clusters,
+		Upload:   upload,
+	})
+	if err != nil {
+		fail(status, err.Error())
+		return
+	}
+	log.Infof("SupportBundle %s/%s started collection %s", bundle.Namespace, bundle.Name, job.ID)
+	r.started[bundle.UID] = job.ID
+	mirror(status, job)
+}
+
+// uploadURL returns the upload destination of a SupportBundle, reading it
+// from its Secret when it has one. The controller reads Secrets with its
+// own privileges, so only the ones labeled with UploadSecretLabel can be
+// referenced.
+func (r *SupportBundleReconciler) uploadURL(ctx context.Context, bundle *v1alpha1.SupportBundle) (string, error) {
+	upload := bundle.Spec.Upload
+	if upload == nil {
+		return "", nil
+	}
+	if upload.SecretRef == "" {
+		return upload.URL, nil
+	}
+	var secret v1.Secret
+	err := r.Get(ctx, types.NamespacedName{Namespace: bundle.Namespace, Name: upload.SecretRef}, &secret)
+	if err != nil {
+		return "", fmt.Errorf("upload secret %s: %w", upload.SecretRef, err)
+	}
+	if secret.Labels[v1alpha1.UploadSecretLabel] != "true" {
+		return "", fmt.Errorf("upload secret %s is not labeled %s=true", upload.SecretRef, v1alpha1.UploadSecretLabel)
+	}
+	url, ok := secret.Data["url"]
+	if !ok {
+		return "", fmt.Errorf("upload secret %s has no url key", upload.SecretRef)
+	}
+	return string(url), nil
+}
+
+// expire deletes a finished SupportBundle once its TTL has passed and
+// otherwise requeues it for then.
+func (r *SupportBundleReconciler) expire(ctx context.Context, bundle *v1alpha1.SupportBundle) (ctrl.Result, error) {
+	// A zero default keeps SupportBundles forever, while a zero TTL in the
+	// spec deletes it as soon as it finishes, as for Jobs.
+	ttl, expires := r.DefaultTTL, r.DefaultTTL > 0
+	if bundle.Spec.TTLSecondsAfterFinished != nil {
+		ttl, expires = time.Duration(*bundle.Spec.TTLSecondsAfterFinished)*time.Second, true
+	}
+	if !expires || bundle.Status.CompletionTime == nil {
+		return ctrl.Result{}, nil
+	}
+	remaining := time.Until(bundle.Status.CompletionTime.Add(ttl))
+	if remaining > 0 {
+		return ctrl.Result{RequeueAfter: remaining}, nil
+	}
+	log.Infof("Deleting expired SupportBundle %s/%s", bundle.Namespace, bundle.Name)
+	return ctrl.Result{}, client.IgnoreNotFound(r.Delete(ctx, bundle))
+}
+
+func mirror(status *v1alpha1.SupportBundleStatus, job jobs.Job) {
+	switch job.Status {
+	case jobs.StatusQueued:
+		status.Phase = v1alpha1.PhasePending
+	case jobs.StatusRunning:
+		status.Phase = v1alpha1.PhaseRunning
+	case jobs.StatusSucceeded:
+		status.Phase = v1alpha1.PhaseSucceeded
+	case jobs.StatusFailed:
+		status.Phase = v1alpha1.PhaseFailed
+	}
+	status.JobID = job.ID
+	status.Step = job.Step
+	status.Progress = ""
+	if job.Total > 0 {
+		status.Progress = fmt.Sprintf("%d/%d", job.Done, job.Total)
+	}
+	status.Error = job.Error
+	status.Bundle = job.Bundle
+	status.Location = job.Location
+	status.Checksum = job.Checksum
+	if job.FinishedAt != nil {
+		finished := metav1.NewTime(*job.FinishedAt)
+		status.CompletionTime = &finished
+	}
+}
+
+func fail(status *v1alpha1.SupportBundleStatus, message string) {
+	now := metav1.Now()
+	status.Phase = v1alpha1.PhaseFailed
+	status.Step = ""
+	status.Error = message
+	status.CompletionTime = &now
+}
+
+// Start installs the SupportBundle CRD when it is missing and runs the
+// controller in the background until ctx is done.
+func Start(ctx context.Context, config *rest.Config, manager *jobs.Manager, settings cli.Cli) error {
+	ctrl.SetLogger(funcr.New(func(prefix, args string) {
+		log.Debugln(prefix, args)
+	}, funcr.Options{}))
+
+	scheme := runtime.NewScheme()
+	err := clientgoscheme.AddToScheme(scheme)
+	if err != nil {
+		return err
+	}
+	err = v1alpha1.AddToScheme(scheme)
+	if err != nil {
+		return err
+	}
+
+	err = InstallCRD(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	mgr, err := ctrl.NewManager(config, ctrl.Options{
+		Scheme:                 scheme,
+		Namespace:              settings.ControllerNamespace,
+		MetricsBindAddress:     "0",
+		HealthProbeBindAddress: "0",
+	})
+	if err != nil {
+		return err
+	}
+	err = ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha1.SupportBundle{}).
+		Complete(&SupportBundleReconciler{
+			Client:     mgr.GetClient(),
+			Jobs:       manager,
+			DefaultTTL: settings.SupportBundleTTL,
+			started:    map[types.UID]string{},
+		})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		log.Infoln("Starting SupportBundle controller")
+		err := mgr.Start(ctx)
+		if err != nil {
+			log.Fatalf("SupportBundle controller failed - Error %s", err)
+		}
+	}()
+	return nil
+}
+
+// InstallCRD creates the SupportBundle CRD unless it already exists and
+// waits for it to be served.
+func InstallCRD(ctx context.Context, config *rest.Config) error {
+	c, err := client.New(config, client.Options{})
+	if err != nil {
+		return err
+	}
+	crd := &unstructured.Unstructured{}
+	err = yaml.Unmarshal(v1alpha1.CustomResourceDefinition, &crd.Object)
+	if err != nil {
+		return err
+	}
+	err = c.Create(ctx, crd)
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("SupportBundle CRD creation failed: %w", err)
+	}
+	log.Infoln("Installed SupportBundle CRD")
+
+	deadline := time.Now().Add(time.Minute)
+	for time.Now().Before(deadline) {
+		err = c.Get(ctx, client.ObjectKeyFromObject(crd), crd)
+		if err == nil && crdEstablished(crd) {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+	return fmt.Errorf("SupportBundle CRD was not established in time")
+}
+
+func crdEstablished(crd *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+	for _, condition := range conditions {
+		c, ok := condition.(map[string]interface{})
+		if ok && c["type"] == "Established" && c["status"] == "True" {
+			return true
+		}
+	}
+	return false
+}
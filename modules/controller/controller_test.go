@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mattmattox/supportability-collector/modules/apis/v1alpha1"
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	"github.com/mattmattox/supportability-collector/modules/jobs"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReconcileUpload(t *testing.T) {
+	// Collections that are allowed to start fail fast without a cluster
+	t.Setenv("KUBECONFIG", filepath.Join(t.TempDir(), "missing"))
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	secret := func(name string, labeled bool, url string) *v1.Secret {
+		s := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Data:       map[string][]byte{"url": []byte(url)},
+		}
+		if labeled {
+			s.Labels = map[string]string{v1alpha1.UploadSecretLabel: "true"}
+		}
+		return s
+	}
+	tests := []struct {
+		name   string
+		upload *v1alpha1.UploadDestination
+		error  string
+	}{
+		{
+			name: "no upload",
+		},
+		{
+			name:   "allowed URL",
+			upload: &v1alpha1.UploadDestination{URL: "https://uploads.example.com/bundles/bundle.tar.gz"},
+		},
+		{
+			name:   "allowed URL in a labeled Secret",
+			upload: &v1alpha1.UploadDestination{SecretRef: "presigned"},
+		},
+		{
+			name:   "file URL",
+			upload: &v1alpha1.UploadDestination{URL: "file:///etc/cron.d"},
+			error:  `scheme "file" is not allowed`,
+		},
+		{
+			name:   "URL outside the allowlist",
+			upload: &v1alpha1.UploadDestination{URL: "https://attacker.example.com/bundle.tar.gz"},
+			error:  "not allowed by UPLOAD_ALLOWED_DESTINATIONS",
+		},
+		{
+			name:   "file URL in a labeled Secret",
+			upload: &v1alpha1.UploadDestination{SecretRef: "file"},
+			error:  `scheme "file" is not allowed`,
+		},
+		{
+			name:   "unlabeled Secret",
+			upload: &v1alpha1.UploadDestination{SecretRef: "credentials"},
+			error:  "is not labeled " + v1alpha1.UploadSecretLabel,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := cli.Cli{
+				BundleDir:                 t.TempDir(),
+				MaxConcurrentCollections:  1,
+				UploadAllowedDestinations: []string{"https://uploads.example.com/bundles/"},
+			}
+			manager, err := jobs.NewManager(settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bundle := &v1alpha1.SupportBundle{
+				ObjectMeta: metav1.ObjectMeta{Name: "bundle", Namespace: "default", UID: types.UID(test.name)},
+				Spec:       v1alpha1.SupportBundleSpec{Profile: "minimal", Upload: test.upload},
+			}
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+				bundle,
+				secret("presigned", true, "https://uploads.example.com/bundles/bundle.tar.gz?X-Amz-Signature=abc"),
+				secret("file", true, "file:///etc/cron.d"),
+				secret("credentials", false, "https://uploads.example.com/bundles/bundle.tar.gz"),
+			).Build()
+			r := &SupportBundleReconciler{Client: c, Jobs: manager, started: map[types.UID]string{}}
+
+			_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(bundle)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got v1alpha1.SupportBundle
+			err = c.Get(context.Background(), client.ObjectKeyFromObject(bundle), &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.error == "" {
+				if got.Status.JobID == "" || got.Status.Phase == v1alpha1.PhaseFailed {
+					t.Errorf("collection didn't start: %+v", got.Status)
+				}
+				return
+			}
+			if got.Status.Phase != v1alpha1.PhaseFailed || !strings.Contains(got.Status.Error, test.error) {
+				t.Errorf("status %+v, want failed with %q", got.Status, test.error)
+			}
+			if got.Status.JobID != "" || len(manager.List()) != 0 {
+				t.Errorf("collection started for a rejected destination")
+			}
+		})
+	}
+}
@@ -0,0 +1,337 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/mattmattox/supportability-collector/modules/analyze"
+)
+
+// volatileMetadata are metadata fields that change without anything
+// meaningful happening to the object.
+var volatileMetadata = []string{"resourceVersion", "managedFields", "creationTimestamp", "generation", "uid"}
+
+// volatileFields are ignored wherever they appear in an object.
+var volatileFields = map[string]bool{
+	"observedGeneration": true,
+	"startedAt":          true,
+	"finishedAt":         true,
+	"heartbeatTime":      true,
+}
+
+// listKeys identify the elements of object lists so that reordering a list
+// doesn't show up as a change of every element.
+var listKeys = []string{"type", "name", "key", "ip", "address"}
+
+// maxValueLength truncates long values in field changes.
+const maxValueLength = 120
+
+type ObjectRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	File       string `json:"file"`
+}
+
+type FieldChange struct {
+	Path string `json:"path"`
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+}
+
+type ObjectChange struct {
+	ObjectRef
+	Fields []FieldChange `json:"fields"`
+}
+
+type Result struct {
+	Added   []ObjectRef    `json:"added"`
+	Removed []ObjectRef    `json:"removed"`
+	Changed []ObjectChange `json:"changed"`
+	Summary []string       `json:"summary"`
+}
+
+// Compare reports the objects added, removed and changed between two
+// bundles, ignoring volatile metadata.
+func Compare(old *analyze.Bundle, new *analyze.Bundle) *Result {
+	result := &Result{
+		Added:   []ObjectRef{},
+		Removed: []ObjectRef{},
+		Changed: []ObjectChange{},
+	}
+	oldObjects := index(old)
+	newObjects := index(new)
+
+	for key, oldObject := range oldObjects {
+		newObject, ok := newObjects[key]
+		if !ok {
+			result.Removed = append(result.Removed, ref(oldObject))
+			continue
+		}
+		var fields []FieldChange
+		compareValues("", normalize(oldObject.Data), normalize(newObject.Data), &fields)
+		if len(fields) > 0 {
+			result.Changed = append(result.Changed, ObjectChange{ObjectRef: ref(newObject), Fields: fields})
+		}
+	}
+	for key, newObject := range newObjects {
+		if _, ok := oldObjects[key]; !ok {
+			result.Added = append(result.Added, ref(newObject))
+		}
+	}
+
+	sort.Slice(result.Added, func(i, j int) bool { return less(result.Added[i], result.Added[j]) })
+	sort.Slice(result.Removed, func(i, j int) bool { return less(result.Removed[i], result.Removed[j]) })
+	sort.Slice(result.Changed, func(i, j int) bool { return less(result.Changed[i].ObjectRef, result.Changed[j].ObjectRef) })
+	result.Summary = summarize(old, new, oldObjects, newObjects)
+	return result
+}
+
+// index keys the objects of a bundle by group, kind, namespace and name.
+// The version is left out so that an API version bump isn't reported as a
+// removal and an addition.
+func index(bundle *analyze.Bundle) map[string]analyze.Object {
+	objects := map[string]analyze.Object{}
+	for _, object := range bundle.Objects {
+		group := ""
+		if slash := strings.Index(object.APIVersion(), "/"); slash >= 0 {
+			group = object.APIVersion()[:slash]
+		}
+		key := group + "/" + object.Kind() + "/" + object.Namespace() + "/" + object.Name()
+		if _, ok := objects[key]; !ok {
+			objects[key] = object
+		}
+	}
+	return objects
+}
+
+func ref(object analyze.Object) ObjectRef {
+	return ObjectRef{
+		APIVersion: object.APIVersion(),
+		Kind:       object.Kind(),
+		Namespace:  object.Namespace(),
+		Name:       object.Name(),
+		File:       object.Path,
+	}
+}
+
+func less(a ObjectRef, b ObjectRef) bool {
+	if a.Kind != b.Kind {
+		return a.Kind < b.Kind
+	}
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	return a.Name < b.Name
+}
+
+// normalize returns a copy of an object without its volatile metadata and
+// timestamps.
+func normalize(data map[string]interface{}) map[string]interface{} {
+	normalized := strip(data).(map[string]interface{})
+	if metadata, ok := normalized["metadata"].(map[string]interface{}); ok {
+		for _, field := range volatileMetadata {
+			delete(metadata, field)
+		}
+	}
+	return normalized
+}
+
+func strip(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		stripped := map[string]interface{}{}
+		for key, child := range v {
+			if volatileFields[key] || strings.HasSuffix(key, "Time") || strings.HasSuffix(key, "Timestamp") {
+				continue
+			}
+			stripped[key] = strip(child)
+		}
+		return stripped
+	case []interface{}:
+		stripped := make([]interface{}, len(v))
+		for i, child := range v {
+			stripped[i] = strip(child)
+		}
+		return stripped
+	}
+	return value
+}
+
+func compareValues(path string, old interface{}, new interface{}, fields *[]FieldChange) {
+	if reflect.DeepEqual(old, new) {
+		return
+	}
+	oldMap, oldIsMap := old.(map[string]interface{})
+	newMap, newIsMap := new.(map[string]interface{})
+	if oldIsMap && newIsMap {
+		keys := map[string]bool{}
+		for key := range oldMap {
+			keys[key] = true
+		}
+		for key := range newMap {
+			keys[key] = true
+		}
+		for _, key := range sortedKeys(keys) {
+			compareValues(join(path, key), oldMap[key], newMap[key], fields)
+		}
+		return
+	}
+	oldList, oldIsList := old.([]interface{})
+	newList, newIsList := new.([]interface{})
+	if oldIsList && newIsList {
+		compareLists(path, oldList, newList, fields)
+		return
+	}
+	*fields = append(*fields, FieldChange{Path: path, Old: format(old), New: format(new)})
+}
+
+// compareLists matches list elements by their identifying key when every
+// element has one, and by position otherwise.
+func compareLists(path string, old []interface{}, new []interface{}, fields *[]FieldChange) {
+	key := listKey(old, new)
+	if key == "" {
+		for i := 0; i < len(old) || i < len(new); i++ {
+			var oldElement, newElement interface{}
+			if i < len(old) {
+				oldElement = old[i]
+			}
+			if i < len(new) {
+				newElement = new[i]
+			}
+			compareValues(fmt.Sprintf("%s[%d]", path, i), oldElement, newElement, fields)
+		}
+		return
+	}
+	elements := map[string][2]interface{}{}
+	var order []string
+	for i, list := range [][]interface{}{old, new} {
+		for _, element := range list {
+			id := fmt.Sprint(element.(map[string]interface{})[key])
+			pair, seen := elements[id]
+			if !seen {
+				order = append(order, id)
+			}
+			pair[i] = element
+			elements[id] = pair
+		}
+	}
+	for _, id := range order {
+		compareValues(fmt.Sprintf("%s[%s=%s]", path, key, id), elements[id][0], elements[id][1], fields)
+	}
+}
+
+func listKey(lists ...[]interface{}) string {
+	for _, key := range listKeys {
+		found := true
+		for _, list := range lists {
+			for _, element := range list {
+				m, ok := element.(map[string]interface{})
+				if !ok {
+					return ""
+				}
+				if _, ok := m[key]; !ok {
+					found = false
+				}
+			}
+		}
+		if found {
+			return key
+		}
+	}
+	return ""
+}
+
+func join(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func format(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	text, ok := value.(string)
+	if !ok {
+		raw, _ := json.Marshal(value)
+		text = string(raw)
+	}
+	if len(text) > maxValueLength {
+		text = text[:maxValueLength] + "..."
+	}
+	return text
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteText writes the result for humans, grouped by kind and namespace.
+func WriteText(w io.Writer, result *Result) error {
+	fmt.Fprintf(w, "%d added, %d removed, %d changed object(s)\n", len(result.Added), len(result.Removed), len(result.Changed))
+
+	if len(result.Summary) > 0 {
+		fmt.Fprintln(w, "\n== Rancher summary ==")
+		for _, line := range result.Summary {
+			fmt.Fprintln(w, line)
+		}
+	}
+
+	group := ""
+	heading := func(ref ObjectRef) {
+		current := ref.Kind
+		if ref.Namespace != "" {
+			current += " in " + ref.Namespace
+		}
+		if current != group {
+			group = current
+			fmt.Fprintf(w, "\n-- %s --\n", group)
+		}
+	}
+
+	if len(result.Added) > 0 {
+		fmt.Fprintln(w, "\n== Added ==")
+		for _, ref := range result.Added {
+			heading(ref)
+			fmt.Fprintf(w, "+ %s (%s)\n", ref.Name, ref.File)
+		}
+	}
+	if len(result.Removed) > 0 {
+		group = ""
+		fmt.Fprintln(w, "\n== Removed ==")
+		for _, ref := range result.Removed {
+			heading(ref)
+			fmt.Fprintf(w, "- %s (%s)\n", ref.Name, ref.File)
+		}
+	}
+	if len(result.Changed) > 0 {
+		group = ""
+		fmt.Fprintln(w, "\n== Changed ==")
+		for _, change := range result.Changed {
+			heading(change.ObjectRef)
+			fmt.Fprintf(w, "~ %s (%s)\n", change.Name, change.File)
+			for _, field := range change.Fields {
+				fmt.Fprintf(w, "    %s: %q -> %q\n", field.Path, field.Old, field.New)
+			}
+		}
+	}
+	return nil
+}
+
+func WriteJSON(w io.Writer, result *Result) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
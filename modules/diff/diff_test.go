@@ -0,0 +1,290 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mattmattox/supportability-collector/modules/analyze"
+)
+
+const (
+	rancherPod = `apiVersion: v1
+kind: Pod
+metadata:
+  name: rancher-1
+  namespace: cattle-system
+  resourceVersion: "%s"
+  uid: 1234
+status:
+  startTime: "2024-01-0%sT00:00:00Z"
+  containerStatuses:
+    - name: rancher
+      image: rancher/rancher:v2.7.5
+      restartCount: %s
+    - name: sidecar
+      image: busybox
+      restartCount: 0
+`
+	// upgradedPod lists the containers of rancherPod the other way round,
+	// with a new rancher image
+	upgradedPod = `apiVersion: v1
+kind: Pod
+metadata:
+  name: rancher-1
+  namespace: cattle-system
+  resourceVersion: "%s"
+  uid: 1234
+status:
+  startTime: "2024-01-0%sT00:00:00Z"
+  containerStatuses:
+    - name: sidecar
+      image: busybox
+      restartCount: 0
+    - name: rancher
+      image: rancher/rancher:v2.7.6
+      restartCount: %s
+`
+	cluster = `apiVersion: management.cattle.io/v3
+kind: Cluster
+metadata:
+  name: c-abcde
+spec:
+  displayName: prod
+status:
+  version:
+    gitVersion: %s
+  conditions:
+    - type: Ready
+      status: "True"
+      lastUpdateTime: "2024-01-0%sT00:00:00Z"
+`
+	setting = `apiVersion: management.cattle.io/v3
+kind: Setting
+metadata:
+  name: %s
+value: "%s"
+`
+	node = `apiVersion: v1
+kind: Node
+metadata:
+  name: node-1
+status:
+  nodeInfo:
+    kubeletVersion: %s
+`
+)
+
+// fill replaces the %s of a fixture in order.
+func fill(fixture string, values ...string) string {
+	for _, value := range values {
+		fixture = strings.Replace(fixture, "%s", value, 1)
+	}
+	return fixture
+}
+
+// bundleOf builds a bundle from file names and contents.
+func bundleOf(files map[string]string) *analyze.Bundle {
+	bundle := analyze.NewBundle()
+	for name, content := range files {
+		bundle.AddFile(name, []byte(content))
+	}
+	return bundle
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     map[string]string
+		new     map[string]string
+		added   []string
+		removed []string
+		changed []string
+		summary []string
+	}{
+		{
+			name: "volatile fields are ignored",
+			old:  map[string]string{"pods/cattle-system/rancher-1.yaml": fill(rancherPod, "100", "1", "0")},
+			new:  map[string]string{"pods/cattle-system/rancher-1.yaml": fill(rancherPod, "200", "2", "0")},
+		},
+		{
+			name:    "changed list elements are matched by name",
+			old:     map[string]string{"pods/cattle-system/rancher-1.yaml": fill(rancherPod, "100", "1", "0")},
+			new:     map[string]string{"pods/cattle-system/rancher-1.yaml": fill(upgradedPod, "200", "2", "3")},
+			changed: []string{`Pod cattle-system/rancher-1: status.containerStatuses[name=rancher].image "rancher/rancher:v2.7.5" -> "rancher/rancher:v2.7.6", status.containerStatuses[name=rancher].restartCount "0" -> "3"`},
+		},
+		{
+			name: "added and removed objects",
+			old: map[string]string{
+				"settings/server-url.yaml": fill(setting, "server-url", "https://rancher.example.com"),
+				"settings/telemetry.yaml":  fill(setting, "telemetry-opt", "in"),
+			},
+			new: map[string]string{
+				"settings/server-url.yaml": fill(setting, "server-url", "https://rancher.example.com"),
+				"nodes/node-1.yaml":        fill(node, "v1.26.8"),
+			},
+			added:   []string{"Node node-1"},
+			removed: []string{"Setting telemetry-opt"},
+			summary: []string{"Node node-1 added", "Setting telemetry-opt removed"},
+		},
+		{
+			name: "Rancher summary",
+			old: map[string]string{
+				"rancher-data/rancher-data.json":          `{"Version":"v2.7.5","ServerUrl":"https://rancher.example.com"}`,
+				"rancher-resources/clusters/c-abcde.yaml": fill(cluster, "v1.25.9", "1"),
+				"settings/server-url.yaml":                fill(setting, "auth-user-session-ttl-minutes", "960"),
+				"nodes/node-1.yaml":                       fill(node, "v1.25.9"),
+			},
+			new: map[string]string{
+				"rancher-data/rancher-data.json":          `{"Version":"v2.7.6","ServerUrl":"https://rancher.example.com"}`,
+				"rancher-resources/clusters/c-abcde.yaml": fill(cluster, "v1.26.8", "2"),
+				"settings/server-url.yaml":                fill(setting, "auth-user-session-ttl-minutes", "720"),
+				"nodes/node-1.yaml":                       fill(node, "v1.26.8"),
+			},
+			changed: []string{
+				`Cluster c-abcde: status.version.gitVersion "v1.25.9" -> "v1.26.8"`,
+				`Node node-1: status.nodeInfo.kubeletVersion "v1.25.9" -> "v1.26.8"`,
+				`Setting auth-user-session-ttl-minutes: value "960" -> "720"`,
+			},
+			summary: []string{
+				`Rancher Version: "v2.7.5" -> "v2.7.6"`,
+				`Cluster c-abcde (prod) status.version.gitVersion: "v1.25.9" -> "v1.26.8"`,
+				`Node node-1 status.nodeInfo.kubeletVersion: "v1.25.9" -> "v1.26.8"`,
+				`Setting auth-user-session-ttl-minutes value: "960" -> "720"`,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := Compare(bundleOf(test.old), bundleOf(test.new))
+			var added, removed, changed []string
+			for _, ref := range result.Added {
+				added = append(added, refName(ref))
+			}
+			for _, ref := range result.Removed {
+				removed = append(removed, refName(ref))
+			}
+			for _, change := range result.Changed {
+				var fields []string
+				for _, field := range change.Fields {
+					fields = append(fields, field.Path+" "+`"`+field.Old+`" -> "`+field.New+`"`)
+				}
+				changed = append(changed, refName(change.ObjectRef)+": "+strings.Join(fields, ", "))
+			}
+			for _, check := range []struct {
+				what      string
+				got, want []string
+			}{
+				{"added", added, test.added},
+				{"removed", removed, test.removed},
+				{"changed", changed, test.changed},
+				{"summary", result.Summary, test.summary},
+			} {
+				if !reflect.DeepEqual(check.got, check.want) {
+					t.Errorf("%s\n%s\nwant\n%s", check.what, strings.Join(check.got, "\n"), strings.Join(check.want, "\n"))
+				}
+			}
+		})
+	}
+}
+
+// refName names an object as "Kind namespace/name".
+func refName(ref ObjectRef) string {
+	if ref.Namespace != "" {
+		return ref.Kind + " " + ref.Namespace + "/" + ref.Name
+	}
+	return ref.Kind + " " + ref.Name
+}
+
+func TestWrite(t *testing.T) {
+	result := &Result{
+		Added:   []ObjectRef{{APIVersion: "v1", Kind: "Pod", Namespace: "cattle-system", Name: "rancher-2", File: "pods/cattle-system/rancher-2.yaml"}},
+		Removed: []ObjectRef{{APIVersion: "v1", Kind: "Pod", Namespace: "cattle-system", Name: "rancher-1", File: "pods/cattle-system/rancher-1.yaml"}},
+		Changed: []ObjectChange{{
+			ObjectRef: ObjectRef{APIVersion: "v1", Kind: "Node", Name: "node-1", File: "nodes/node-1.yaml"},
+			Fields:    []FieldChange{{Path: "status.nodeInfo.kubeletVersion", Old: "v1.25.9", New: "v1.26.8"}},
+		}},
+		Summary: []string{`Node node-1 status.nodeInfo.kubeletVersion: "v1.25.9" -> "v1.26.8"`},
+	}
+
+	var text bytes.Buffer
+	err := WriteText(&text, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `1 added, 1 removed, 1 changed object(s)
+
+== Rancher summary ==
+Node node-1 status.nodeInfo.kubeletVersion: "v1.25.9" -> "v1.26.8"
+
+== Added ==
+
+-- Pod in cattle-system --
++ rancher-2 (pods/cattle-system/rancher-2.yaml)
+
+== Removed ==
+
+-- Pod in cattle-system --
+- rancher-1 (pods/cattle-system/rancher-1.yaml)
+
+== Changed ==
+
+-- Node --
+~ node-1 (nodes/node-1.yaml)
+    status.nodeInfo.kubeletVersion: "v1.25.9" -> "v1.26.8"
+`
+	if text.String() != want {
+		t.Errorf("text\n%s\nwant\n%s", text.String(), want)
+	}
+
+	var out bytes.Buffer
+	err = WriteJSON(&out, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Result
+	err = json.Unmarshal(out.Bytes(), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, result) {
+		t.Errorf("JSON decodes to %+v, want %+v", decoded, result)
+	}
+}
+
+func TestCompareBundles(t *testing.T) {
+	bundles := map[string]map[string]string{
+		"old": {"nodes/node-1.yaml": fill(node, "v1.25.9"), "nodes/node-1/kubelet.log": "kubelet log"},
+		"new": {"nodes/node-1.yaml": fill(node, "v1.26.8"), "nodes/node-1/kubelet.log": "other log"},
+	}
+	paths := map[string]string{}
+	for name, files := range bundles {
+		dir := t.TempDir()
+		for file, content := range files {
+			path := filepath.Join(dir, file)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		paths[name] = dir
+	}
+	old, err := analyze.ReadBundle(paths["old"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	new, err := analyze.ReadBundle(paths["new"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := Compare(old, new)
+	if len(result.Added)+len(result.Removed) != 0 || len(result.Changed) != 1 || result.Changed[0].Name != "node-1" {
+		t.Errorf("result %+v, want node-1 changed", result)
+	}
+}
@@ -0,0 +1,88 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mattmattox/supportability-collector/modules/analyze"
+)
+
+// summaryFields are the fields of Rancher objects whose changes are
+// called out at the top of a diff, keyed by kind.
+var summaryFields = map[string][]string{
+	"Setting": {"value"},
+	"Feature": {"spec.value", "status.default"},
+	"Cluster": {
+		"status.version.gitVersion",
+		"status.conditions[type=Ready].status",
+		"status.conditions[type=Connected].status",
+	},
+	"Node": {"status.nodeInfo.kubeletVersion", "status.conditions[type=Ready].status"},
+}
+
+// summarize describes the changes to Rancher itself, its settings, cluster
+// states and Kubernetes versions.
+func summarize(old *analyze.Bundle, new *analyze.Bundle, oldObjects map[string]analyze.Object, newObjects map[string]analyze.Object) []string {
+	var summary []string
+
+	oldRancher, newRancher := rancherData(old), rancherData(new)
+	for _, field := range []string{"Version", "ServerUrl"} {
+		if oldRancher[field] != newRancher[field] {
+			summary = append(summary, fmt.Sprintf("Rancher %s: %q -> %q", field, oldRancher[field], newRancher[field]))
+		}
+	}
+
+	var lines []string
+	for key, oldObject := range oldObjects {
+		fields, ok := summaryFields[oldObject.Kind()]
+		if !ok || !isRancherSummaryObject(oldObject) {
+			continue
+		}
+		newObject, ok := newObjects[key]
+		if !ok {
+			lines = append(lines, fmt.Sprintf("%s %s removed", oldObject.Kind(), displayName(oldObject)))
+			continue
+		}
+		for _, field := range fields {
+			if oldValue, newValue := oldObject.Field(field), newObject.Field(field); oldValue != newValue {
+				lines = append(lines, fmt.Sprintf("%s %s %s: %q -> %q", oldObject.Kind(), displayName(newObject), field, oldValue, newValue))
+			}
+		}
+	}
+	for key, newObject := range newObjects {
+		if _, ok := summaryFields[newObject.Kind()]; !ok || !isRancherSummaryObject(newObject) {
+			continue
+		}
+		if _, ok := oldObjects[key]; !ok {
+			lines = append(lines, fmt.Sprintf("%s %s added", newObject.Kind(), displayName(newObject)))
+		}
+	}
+	sort.Strings(lines)
+	return append(summary, lines...)
+}
+
+// isRancherSummaryObject limits the summary to management.cattle.io
+// objects and the nodes of the local cluster.
+func isRancherSummaryObject(object analyze.Object) bool {
+	if object.Kind() == "Node" && object.APIVersion() == "v1" {
+		return true
+	}
+	return strings.HasPrefix(object.APIVersion(), "management.cattle.io/")
+}
+
+func displayName(object analyze.Object) string {
+	if name := object.Field("spec.displayName"); name != "" && name != object.Name() {
+		return object.Name() + " (" + name + ")"
+	}
+	return object.Name()
+}
+
+func rancherData(bundle *analyze.Bundle) map[string]string {
+	data := map[string]string{}
+	if raw, ok := bundle.Files["rancher-data/rancher-data.json"]; ok {
+		json.Unmarshal(raw, &data)
+	}
+	return data
+}
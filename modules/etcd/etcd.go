@@ -0,0 +1,273 @@
+package etcd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// DefaultQuotaBytes is etcd's default --quota-backend-bytes (2GiB).
+const DefaultQuotaBytes = 2 * 1024 * 1024 * 1024
+
+// Uint64 is a uint64 field of the etcd JSON gateway, which sends them as
+// strings. Plain numbers are accepted too. The responses of the client are
+// saved in the same format as the node diagnostics query the gateway.
+type Uint64 uint64
+
+func (u Uint64) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + strconv.FormatUint(uint64(u), 10) + `"`), nil
+}
+
+func (u *Uint64) UnmarshalJSON(data []byte) error {
+	value := strings.Trim(string(data), `"`)
+	if value == "" || value == "null" {
+		*u = 0
+		return nil
+	}
+	parsed, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return err
+	}
+	*u = Uint64(parsed)
+	return nil
+}
+
+type ResponseHeader struct {
+	ClusterID Uint64 `json:"cluster_id"`
+	MemberID  Uint64 `json:"member_id"`
+	Revision  Uint64 `json:"revision"`
+	RaftTerm  Uint64 `json:"raft_term"`
+}
+
+type Member struct {
+	ID         Uint64   `json:"ID"`
+	Name       string   `json:"name"`
+	PeerURLs   []string `json:"peerURLs"`
+	ClientURLs []string `json:"clientURLs"`
+	IsLearner  bool     `json:"isLearner"`
+}
+
+type MemberListResponse struct {
+	Header  ResponseHeader `json:"header"`
+	Members []Member       `json:"members"`
+}
+
+type StatusResponse struct {
+	Header           ResponseHeader `json:"header"`
+	Version          string         `json:"version"`
+	DbSize           Uint64         `json:"dbSize"`
+	Leader           Uint64         `json:"leader"`
+	RaftIndex        Uint64         `json:"raftIndex"`
+	RaftTerm         Uint64         `json:"raftTerm"`
+	RaftAppliedIndex Uint64         `json:"raftAppliedIndex"`
+	Errors           []string       `json:"errors"`
+	DbSizeInUse      Uint64         `json:"dbSizeInUse"`
+	IsLearner        bool           `json:"isLearner"`
+}
+
+type Alarm struct {
+	MemberID Uint64 `json:"memberID"`
+	Alarm    string `json:"alarm"`
+}
+
+type AlarmResponse struct {
+	Header ResponseHeader `json:"header"`
+	Alarms []Alarm        `json:"alarms"`
+}
+
+// Client queries one etcd member with the etcd v3 client.
+type Client struct {
+	endpoint string
+	timeout  time.Duration
+	client   *clientv3.Client
+}
+
+// NewClient connects to the member at endpoint, giving up after timeout,
+// which also bounds every request. http:// endpoints are dialed without
+// TLS.
+func NewClient(endpoint string, tlsConfig *tls.Config, timeout time.Duration) (*Client, error) {
+	if strings.HasPrefix(endpoint, "http://") {
+		tlsConfig = nil
+	}
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{endpoint},
+		TLS:         tlsConfig,
+		DialTimeout: timeout,
+		DialOptions: []grpc.DialOption{grpc.WithBlock(), grpc.WithReturnConnectionError()},
+		Logger:      zap.NewNop(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Client{endpoint: endpoint, timeout: timeout, client: client}, nil
+}
+
+func (c *Client) Close() error {
+	return c.client.Close()
+}
+
+// TLSConfig builds the client TLS configuration from the etcd CA, client
+// certificate and key files. Empty paths are skipped.
+func TLSConfig(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if certFile != "" && keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func (c *Client) MemberList() (*MemberListResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	response, err := c.client.MemberList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]Member, 0, len(response.Members))
+	for _, member := range response.Members {
+		members = append(members, Member{
+			ID:         Uint64(member.ID),
+			Name:       member.Name,
+			PeerURLs:   member.PeerURLs,
+			ClientURLs: member.ClientURLs,
+			IsLearner:  member.IsLearner,
+		})
+	}
+	return &MemberListResponse{Header: header(response.Header), Members: members}, nil
+}
+
+func (c *Client) Status() (*StatusResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	response, err := c.client.Status(ctx, c.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &StatusResponse{
+		Header:           header(response.Header),
+		Version:          response.Version,
+		DbSize:           Uint64(response.DbSize),
+		Leader:           Uint64(response.Leader),
+		RaftIndex:        Uint64(response.RaftIndex),
+		RaftTerm:         Uint64(response.RaftTerm),
+		RaftAppliedIndex: Uint64(response.RaftAppliedIndex),
+		Errors:           response.Errors,
+		DbSizeInUse:      Uint64(response.DbSizeInUse),
+		IsLearner:        response.IsLearner,
+	}, nil
+}
+
+func (c *Client) AlarmList() (*AlarmResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	response, err := c.client.AlarmList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	alarms := make([]Alarm, 0, len(response.Alarms))
+	for _, alarm := range response.Alarms {
+		alarms = append(alarms, Alarm{MemberID: Uint64(alarm.MemberID), Alarm: alarm.Alarm.String()})
+	}
+	return &AlarmResponse{Header: header(response.Header), Alarms: alarms}, nil
+}
+
+func header(h *etcdserverpb.ResponseHeader) ResponseHeader {
+	if h == nil {
+		return ResponseHeader{}
+	}
+	return ResponseHeader{
+		ClusterID: Uint64(h.ClusterId),
+		MemberID:  Uint64(h.MemberId),
+		Revision:  Uint64(h.Revision),
+		RaftTerm:  Uint64(h.RaftTerm),
+	}
+}
+
+func ParseMemberList(data []byte) (*MemberListResponse, error) {
+	var response MemberListResponse
+	err := json.Unmarshal(data, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func ParseStatus(data []byte) (*StatusResponse, error) {
+	var response StatusResponse
+	err := json.Unmarshal(data, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func ParseAlarmList(data []byte) (*AlarmResponse, error) {
+	var response AlarmResponse
+	err := json.Unmarshal(data, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// DefragHints returns advice based on how much of the database file is
+// unused and how close it is to the backend quota.
+func DefragHints(status *StatusResponse, alarms *AlarmResponse) []string {
+	var hints []string
+	if status != nil && status.DbSize > 0 {
+		free := status.DbSize - status.DbSizeInUse
+		if status.DbSizeInUse > 0 && free*2 > status.DbSize {
+			hints = append(hints, fmt.Sprintf("%.0f%% of the database (%s) is free space; a defrag would reclaim it", float64(free)*100/float64(status.DbSize), humanBytes(uint64(free))))
+		}
+		if uint64(status.DbSize)*10 > DefaultQuotaBytes*8 {
+			hints = append(hints, fmt.Sprintf("database size %s is above 80%% of the default %s quota", humanBytes(uint64(status.DbSize)), humanBytes(DefaultQuotaBytes)))
+		}
+	}
+	if alarms != nil {
+		for _, alarm := range alarms.Alarms {
+			if alarm.Alarm == "NOSPACE" {
+				hints = append(hints, fmt.Sprintf("member %x raised NOSPACE; compact, defrag and then disarm the alarm", uint64(alarm.MemberID)))
+			}
+		}
+	}
+	return hints
+}
+
+func humanBytes(size uint64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := uint64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
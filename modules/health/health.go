@@ -1,33 +1,123 @@
 package health
 
 import (
+	"encoding/json"
+	"net"
 	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/mattmattox/supportability-collector/modules/auth"
 	"github.com/mattmattox/supportability-collector/modules/cli"
+	"github.com/mattmattox/supportability-collector/modules/collect"
+	"github.com/mattmattox/supportability-collector/modules/kubernetes"
 
 	"github.com/mattmattox/supportability-collector/modules/logging"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	k8s "k8s.io/client-go/kubernetes"
 )
 
 var log = logging.SetupLogging()
 
+// apiServerTimeout bounds the apiserver readiness check.
+const apiServerTimeout = 5 * time.Second
+
 var gitCommit string
 var gitBranch string
+var buildDate string
+
+// Version describes the running build.
+type Version struct {
+	Commit     string   `json:"commit"`
+	Branch     string   `json:"branch"`
+	BuildDate  string   `json:"buildDate"`
+	GoVersion  string   `json:"goVersion"`
+	Platform   string   `json:"platform"`
+	Collectors []string `json:"collectors"`
+	Profiles   []string `json:"profiles"`
+}
+
+// Status is the body of the readiness and liveness endpoints. Checks holds
+// "ok" or the error of every check and is only served on their /checks
+// endpoints, which require authentication.
+type Status struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+var (
+	checksMu        sync.Mutex
+	readinessChecks = map[string]func() error{}
+	livenessChecks  = map[string]func() error{}
+)
 
 func PrintVersion() {
 	log.Printf("Current build version: %s", gitCommit)
 	log.Printf("Current build branch: %s", gitBranch)
+	log.Printf("Current build date: %s", buildDate)
+}
+
+// AddReadinessCheck adds a check to /readyz.
+func AddReadinessCheck(name string, check func() error) {
+	checksMu.Lock()
+	defer checksMu.Unlock()
+	readinessChecks[name] = check
 }
 
-func StartHealthServer(cli.Cli) {
+// AddLivenessCheck adds a check to /livez.
+func AddLivenessCheck(name string, check func() error) {
+	checksMu.Lock()
+	defer checksMu.Unlock()
+	livenessChecks[name] = check
+}
+
+// StartHealthServer serves the health, readiness, liveness, version and
+// metrics endpoints plus whatever routes adds to the router. Readiness
+// always checks the apiserver and the scratch directory collections are
+// written to. The server uses TLS and authentication when configured, see
+// the auth package.
+func StartHealthServer(settings cli.Cli, routes ...func(router *mux.Router)) {
+	AddReadinessCheck("apiserver", APIServerCheck)
+	AddReadinessCheck("storage", WritableCheck(os.TempDir()))
+	tlsConfig, err := auth.TLSConfig(settings)
+	if err != nil {
+		log.Fatalf("TLS configuration failed - Error %s", err)
+	}
+	authenticator, err := auth.New(settings)
+	if err != nil {
+		log.Fatalf("Authentication setup failed - Error %s", err)
+	}
 	go func() {
 		router := mux.NewRouter()
+		if authenticator != nil {
+			router.Use(authenticator.Middleware)
+		}
 		router.HandleFunc("/healthz", HealthHandler)
+		router.HandleFunc("/readyz", ReadyHandler)
+		router.HandleFunc("/readyz/checks", ReadyChecksHandler)
+		router.HandleFunc("/livez", LiveHandler)
+		router.HandleFunc("/livez/checks", LiveChecksHandler)
 		router.HandleFunc("/version", VersionHandler)
 		router.Handle("/metrics", promhttp.Handler())
-		address := "0.0.0.0:" + cli.Settings().HealthCheckPort
-		if err := http.ListenAndServe(address, router); err != nil {
+		for _, route := range routes {
+			route(router)
+		}
+		server := &http.Server{
+			Addr:      net.JoinHostPort(settings.ListenAddress, settings.HealthCheckPort),
+			Handler:   router,
+			TLSConfig: tlsConfig,
+		}
+		var err error
+		if tlsConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil {
 			log.Fatal(err)
 		} else {
 			log.Infoln("Health check server started")
@@ -40,7 +130,107 @@ func HealthHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
+// ReadyHandler answers kubelet readiness probes with the status alone, it
+// is served without authentication.
+func ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, Status{Status: runChecks(readinessChecks).Status})
+}
+
+// ReadyChecksHandler reports the result of every readiness check.
+func ReadyChecksHandler(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, runChecks(readinessChecks))
+}
+
+// LiveHandler answers kubelet liveness probes with the status alone, it is
+// served without authentication.
+func LiveHandler(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, Status{Status: runChecks(livenessChecks).Status})
+}
+
+// LiveChecksHandler reports the result of every liveness check.
+func LiveChecksHandler(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, runChecks(livenessChecks))
+}
+
 func VersionHandler(w http.ResponseWriter, r *http.Request) {
+	version := Version{
+		Commit:     gitCommit,
+		Branch:     gitBranch,
+		BuildDate:  buildDate,
+		GoVersion:  runtime.Version(),
+		Platform:   runtime.GOOS + "/" + runtime.GOARCH,
+		Collectors: []string{},
+		Profiles:   collect.ProfileNames(),
+	}
+	for _, collector := range collect.Collectors {
+		version.Collectors = append(version.Collectors, collector.Name)
+	}
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Version: " + gitCommit))
+	json.NewEncoder(w).Encode(version)
+}
+
+// APIServerCheck fails when the apiserver can't be reached within
+// apiServerTimeout.
+func APIServerCheck() error {
+	config, err := kubernetes.GetConfig()
+	if err != nil {
+		return err
+	}
+	config.Timeout = apiServerTimeout
+	client, err := k8s.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	_, err = kubernetes.GetServerVersion(client)
+	return err
+}
+
+// WritableCheck returns a check failing when a file can't be created in
+// dir.
+func WritableCheck(dir string) func() error {
+	return func() error {
+		f, err := os.CreateTemp(dir, ".writable-")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		return os.Remove(f.Name())
+	}
+}
+
+func runChecks(checks map[string]func() error) Status {
+	checksMu.Lock()
+	names := make([]string, 0, len(checks))
+	copied := make(map[string]func() error, len(checks))
+	for name, check := range checks {
+		names = append(names, name)
+		copied[name] = check
+	}
+	checksMu.Unlock()
+	sort.Strings(names)
+
+	status := Status{Status: "ok", Checks: map[string]string{}}
+	for _, name := range names {
+		if err := copied[name](); err != nil {
+			status.Status = "failed"
+			status.Checks[name] = err.Error()
+			continue
+		}
+		status.Checks[name] = "ok"
+	}
+	return status
+}
+
+func writeStatus(w http.ResponseWriter, status Status) {
+	w.Header().Set("Content-Type", "application/json")
+	if status.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	err := json.NewEncoder(w).Encode(status)
+	if err != nil {
+		log.Warningf("Writing status failed - Error %s", err)
+	}
 }
@@ -0,0 +1,49 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusHandlers(t *testing.T) {
+	AddReadinessCheck("storage", func() error { return errors.New("open /tmp/.writable-1: read-only file system") })
+	AddReadinessCheck("apiserver", func() error { return nil })
+	AddLivenessCheck("jobs", func() error { return nil })
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		code    int
+		status  string
+		checks  map[string]string
+	}{
+		{name: "readiness probe", handler: ReadyHandler, code: http.StatusServiceUnavailable, status: "failed"},
+		{name: "readiness checks", handler: ReadyChecksHandler, code: http.StatusServiceUnavailable, status: "failed", checks: map[string]string{"apiserver": "ok", "storage": "open /tmp/.writable-1: read-only file system"}},
+		{name: "liveness probe", handler: LiveHandler, code: http.StatusOK, status: "ok"},
+		{name: "liveness checks", handler: LiveChecksHandler, code: http.StatusOK, status: "ok", checks: map[string]string{"jobs": "ok"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			test.handler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+			if recorder.Code != test.code {
+				t.Errorf("status code %d, want %d", recorder.Code, test.code)
+			}
+			var status Status
+			err := json.NewDecoder(recorder.Body).Decode(&status)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.Status != test.status || len(status.Checks) != len(test.checks) {
+				t.Errorf("status %+v, want %s with %v", status, test.status, test.checks)
+			}
+			for name, result := range test.checks {
+				if status.Checks[name] != result {
+					t.Errorf("check %s reported %q, want %q", name, status.Checks[name], result)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,374 @@
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	"github.com/mattmattox/supportability-collector/modules/collect"
+	"github.com/mattmattox/supportability-collector/modules/logging"
+	"github.com/mattmattox/supportability-collector/modules/metrics"
+)
+
+var log = logging.SetupLogging()
+
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Request describes a collection to run. Upload is an upload destination
+// as understood by collect.Upload, callers pass the ones they don't trust
+// through CheckUpload first. Schedule is set by the scheduler only
+// and suffixes the bundle name with the schedule so retention can find it.
+// Trigger is set by the trigger watcher only and records what started the
+// collection.
+type Request struct {
+	Profile  string   `json:"profile"`
+	Clusters []string `json:"clusters,omitempty"`
+	Upload   string   `json:"upload,omitempty"`
+	Schedule string   `json:"-"`
+	Trigger  string   `json:"-"`
+}
+
+// Job is one asynchronous collection. The upload destination is kept out
+// of the JSON as presigned URLs carry credentials.
+type Job struct {
+	ID         string     `json:"id"`
+	Profile    string     `json:"profile"`
+	Clusters   []string   `json:"clusters,omitempty"`
+	Upload     string     `json:"-"`
+	Schedule   string     `json:"schedule,omitempty"`
+	Trigger    string     `json:"trigger,omitempty"`
+	Status     string     `json:"status"`
+	Step       string     `json:"step,omitempty"`
+	Done       int        `json:"done"`
+	Total      int        `json:"total"`
+	Bundle     string     `json:"bundle,omitempty"`
+	Checksum   string     `json:"checksum,omitempty"`
+	Location   string     `json:"location,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// Bundle is a collected bundle stored by the manager.
+type Bundle struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Manager runs collections in the background, at most Concurrency at a
+// time, and keeps their bundles in Dir. Finished jobs are forgotten after
+// HistoryTTL, or sooner when more than HistoryLimit of them are kept.
+type Manager struct {
+	Dir          string
+	Concurrency  int
+	HistoryTTL   time.Duration
+	HistoryLimit int
+
+	settings cli.Cli
+	slots    chan struct{}
+	mu       sync.Mutex
+	jobs     map[string]*Job
+	done     map[string]chan struct{}
+}
+
+func NewManager(settings cli.Cli) (*Manager, error) {
+	err := os.MkdirAll(settings.BundleDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	collect.RecoverFatal()
+	return &Manager{
+		Dir:          settings.BundleDir,
+		Concurrency:  settings.MaxConcurrentCollections,
+		HistoryTTL:   settings.JobHistoryTTL,
+		HistoryLimit: settings.JobHistoryLimit,
+		settings:     settings,
+		slots:        make(chan struct{}, settings.MaxConcurrentCollections),
+		jobs:         map[string]*Job{},
+		done:         map[string]chan struct{}{},
+	}, nil
+}
+
+// CheckUpload returns an error unless an upload destination chosen by an
+// API caller or a SupportBundle is allowed, see collect.CheckDestination.
+func (m *Manager) CheckUpload(destination string) error {
+	return collect.CheckDestination(destination, m.settings.UploadAllowedDestinations)
+}
+
+// Start queues a collection and returns its job.
+func (m *Manager) Start(request Request) (Job, error) {
+	profile := request.Profile
+	if profile == "" {
+		profile = collect.DefaultProfile
+	}
+	if _, err := collect.ProfileCollectors(m.settings, profile); err != nil {
+		return Job{}, err
+	}
+	id, err := newID()
+	if err != nil {
+		return Job{}, err
+	}
+	job := &Job{
+		ID:        id,
+		Profile:   profile,
+		Clusters:  request.Clusters,
+		Upload:    request.Upload,
+		Schedule:  request.Schedule,
+		Trigger:   request.Trigger,
+		Status:    StatusQueued,
+		CreatedAt: time.Now().UTC(),
+	}
+	job.UpdatedAt = job.CreatedAt
+	m.mu.Lock()
+	m.prune(job.CreatedAt)
+	done := make(chan struct{})
+	m.jobs[id] = job
+	m.done[id] = done
+	m.mu.Unlock()
+	log.Infof("Queued collection %s with profile %s", id, profile)
+
+	go m.run(job, done)
+	return m.snapshot(job), nil
+}
+
+func (m *Manager) run(job *Job, done chan struct{}) {
+	m.slots <- struct{}{}
+	defer func() { <-m.slots }()
+	defer close(done)
+
+	var suffix string
+	m.update(job, func(job *Job) {
+		now := time.Now().UTC()
+		job.Status = StatusRunning
+		job.StartedAt = &now
+		if job.Schedule != "" {
+			suffix = ScheduleSuffix(job.Schedule)
+		}
+	})
+	log.Infof("Starting collection %s", job.ID)
+
+	tarFile, checksum, location, err := m.collect(job, suffix)
+
+	m.update(job, func(job *Job) {
+		now := time.Now().UTC()
+		job.FinishedAt = &now
+		job.Step = ""
+		if tarFile != "" {
+			job.Bundle = filepath.Base(tarFile)
+		}
+		if err != nil {
+			job.Status = StatusFailed
+			job.Error = err.Error()
+			return
+		}
+		job.Status = StatusSucceeded
+		job.Done = job.Total
+		job.Checksum = checksum
+		job.Location = location
+	})
+	if err != nil {
+		log.Warningf("Collection %s failed - Error %s", job.ID, err)
+		return
+	}
+	log.Infof("Collection %s complete: %s", job.ID, tarFile)
+}
+
+// collect runs the collection of a job, then checksums and uploads its
+// bundle. Panics fail the job instead of the server. The job counts as a
+// failed collection whichever of these steps failed.
+func (m *Manager) collect(job *Job, suffix string) (tarFile string, checksum string, location string, err error) {
+	metrics.CollectionsTotal.WithLabelValues(job.Profile).Inc()
+	defer func() { metrics.RecordCollection(job.Profile, tarFile, err) }()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Collection %s panicked - Error %v\n%s", job.ID, r, debug.Stack())
+			err = fmt.Errorf("collection panicked: %v", r)
+		}
+	}()
+	tarFile, err = collect.Collect(m.settings, collect.Options{
+		Profile:   job.Profile,
+		Clusters:  job.Clusters,
+		OutputDir: m.Dir,
+		Suffix:    suffix,
+		Progress: func(step string, done int, total int) {
+			m.update(job, func(job *Job) {
+				job.Step, job.Done, job.Total = step, done, total
+			})
+		},
+	})
+	if err != nil {
+		return tarFile, "", "", err
+	}
+	checksum, err = collect.Checksum(tarFile)
+	if err != nil {
+		return tarFile, "", "", err
+	}
+	m.update(job, func(job *Job) { job.Step = "upload" })
+	location, err = collect.Upload(tarFile, job.Upload)
+	return tarFile, checksum, location, err
+}
+
+// prune forgets the finished jobs that expired at now and the oldest ones
+// beyond HistoryLimit. It's called with m.mu held.
+func (m *Manager) prune(now time.Time) {
+	var finished []*Job
+	for id, job := range m.jobs {
+		if job.FinishedAt == nil {
+			continue
+		}
+		if m.HistoryTTL > 0 && now.Sub(*job.FinishedAt) > m.HistoryTTL {
+			delete(m.jobs, id)
+			delete(m.done, id)
+			continue
+		}
+		finished = append(finished, job)
+	}
+	if m.HistoryLimit <= 0 || len(finished) <= m.HistoryLimit {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].FinishedAt.After(*finished[j].FinishedAt) })
+	for _, job := range finished[m.HistoryLimit:] {
+		delete(m.jobs, job.ID)
+		delete(m.done, job.ID)
+	}
+}
+
+func (m *Manager) update(job *Job, change func(job *Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	change(job)
+	job.UpdatedAt = time.Now().UTC()
+}
+
+func (m *Manager) snapshot(job *Job) Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return *job
+}
+
+// Get returns a job by ID.
+func (m *Manager) Get(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return *job, nil
+}
+
+// Wait blocks until a job has finished and returns it.
+func (m *Manager) Wait(id string) (Job, error) {
+	m.mu.Lock()
+	done, ok := m.done[id]
+	m.mu.Unlock()
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	<-done
+	return m.Get(id)
+}
+
+// List returns every job, newest first.
+func (m *Manager) List() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	return jobs
+}
+
+// Stuck returns the running jobs that made no progress for longer than
+// timeout.
+func (m *Manager) Stuck(timeout time.Duration) []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var stuck []Job
+	for _, job := range m.jobs {
+		if job.Status == StatusRunning && time.Since(job.UpdatedAt) > timeout {
+			stuck = append(stuck, *job)
+		}
+	}
+	return stuck
+}
+
+// Bundles returns the bundles in the bundle directory, newest first.
+func (m *Manager) Bundles() ([]Bundle, error) {
+	entries, err := os.ReadDir(m.Dir)
+	if err != nil {
+		return nil, err
+	}
+	bundles := []Bundle{}
+	for _, entry := range entries {
+		if entry.IsDir() || !collect.IsBundle(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		bundles = append(bundles, Bundle{Name: entry.Name(), Size: info.Size(), CreatedAt: info.ModTime().UTC()})
+	}
+	sort.Slice(bundles, func(i, j int) bool { return bundles[i].CreatedAt.After(bundles[j].CreatedAt) })
+	return bundles, nil
+}
+
+// BundlePath returns the path of a bundle. Names are checked so that a
+// request can't reach outside the bundle directory.
+func (m *Manager) BundlePath(name string) (string, error) {
+	if name != filepath.Base(name) || !collect.IsBundle(name) || strings.HasPrefix(name, ".") {
+		return "", ErrNotFound
+	}
+	path := filepath.Join(m.Dir, name)
+	if _, err := os.Stat(path); err != nil {
+		return "", ErrNotFound
+	}
+	return path, nil
+}
+
+// DeleteBundle removes a bundle from the bundle directory.
+func (m *Manager) DeleteBundle(name string) error {
+	path, err := m.BundlePath(name)
+	if err != nil {
+		return err
+	}
+	log.Infof("Deleting bundle %s", name)
+	return os.Remove(path)
+}
+
+// ScheduleSuffix returns the suffix following the bundle root in the names
+// of the bundles collected for a schedule.
+func ScheduleSuffix(schedule string) string {
+	return "-" + schedule
+}
+
+func newID() (string, error) {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
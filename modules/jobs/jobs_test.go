@@ -0,0 +1,78 @@
+package jobs
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPrune(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	finished := func(ago time.Duration) *time.Time {
+		at := now.Add(-ago)
+		return &at
+	}
+	tests := []struct {
+		name  string
+		ttl   time.Duration
+		limit int
+		jobs  map[string]*time.Time
+		want  []string
+	}{
+		{
+			name:  "running jobs are kept",
+			ttl:   time.Hour,
+			limit: 1,
+			jobs:  map[string]*time.Time{"a": nil, "b": nil, "c": finished(2 * time.Hour)},
+			want:  []string{"a", "b"},
+		},
+		{
+			name:  "expired jobs are dropped",
+			ttl:   time.Hour,
+			limit: 10,
+			jobs:  map[string]*time.Time{"old": finished(2 * time.Hour), "new": finished(time.Minute)},
+			want:  []string{"new"},
+		},
+		{
+			name:  "oldest jobs beyond the limit are dropped",
+			ttl:   time.Hour,
+			limit: 2,
+			jobs:  map[string]*time.Time{"a": finished(3 * time.Minute), "b": finished(2 * time.Minute), "c": finished(time.Minute)},
+			want:  []string{"b", "c"},
+		},
+		{
+			name: "zero settings keep everything",
+			jobs: map[string]*time.Time{"a": finished(1000 * time.Hour), "b": finished(time.Minute)},
+			want: []string{"a", "b"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &Manager{HistoryTTL: test.ttl, HistoryLimit: test.limit, jobs: map[string]*Job{}, done: map[string]chan struct{}{}}
+			for id, finishedAt := range test.jobs {
+				m.jobs[id] = &Job{ID: id, FinishedAt: finishedAt}
+				m.done[id] = make(chan struct{})
+			}
+			m.prune(now)
+			var got []string
+			for id := range m.jobs {
+				got = append(got, id)
+				if _, ok := m.done[id]; !ok {
+					t.Errorf("job %s kept without its done channel", id)
+				}
+			}
+			sort.Strings(got)
+			if len(m.done) != len(m.jobs) {
+				t.Errorf("%d done channels kept for %d jobs", len(m.done), len(m.jobs))
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("kept %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("kept %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+}
@@ -1,21 +1,36 @@
 package kubernetes
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"strings"
 
+	"github.com/mattmattox/supportability-collector/modules/metrics"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	networkingV1 "k8s.io/api/networking/v1"
+	storagev1 "k8s.io/api/storage/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/transport/spdy"
 	"sigs.k8s.io/yaml"
 )
 
@@ -34,23 +49,28 @@ func parseJSON(data []byte) (string, error) {
 	return d.Value, nil
 }
 
-func GetClient() (*kubernetes.Clientset, error) {
+func GetConfig() (*rest.Config, error) {
+	var config *rest.Config
+	var err error
 	if os.Getenv("KUBECONFIG") != "" {
 		// If the KUBECONFIG environment variable is set, use it to build the client configuration
 		kubeConfigPath := os.Getenv("KUBECONFIG")
-		kubeConfig, err := clientcmd.BuildConfigFromFlags("", kubeConfigPath)
-		if err != nil {
-			return nil, err
-		}
-		client, err := kubernetes.NewForConfig(kubeConfig)
-		if err != nil {
-			return nil, err
-		}
-		return client, nil
+		config, err = clientcmd.BuildConfigFromFlags("", kubeConfigPath)
+	} else {
+		// If the KUBECONFIG environment variable is not set, try to use the in-cluster configuration
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	// If the KUBECONFIG environment variable is not set, try to use the in-cluster configuration
-	config, err := rest.InClusterConfig()
+	// Count failed API requests
+	config.Wrap(metrics.Transport)
+	return config, nil
+}
+
+func GetClient() (*kubernetes.Clientset, error) {
+	config, err := GetConfig()
 	if err != nil {
 		return nil, err
 	}
@@ -61,7 +81,8 @@ func GetClient() (*kubernetes.Clientset, error) {
 	return client, nil
 }
 
-func GetRancherVersion(config *rest.Config) (string, error) {
+// getRancherSetting returns the value of a management.cattle.io setting.
+func getRancherSetting(config *rest.Config, name string) (string, error) {
 	crdConfig := *config
 	crdConfig.ContentConfig.GroupVersion = &schema.GroupVersion{Group: "management.cattle.io", Version: "v3"}
 	crdConfig.APIPath = "/apis"
@@ -69,92 +90,38 @@ func GetRancherVersion(config *rest.Config) (string, error) {
 	crdConfig.UserAgent = rest.DefaultKubernetesUserAgent()
 	crdClient, err := rest.RESTClientFor(&crdConfig)
 	if err != nil {
-		panic(err.Error())
+		return "", err
 	}
 	result, err := crdClient.
 		Get().
-		AbsPath("/apis/management.cattle.io/v3/settings/server-version").
+		AbsPath("/apis/management.cattle.io/v3/settings/" + name).
 		DoRaw(context.TODO())
 	if err != nil {
-		panic(err.Error())
-	}
-	rancherVersion, err := parseJSON(result)
-	if err != nil {
-		log.Fatalln(err)
+		return "", err
 	}
-	return rancherVersion, nil
+	return parseJSON(result)
+}
+
+func GetRancherVersion(config *rest.Config) (string, error) {
+	return getRancherSetting(config, "server-version")
 }
 
 func GetRancherUUID(config *rest.Config) (string, error) {
-	crdConfig := *config
-	crdConfig.ContentConfig.GroupVersion = &schema.GroupVersion{Group: "management.cattle.io", Version: "v3"}
-	crdConfig.APIPath = "/apis"
-	crdConfig.NegotiatedSerializer = scheme.Codecs.WithoutConversion()
-	crdConfig.UserAgent = rest.DefaultKubernetesUserAgent()
-	crdClient, err := rest.RESTClientFor(&crdConfig)
-	if err != nil {
-		panic(err.Error())
-	}
-	result, err := crdClient.
-		Get().
-		AbsPath("/apis/management.cattle.io/v3/settings/install-uuid").
-		DoRaw(context.TODO())
-	if err != nil {
-		panic(err.Error())
-	}
-	rancherUUID, err := parseJSON(result)
-	if err != nil {
-		log.Fatalln(err)
-	}
-	return rancherUUID, nil
+	return getRancherSetting(config, "install-uuid")
 }
 
 func GetRancherServerURL(config *rest.Config) (string, error) {
-	crdConfig := *config
-	crdConfig.ContentConfig.GroupVersion = &schema.GroupVersion{Group: "management.cattle.io", Version: "v3"}
-	crdConfig.APIPath = "/apis"
-	crdConfig.NegotiatedSerializer = scheme.Codecs.WithoutConversion()
-	crdConfig.UserAgent = rest.DefaultKubernetesUserAgent()
-	crdClient, err := rest.RESTClientFor(&crdConfig)
-	if err != nil {
-		panic(err.Error())
-	}
-	result, err := crdClient.
-		Get().
-		AbsPath("/apis/management.cattle.io/v3/settings/server-url").
-		DoRaw(context.TODO())
-	if err != nil {
-		panic(err.Error())
-	}
-	rancherServerURL, err := parseJSON(result)
-	if err != nil {
-		log.Fatalln(err)
-	}
-	return rancherServerURL, nil
+	return getRancherSetting(config, "server-url")
 }
 
 func GetRancherEulaDate(config *rest.Config) (string, error) {
-	crdConfig := *config
-	crdConfig.ContentConfig.GroupVersion = &schema.GroupVersion{Group: "management.cattle.io", Version: "v3"}
-	crdConfig.APIPath = "/apis"
-	crdConfig.NegotiatedSerializer = scheme.Codecs.WithoutConversion()
-	crdConfig.UserAgent = rest.DefaultKubernetesUserAgent()
-	crdClient, err := rest.RESTClientFor(&crdConfig)
-	if err != nil {
-		panic(err.Error())
-	}
-	result, err := crdClient.
-		Get().
-		AbsPath("/apis/management.cattle.io/v3/settings/eula-agreed").
-		DoRaw(context.TODO())
-	if err != nil {
-		panic(err.Error())
-	}
-	rancherStartDate, err := parseJSON(result)
-	if err != nil {
-		log.Fatalln(err)
-	}
-	return rancherStartDate, nil
+	return getRancherSetting(config, "eula-agreed")
+}
+
+// GetRancherCACerts returns the PEM private CA of the Rancher server, empty
+// when its certificate is publicly trusted.
+func GetRancherCACerts(config *rest.Config) (string, error) {
+	return getRancherSetting(config, "cacerts")
 }
 
 func GetRancherClusters(config *rest.Config) ([]string, error) {
@@ -740,7 +707,7 @@ func GetDeploymentYaml(client *kubernetes.Clientset, namespace string, deploymen
 	return deploy, nil
 }
 
-func GetDaemonSets(client *kubernetes.Clientset, namespace string) ([]string, error) {
+func GetDaemonSets(client kubernetes.Interface, namespace string) ([]string, error) {
 	daemonsets, err := client.AppsV1().DaemonSets(namespace).List(context.Background(), metav1.ListOptions{})
 	if err != nil {
 		return nil, err
@@ -752,7 +719,7 @@ func GetDaemonSets(client *kubernetes.Clientset, namespace string) ([]string, er
 	return daemonsetList, nil
 }
 
-func GetDaemonSetYaml(client *kubernetes.Clientset, namespace string, daemonset string) (*appsv1.DaemonSet, error) {
+func GetDaemonSetYaml(client kubernetes.Interface, namespace string, daemonset string) (*appsv1.DaemonSet, error) {
 	ds, err := client.AppsV1().DaemonSets(namespace).Get(context.Background(), daemonset, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
@@ -832,7 +799,7 @@ func GetPods(client *kubernetes.Clientset, namespace string) ([]string, error) {
 	return podList, nil
 }
 
-func GetPodYaml(client *kubernetes.Clientset, namespace string, pod string) (*v1.Pod, error) {
+func GetPodYaml(client kubernetes.Interface, namespace string, pod string) (*v1.Pod, error) {
 	p, err := client.CoreV1().Pods(namespace).Get(context.Background(), pod, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
@@ -840,7 +807,7 @@ func GetPodYaml(client *kubernetes.Clientset, namespace string, pod string) (*v1
 	return p, nil
 }
 
-func GetServices(client *kubernetes.Clientset, namespace string) ([]string, error) {
+func GetServices(client kubernetes.Interface, namespace string) ([]string, error) {
 	services, err := client.CoreV1().Services(namespace).List(context.Background(), metav1.ListOptions{})
 	if err != nil {
 		return nil, err
@@ -852,7 +819,7 @@ func GetServices(client *kubernetes.Clientset, namespace string) ([]string, erro
 	return serviceList, nil
 }
 
-func GetServiceYaml(client *kubernetes.Clientset, namespace string, service string) (*v1.Service, error) {
+func GetServiceYaml(client kubernetes.Interface, namespace string, service string) (*v1.Service, error) {
 	s, err := client.CoreV1().Services(namespace).Get(context.Background(), service, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
@@ -872,7 +839,7 @@ func GetEndpoints(client *kubernetes.Clientset, namespace string) ([]string, err
 	return endpointList, nil
 }
 
-func GetEndpointYaml(client *kubernetes.Clientset, namespace string, endpoint string) (*v1.Endpoints, error) {
+func GetEndpointYaml(client kubernetes.Interface, namespace string, endpoint string) (*v1.Endpoints, error) {
 	e, err := client.CoreV1().Endpoints(namespace).Get(context.Background(), endpoint, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
@@ -952,7 +919,7 @@ func GetConfigMaps(client *kubernetes.Clientset, namespace string) ([]string, er
 	return configmapList, nil
 }
 
-func GetConfigMapYaml(client *kubernetes.Clientset, namespace string, configmap string) (*v1.ConfigMap, error) {
+func GetConfigMapYaml(client kubernetes.Interface, namespace string, configmap string) (*v1.ConfigMap, error) {
 	cm, err := client.CoreV1().ConfigMaps(namespace).Get(context.Background(), configmap, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
@@ -999,3 +966,567 @@ func GetNodeYaml(client *kubernetes.Clientset, node string) (*v1.Node, error) {
 	}
 	return n, nil
 }
+
+func GetValidatingWebhookConfigurations(client *kubernetes.Clientset) ([]string, error) {
+	webhooks, err := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var webhookList []string
+	for _, webhook := range webhooks.Items {
+		webhookList = append(webhookList, webhook.Name)
+	}
+	return webhookList, nil
+}
+
+func GetValidatingWebhookConfigurationYaml(client *kubernetes.Clientset, webhook string) (*admissionregistrationv1.ValidatingWebhookConfiguration, error) {
+	w, err := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.Background(), webhook, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func GetMutatingWebhookConfigurations(client *kubernetes.Clientset) ([]string, error) {
+	webhooks, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var webhookList []string
+	for _, webhook := range webhooks.Items {
+		webhookList = append(webhookList, webhook.Name)
+	}
+	return webhookList, nil
+}
+
+func GetMutatingWebhookConfigurationYaml(client *kubernetes.Clientset, webhook string) (*admissionregistrationv1.MutatingWebhookConfiguration, error) {
+	w, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.Background(), webhook, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func GetAPIServices(config *rest.Config) ([]string, error) {
+	// Set up the CRD client configuration
+	crdConfig := *config
+	crdConfig.ContentConfig.GroupVersion = &schema.GroupVersion{Group: "apiregistration.k8s.io", Version: "v1"}
+	crdConfig.APIPath = "/apis"
+	crdConfig.NegotiatedSerializer = scheme.Codecs.WithoutConversion()
+	crdConfig.UserAgent = rest.DefaultKubernetesUserAgent()
+
+	// Create the CRD client
+	crdClient, err := rest.RESTClientFor(&crdConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retrieve the list of API services
+	result, err := crdClient.
+		Get().
+		AbsPath("/apis/apiregistration.k8s.io/v1/apiservices").
+		DoRaw(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+
+	// Define a struct to hold the list response from the API server
+	type APIServiceList struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			}
+		}
+	}
+
+	var response APIServiceList
+	err = json.Unmarshal(result, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	var apiServices []string
+	for _, item := range response.Items {
+		apiServices = append(apiServices, item.Metadata.Name)
+	}
+	return apiServices, nil
+}
+
+func GetAPIServiceYaml(config *rest.Config, apiService string) (string, error) {
+	// Set up the CRD client configuration
+	crdConfig := *config
+	crdConfig.ContentConfig.GroupVersion = &schema.GroupVersion{Group: "apiregistration.k8s.io", Version: "v1"}
+	crdConfig.APIPath = "/apis"
+	crdConfig.NegotiatedSerializer = scheme.Codecs.WithoutConversion()
+	crdConfig.UserAgent = rest.DefaultKubernetesUserAgent()
+
+	// Create the CRD client
+	crdClient, err := rest.RESTClientFor(&crdConfig)
+	if err != nil {
+		return "", err
+	}
+
+	// Retrieve the API service data
+	result, err := crdClient.
+		Get().
+		AbsPath("/apis/apiregistration.k8s.io/v1/apiservices/" + apiService).
+		DoRaw(context.TODO())
+	if err != nil {
+		return "", err
+	}
+
+	// Convert the API service data to YAML
+	yamlData, err := yaml.JSONToYAML(result)
+	if err != nil {
+		log.Println(err)
+		return "", err
+	}
+
+	return string(yamlData), nil
+}
+
+func CreateDaemonSet(client kubernetes.Interface, namespace string, daemonset *appsv1.DaemonSet) (*appsv1.DaemonSet, error) {
+	ds, err := client.AppsV1().DaemonSets(namespace).Create(context.Background(), daemonset, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+func DeleteDaemonSet(client kubernetes.Interface, namespace string, daemonset string) error {
+	propagation := metav1.DeletePropagationForeground
+	return client.AppsV1().DaemonSets(namespace).Delete(context.Background(), daemonset, metav1.DeleteOptions{PropagationPolicy: &propagation})
+}
+
+func GetDaemonSetStatus(client kubernetes.Interface, namespace string, daemonset string) (*appsv1.DaemonSetStatus, error) {
+	ds, err := client.AppsV1().DaemonSets(namespace).Get(context.Background(), daemonset, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &ds.Status, nil
+}
+
+func GetPodsBySelector(client kubernetes.Interface, namespace string, selector string) ([]v1.Pod, error) {
+	pods, err := client.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
+func GetPodLogs(client kubernetes.Interface, namespace string, pod string, container string) ([]byte, error) {
+	logs, err := client.CoreV1().Pods(namespace).GetLogs(pod, &v1.PodLogOptions{Container: container}).DoRaw(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// ExecPod runs command in a container of a pod and copies its standard
+// output to stdout. Standard error is added to the error of a failed
+// command.
+func ExecPod(config *rest.Config, client kubernetes.Interface, namespace string, pod string, container string, command []string, stdout io.Writer) error {
+	request := client.CoreV1().RESTClient().
+		Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&v1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(config, "POST", request.URL())
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	err = executor.StreamWithContext(context.Background(), remotecommand.StreamOptions{Stdout: stdout, Stderr: &stderr})
+	if err != nil && stderr.Len() > 0 {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return err
+}
+
+// PortForwardPod forwards a random local port on 127.0.0.1 to port of a
+// pod and returns it with a function stopping the forward.
+func PortForwardPod(config *rest.Config, client kubernetes.Interface, namespace string, pod string, port int) (uint16, func(), error) {
+	transport, upgrader, err := spdy.RoundTripperFor(config)
+	if err != nil {
+		return 0, nil, err
+	}
+	request := client.CoreV1().RESTClient().
+		Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("portforward")
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, request.URL())
+	stop := make(chan struct{})
+	ready := make(chan struct{})
+	forwarder, err := portforward.NewOnAddresses(dialer, []string{"127.0.0.1"}, []string{fmt.Sprintf("0:%d", port)}, stop, ready, io.Discard, io.Discard)
+	if err != nil {
+		return 0, nil, err
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- forwarder.ForwardPorts()
+	}()
+	select {
+	case <-ready:
+	case err = <-errs:
+		return 0, nil, err
+	}
+	ports, err := forwarder.GetPorts()
+	if err != nil {
+		close(stop)
+		return 0, nil, err
+	}
+	return ports[0].Local, func() { close(stop) }, nil
+}
+
+func GetNodesBySelector(client kubernetes.Interface, selector string) ([]v1.Node, error) {
+	nodes, err := client.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	return nodes.Items, nil
+}
+
+func GetNodeMetrics(config *rest.Config) ([]byte, error) {
+	// Set up the metrics client configuration
+	crdConfig := *config
+	crdConfig.ContentConfig.GroupVersion = &schema.GroupVersion{Group: "metrics.k8s.io", Version: "v1beta1"}
+	crdConfig.APIPath = "/apis"
+	crdConfig.NegotiatedSerializer = scheme.Codecs.WithoutConversion()
+	crdConfig.UserAgent = rest.DefaultKubernetesUserAgent()
+
+	// Create the metrics client
+	crdClient, err := rest.RESTClientFor(&crdConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retrieve the node usage
+	return crdClient.
+		Get().
+		AbsPath("/apis/metrics.k8s.io/v1beta1/nodes").
+		DoRaw(context.TODO())
+}
+
+func GetPodMetrics(config *rest.Config) ([]byte, error) {
+	// Set up the metrics client configuration
+	crdConfig := *config
+	crdConfig.ContentConfig.GroupVersion = &schema.GroupVersion{Group: "metrics.k8s.io", Version: "v1beta1"}
+	crdConfig.APIPath = "/apis"
+	crdConfig.NegotiatedSerializer = scheme.Codecs.WithoutConversion()
+	crdConfig.UserAgent = rest.DefaultKubernetesUserAgent()
+
+	// Create the metrics client
+	crdClient, err := rest.RESTClientFor(&crdConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retrieve the pod usage across all namespaces
+	return crdClient.
+		Get().
+		AbsPath("/apis/metrics.k8s.io/v1beta1/pods").
+		DoRaw(context.TODO())
+}
+
+func ProxyGetService(client kubernetes.Interface, namespace string, scheme string, service string, port string, path string, params map[string]string) ([]byte, error) {
+	return client.CoreV1().Services(namespace).ProxyGet(scheme, service, port, path, params).DoRaw(context.Background())
+}
+
+func GetStorageClasses(client kubernetes.Interface) ([]string, error) {
+	storageclasss, err := client.StorageV1().StorageClasses().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var storageclassList []string
+	for _, storageclass := range storageclasss.Items {
+		storageclassList = append(storageclassList, storageclass.Name)
+	}
+	return storageclassList, nil
+}
+
+func GetStorageClassYaml(client kubernetes.Interface, storageclass string) (*storagev1.StorageClass, error) {
+	sc, err := client.StorageV1().StorageClasses().Get(context.Background(), storageclass, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+func GetVolumeAttachments(client kubernetes.Interface) ([]string, error) {
+	volumeattachments, err := client.StorageV1().VolumeAttachments().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var volumeattachmentList []string
+	for _, volumeattachment := range volumeattachments.Items {
+		volumeattachmentList = append(volumeattachmentList, volumeattachment.Name)
+	}
+	return volumeattachmentList, nil
+}
+
+func GetVolumeAttachmentYaml(client kubernetes.Interface, volumeattachment string) (*storagev1.VolumeAttachment, error) {
+	va, err := client.StorageV1().VolumeAttachments().Get(context.Background(), volumeattachment, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return va, nil
+}
+
+func GetCSIDrivers(client kubernetes.Interface) ([]string, error) {
+	csidrivers, err := client.StorageV1().CSIDrivers().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var csidriverList []string
+	for _, csidriver := range csidrivers.Items {
+		csidriverList = append(csidriverList, csidriver.Name)
+	}
+	return csidriverList, nil
+}
+
+func GetCSIDriverYaml(client kubernetes.Interface, csidriver string) (*storagev1.CSIDriver, error) {
+	d, err := client.StorageV1().CSIDrivers().Get(context.Background(), csidriver, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func GetCSINodes(client kubernetes.Interface) ([]string, error) {
+	csinodes, err := client.StorageV1().CSINodes().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var csinodeList []string
+	for _, csinode := range csinodes.Items {
+		csinodeList = append(csinodeList, csinode.Name)
+	}
+	return csinodeList, nil
+}
+
+func GetCSINodeYaml(client kubernetes.Interface, csinode string) (*storagev1.CSINode, error) {
+	n, err := client.StorageV1().CSINodes().Get(context.Background(), csinode, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func GetCustomResourceListYaml(config *rest.Config, group string, version string, resource string) (string, error) {
+	// Set up the CRD client configuration
+	crdConfig := *config
+	crdConfig.ContentConfig.GroupVersion = &schema.GroupVersion{Group: group, Version: version}
+	crdConfig.APIPath = "/apis"
+	crdConfig.NegotiatedSerializer = scheme.Codecs.WithoutConversion()
+	crdConfig.UserAgent = rest.DefaultKubernetesUserAgent()
+
+	// Create the CRD client
+	crdClient, err := rest.RESTClientFor(&crdConfig)
+	if err != nil {
+		return "", err
+	}
+
+	// Retrieve the resources across all namespaces
+	result, err := crdClient.
+		Get().
+		AbsPath("/apis/" + group + "/" + version + "/" + resource).
+		DoRaw(context.TODO())
+	if err != nil {
+		return "", err
+	}
+
+	// Convert the list to YAML
+	yamlData, err := yaml.JSONToYAML(result)
+	if err != nil {
+		log.Println(err)
+		return "", err
+	}
+
+	return string(yamlData), nil
+}
+
+func GetNetworkPolicies(client kubernetes.Interface, namespace string) ([]string, error) {
+	networkpolicies, err := client.NetworkingV1().NetworkPolicies(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var networkpolicyList []string
+	for _, networkpolicy := range networkpolicies.Items {
+		networkpolicyList = append(networkpolicyList, networkpolicy.Name)
+	}
+	return networkpolicyList, nil
+}
+
+func GetNetworkPolicyYaml(client kubernetes.Interface, namespace string, networkpolicy string) (*networkingV1.NetworkPolicy, error) {
+	np, err := client.NetworkingV1().NetworkPolicies(namespace).Get(context.Background(), networkpolicy, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return np, nil
+}
+
+func GetEndpointSlices(client kubernetes.Interface, namespace string) ([]string, error) {
+	endpointslices, err := client.DiscoveryV1().EndpointSlices(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var endpointsliceList []string
+	for _, endpointslice := range endpointslices.Items {
+		endpointsliceList = append(endpointsliceList, endpointslice.Name)
+	}
+	return endpointsliceList, nil
+}
+
+func GetEndpointSliceYaml(client kubernetes.Interface, namespace string, endpointslice string) (*discoveryv1.EndpointSlice, error) {
+	es, err := client.DiscoveryV1().EndpointSlices(namespace).Get(context.Background(), endpointslice, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return es, nil
+}
+
+func GetIngressClasses(client kubernetes.Interface) ([]string, error) {
+	ingressclasses, err := client.NetworkingV1().IngressClasses().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var ingressclassList []string
+	for _, ingressclass := range ingressclasses.Items {
+		ingressclassList = append(ingressclassList, ingressclass.Name)
+	}
+	return ingressclassList, nil
+}
+
+func GetIngressClassYaml(client kubernetes.Interface, ingressclass string) (*networkingV1.IngressClass, error) {
+	ic, err := client.NetworkingV1().IngressClasses().Get(context.Background(), ingressclass, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return ic, nil
+}
+
+func GetSecretsByType(client kubernetes.Interface, namespace string, secretType v1.SecretType) ([]v1.Secret, error) {
+	secrets, err := client.CoreV1().Secrets(namespace).List(context.Background(), metav1.ListOptions{FieldSelector: "type=" + string(secretType)})
+	if err != nil {
+		return nil, err
+	}
+	return secrets.Items, nil
+}
+
+func GetServerVersion(client kubernetes.Interface) (*version.Info, error) {
+	return client.Discovery().ServerVersion()
+}
+
+// GetAPIServerMetrics returns the Prometheus metrics of the apiserver the
+// client is connected to.
+func GetAPIServerMetrics(client kubernetes.Interface) ([]byte, error) {
+	return client.Discovery().RESTClient().Get().AbsPath("/metrics").DoRaw(context.Background())
+}
+
+// GetHelmReleaseSecrets returns the Secrets Helm 3 stores the deployed
+// revision of every release in.
+func GetHelmReleaseSecrets(client kubernetes.Interface) ([]v1.Secret, error) {
+	secrets, err := client.CoreV1().Secrets("").List(context.Background(), metav1.ListOptions{
+		FieldSelector: "type=helm.sh/release.v1",
+		LabelSelector: "owner=helm,status=deployed",
+	})
+	if err != nil {
+		return nil, err
+	}
+	return secrets.Items, nil
+}
+
+// RancherClusterCondition is a status condition of a Rancher cluster.
+type RancherClusterCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// GetRancherClusterConditions returns the status conditions of every
+// Rancher cluster by cluster ID.
+func GetRancherClusterConditions(config *rest.Config) (map[string][]RancherClusterCondition, error) {
+	// Set up the CRD client configuration
+	crdConfig := *config
+	crdConfig.ContentConfig.GroupVersion = &schema.GroupVersion{Group: "management.cattle.io", Version: "v3"}
+	crdConfig.APIPath = "/apis"
+	crdConfig.NegotiatedSerializer = scheme.Codecs.WithoutConversion()
+	crdConfig.UserAgent = rest.DefaultKubernetesUserAgent()
+
+	// Create the CRD client
+	crdClient, err := rest.RESTClientFor(&crdConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retrieve the list of clusters
+	result, err := crdClient.
+		Get().
+		AbsPath("/apis/management.cattle.io/v3/clusters").
+		DoRaw(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Status struct {
+				Conditions []RancherClusterCondition `json:"conditions"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	err = json.Unmarshal(result, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	conditions := map[string][]RancherClusterCondition{}
+	for _, item := range response.Items {
+		conditions[item.Metadata.Name] = item.Status.Conditions
+	}
+	return conditions, nil
+}
+
+// CreateTokenReview asks the apiserver who a bearer token belongs to.
+func CreateTokenReview(client kubernetes.Interface, token string) (*authenticationv1.TokenReviewStatus, error) {
+	review, err := client.AuthenticationV1().TokenReviews().Create(context.Background(), &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &review.Status, nil
+}
+
+// CreateSubjectAccessReview asks the apiserver whether a user may perform
+// an action.
+func CreateSubjectAccessReview(client kubernetes.Interface, spec authorizationv1.SubjectAccessReviewSpec) (*authorizationv1.SubjectAccessReviewStatus, error) {
+	review, err := client.AuthorizationV1().SubjectAccessReviews().Create(context.Background(), &authorizationv1.SubjectAccessReview{
+		Spec: spec,
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &review.Status, nil
+}
+
+func GetSecret(client kubernetes.Interface, namespace string, secret string) (*v1.Secret, error) {
+	s, err := client.CoreV1().Secrets(namespace).Get(context.Background(), secret, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
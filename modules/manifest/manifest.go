@@ -0,0 +1,194 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/mattmattox/supportability-collector/modules/analyze"
+	"github.com/mattmattox/supportability-collector/modules/logging"
+)
+
+var log = logging.SetupLogging()
+
+const (
+	// FileName is the manifest at the root of every bundle.
+	FileName = "manifest.json"
+	// SignatureFileName holds the base64 signature of the manifest, as
+	// written by cosign sign-blob.
+	SignatureFileName = "manifest.json.sig"
+	// CertificateFileName holds the certificate of keyless signatures.
+	CertificateFileName = "manifest.json.pem"
+	// Version is the version of the manifest format.
+	Version = 1
+	// SchemaVersion is the version of the bundle layout documented in
+	// docs/bundle-schema.md.
+	SchemaVersion = 1
+)
+
+// Manifest lists every file of a bundle with its checksum. The manifest
+// and its signature files aren't listed. Format is the archive format the
+// bundle was written in and SchemaVersion the version of its layout.
+type Manifest struct {
+	Version       int       `json:"version"`
+	SchemaVersion int       `json:"schemaVersion,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	Profile       string    `json:"profile,omitempty"`
+	Cluster       string    `json:"cluster,omitempty"`
+	Format        string    `json:"format,omitempty"`
+	Files         []File    `json:"files"`
+}
+
+// File is a file of a bundle. Path is relative to the bundle root.
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Result is the outcome of verifying a bundle. Mismatched files have
+// another checksum than in the manifest, missing ones are in the manifest
+// only and unexpected ones are in the bundle only.
+type Result struct {
+	Manifest   *Manifest
+	Signed     bool
+	Keyless    bool
+	Identity   string
+	Verified   int
+	Mismatched []string
+	Missing    []string
+	Unexpected []string
+}
+
+// OK reports whether every file matched the manifest.
+func (r *Result) OK() bool {
+	return len(r.Mismatched) == 0 && len(r.Missing) == 0 && len(r.Unexpected) == 0
+}
+
+// New returns the manifest of files, sorted by path. Manifest files among
+// them are left out.
+func New(profile string, files []File) *Manifest {
+	manifest := &Manifest{Version: Version, SchemaVersion: SchemaVersion, CreatedAt: time.Now().UTC(), Profile: profile, Files: []File{}}
+	for _, file := range files {
+		if !isManifestFile(file.Path) {
+			manifest.Files = append(manifest.Files, file)
+		}
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool { return manifest.Files[i].Path < manifest.Files[j].Path })
+	return manifest
+}
+
+// Write writes the manifest and, when signer isn't nil, its signature with
+// create, which opens a file by its name relative to the bundle root.
+func Write(create func(name string) (io.WriteCloser, error), manifest *Manifest, signer *Signer) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	files := map[string][]byte{FileName: data}
+	if signer != nil {
+		signature, certificate, err := signer.Sign(data)
+		if err != nil {
+			return err
+		}
+		files[SignatureFileName] = signature
+		if certificate != nil {
+			files[CertificateFileName] = certificate
+		}
+	}
+	for _, name := range []string{FileName, SignatureFileName, CertificateFileName} {
+		if files[name] == nil {
+			continue
+		}
+		f, err := create(name)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(files[name])
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	log.Infof("Manifest lists %d files", len(manifest.Files))
+	return nil
+}
+
+// Verify checks a bundle against its manifest and the manifest signature.
+// With a public key the signature must be made by it; without one a
+// keyless signature is checked against its own certificate, which only
+// proves integrity.
+func Verify(path string, publicKey []byte) (*Result, error) {
+	var manifestData, signature, certificate []byte
+	files := map[string]File{}
+	err := analyze.WalkBundle(path, func(name string, size int64, r io.Reader) error {
+		var err error
+		switch name {
+		case FileName:
+			manifestData, err = io.ReadAll(r)
+		case SignatureFileName:
+			signature, err = io.ReadAll(r)
+		case CertificateFileName:
+			certificate, err = io.ReadAll(r)
+		default:
+			files[name], err = checksum(name, r)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if manifestData == nil {
+		return nil, fmt.Errorf("bundle has no %s", FileName)
+	}
+
+	result := &Result{Manifest: &Manifest{}}
+	err = json.Unmarshal(manifestData, result.Manifest)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", FileName, err)
+	}
+	if result.Manifest.Version > Version {
+		return nil, fmt.Errorf("manifest version %d is newer than the supported version %d", result.Manifest.Version, Version)
+	}
+	err = verifySignature(result, manifestData, signature, certificate, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, expected := range result.Manifest.Files {
+		actual, ok := files[expected.Path]
+		delete(files, expected.Path)
+		switch {
+		case !ok:
+			result.Missing = append(result.Missing, expected.Path)
+		case actual.SHA256 != expected.SHA256 || actual.Size != expected.Size:
+			result.Mismatched = append(result.Mismatched, expected.Path)
+		default:
+			result.Verified++
+		}
+	}
+	for name := range files {
+		result.Unexpected = append(result.Unexpected, name)
+	}
+	sort.Strings(result.Unexpected)
+	return result, nil
+}
+
+func checksum(name string, r io.Reader) (File, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, r)
+	if err != nil {
+		return File{}, err
+	}
+	return File{Path: name, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+func isManifestFile(name string) bool {
+	return name == FileName || name == SignatureFileName || name == CertificateFileName
+}
@@ -0,0 +1,210 @@
+package manifest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keySigner := &Signer{key: private}
+	keylessSigner := &Signer{keyless: true}
+	files := map[string]string{
+		"timestamp":                         "1700000000",
+		"rancher-data/rancher-data.yaml":    "version: v2.7.5\n",
+		"etcd/endpoints/etcd-1/status.json": "{}",
+	}
+	tests := []struct {
+		name       string
+		signer     *Signer
+		publicKey  ed25519.PublicKey
+		tamper     func(t *testing.T, dir string)
+		err        string
+		signed     bool
+		keyless    bool
+		mismatched []string
+		missing    []string
+		unexpected []string
+	}{
+		{
+			name: "unsigned bundle",
+		},
+		{
+			name:      "signed with the key",
+			signer:    keySigner,
+			publicKey: public,
+			signed:    true,
+		},
+		{
+			name:    "keyless signature without a key",
+			signer:  keylessSigner,
+			signed:  true,
+			keyless: true,
+		},
+		{
+			name:      "changed, removed and added files",
+			signer:    keySigner,
+			publicKey: public,
+			tamper: func(t *testing.T, dir string) {
+				write(t, dir, "timestamp", "1800000000")
+				remove(t, dir, "rancher-data/rancher-data.yaml")
+				write(t, dir, "extra.txt", "added")
+			},
+			signed:     true,
+			mismatched: []string{"timestamp"},
+			missing:    []string{"rancher-data/rancher-data.yaml"},
+			unexpected: []string{"extra.txt"},
+		},
+		{
+			name:      "signed with another key",
+			signer:    keySigner,
+			publicKey: otherPublic,
+			err:       "manifest signature is invalid",
+		},
+		{
+			name:      "rewritten manifest",
+			signer:    keySigner,
+			publicKey: public,
+			tamper: func(t *testing.T, dir string) {
+				data, err := os.ReadFile(filepath.Join(dir, FileName))
+				if err != nil {
+					t.Fatal(err)
+				}
+				write(t, dir, FileName, strings.Replace(string(data), `"profile": "full"`, `"profile": "minimal"`, 1))
+			},
+			err: "manifest signature is invalid",
+		},
+		{
+			name:   "key signature without a key",
+			signer: keySigner,
+			err:    "a public key is required",
+		},
+		{
+			name:      "unsigned bundle with a key",
+			publicKey: public,
+			err:       "bundle isn't signed",
+		},
+		{
+			name:      "keyless signature with a key",
+			signer:    keylessSigner,
+			publicKey: public,
+			err:       "manifest signature is invalid",
+		},
+		{
+			name: "no manifest",
+			tamper: func(t *testing.T, dir string) {
+				remove(t, dir, FileName)
+			},
+			err: "bundle has no manifest.json",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := bundle(t, files, test.signer)
+			if test.tamper != nil {
+				test.tamper(t, dir)
+			}
+			var publicKey []byte
+			if test.publicKey != nil {
+				der, err := x509.MarshalPKIXPublicKey(test.publicKey)
+				if err != nil {
+					t.Fatal(err)
+				}
+				publicKey = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+			}
+
+			result, err := Verify(dir, publicKey)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Signed != test.signed || result.Keyless != test.keyless {
+				t.Errorf("signed %t keyless %t, want %t %t", result.Signed, result.Keyless, test.signed, test.keyless)
+			}
+			if test.keyless && result.Identity == "" {
+				t.Error("keyless signature without an identity")
+			}
+			for _, check := range []struct {
+				name      string
+				got, want []string
+			}{
+				{"mismatched", result.Mismatched, test.mismatched},
+				{"missing", result.Missing, test.missing},
+				{"unexpected", result.Unexpected, test.unexpected},
+			} {
+				if strings.Join(check.got, ",") != strings.Join(check.want, ",") {
+					t.Errorf("%s %v, want %v", check.name, check.got, check.want)
+				}
+			}
+			if result.OK() != (len(test.mismatched)+len(test.missing)+len(test.unexpected) == 0) {
+				t.Errorf("OK is %t", result.OK())
+			}
+			if result.Verified != len(files)-len(test.mismatched)-len(test.missing) {
+				t.Errorf("%d files verified", result.Verified)
+			}
+		})
+	}
+}
+
+// bundle writes files and their manifest, signed by signer when it isn't
+// nil, to a directory bundle.
+func bundle(t *testing.T, files map[string]string, signer *Signer) string {
+	t.Helper()
+	dir := t.TempDir()
+	var listed []File
+	for name, content := range files {
+		write(t, dir, name, content)
+		file, err := checksum(name, strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		listed = append(listed, file)
+	}
+	err := Write(func(name string) (io.WriteCloser, error) {
+		return os.Create(filepath.Join(dir, name))
+	}, New("full", listed), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func write(t *testing.T, dir string, name string, content string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func remove(t *testing.T, dir string, name string) {
+	t.Helper()
+	err := os.Remove(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,209 @@
+package manifest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	"github.com/mattmattox/supportability-collector/modules/kubernetes"
+)
+
+// SecretKey is the data key of the signing key in a Kubernetes Secret.
+const SecretKey = "signing.key"
+
+// keylessValidity is how long keyless certificates are valid, matching
+// the short lived certificates of Fulcio.
+const keylessValidity = 10 * time.Minute
+
+// Signer signs manifests with an ed25519 key. Keyless signers use a key
+// generated per bundle and a self-signed certificate naming the collector,
+// standing in for a Fulcio certificate; nothing is recorded in a
+// transparency log, so keyless signatures only prove integrity.
+type Signer struct {
+	key     ed25519.PrivateKey
+	keyless bool
+}
+
+// LoadSigner returns the signer configured by settings, or nil when
+// bundles aren't signed.
+func LoadSigner(settings cli.Cli) (*Signer, error) {
+	switch {
+	case settings.SigningKeyFile != "":
+		data, err := os.ReadFile(settings.SigningKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", settings.SigningKeyFile, err)
+		}
+		return &Signer{key: key}, nil
+	case settings.SigningKeySecret != "":
+		namespace, name, ok := strings.Cut(settings.SigningKeySecret, "/")
+		if !ok {
+			return nil, fmt.Errorf("signing key secret %q must be namespace/name", settings.SigningKeySecret)
+		}
+		client, err := kubernetes.GetClient()
+		if err != nil {
+			return nil, err
+		}
+		secret, err := kubernetes.GetSecret(client, namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		data, ok := secret.Data[SecretKey]
+		if !ok {
+			return nil, fmt.Errorf("secret %s has no %s key", settings.SigningKeySecret, SecretKey)
+		}
+		key, err := ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("secret %s: %w", settings.SigningKeySecret, err)
+		}
+		return &Signer{key: key}, nil
+	case settings.SigningKeyless:
+		return &Signer{keyless: true}, nil
+	}
+	return nil, nil
+}
+
+// Sign returns the base64 signature of manifest and, for keyless
+// signatures, the PEM certificate to verify it with.
+func (s *Signer) Sign(manifest []byte) (signature []byte, certificate []byte, err error) {
+	key := s.key
+	if s.keyless {
+		key, certificate, err = keylessCertificate()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	signature = []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(key, manifest)))
+	log.Infoln("Signed bundle manifest")
+	return signature, certificate, nil
+}
+
+// ParsePrivateKey parses a PEM PKCS #8 ed25519 private key, as written by
+// openssl genpkey -algorithm ed25519.
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM private key found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ed25519Key, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an ed25519 key")
+	}
+	return ed25519Key, nil
+}
+
+// ParsePublicKey parses a PEM PKIX ed25519 public key, as written by
+// openssl pkey -pubout.
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM public key found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ed25519Key, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not an ed25519 key")
+	}
+	return ed25519Key, nil
+}
+
+func keylessCertificate() (ed25519.PrivateKey, []byte, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	hostname, _ := os.Hostname()
+	identity := &url.URL{Scheme: "supportability-collector", Host: hostname}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"supportability-collector"}, CommonName: hostname},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(keylessValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		URIs:         []*url.URL{identity},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, public, private)
+	if err != nil {
+		return nil, nil, err
+	}
+	return private, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+func verifySignature(result *Result, manifest []byte, signature []byte, certificate []byte, publicKey []byte) error {
+	if signature == nil {
+		if publicKey != nil {
+			return errors.New("bundle isn't signed")
+		}
+		return nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(signature)))
+	if err != nil {
+		return fmt.Errorf("%s: %w", SignatureFileName, err)
+	}
+
+	var key ed25519.PublicKey
+	if publicKey != nil {
+		key, err = ParsePublicKey(publicKey)
+		if err != nil {
+			return err
+		}
+	} else {
+		if certificate == nil {
+			return errors.New("bundle is signed with a key, a public key is required to verify it")
+		}
+		block, _ := pem.Decode(certificate)
+		if block == nil {
+			return fmt.Errorf("%s: no PEM certificate found", CertificateFileName)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("%s: %w", CertificateFileName, err)
+		}
+		var ok bool
+		key, ok = cert.PublicKey.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("%s: certificate key is not an ed25519 key", CertificateFileName)
+		}
+		if result.Manifest.CreatedAt.Before(cert.NotBefore) || result.Manifest.CreatedAt.After(cert.NotAfter) {
+			return fmt.Errorf("%s: manifest was created outside of the certificate validity", CertificateFileName)
+		}
+		result.Keyless = true
+		result.Identity = cert.Subject.CommonName
+		if len(cert.URIs) > 0 {
+			result.Identity = cert.URIs[0].String()
+		}
+	}
+	if !ed25519.Verify(key, manifest, decoded) {
+		return errors.New("manifest signature is invalid")
+	}
+	result.Signed = true
+	return nil
+}
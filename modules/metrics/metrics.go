@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	CollectionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "supportability_collections_total",
+		Help: "Collections started, by profile.",
+	}, []string{"profile"})
+
+	CollectionsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "supportability_collections_failed_total",
+		Help: "Collections that failed or were aborted, by profile.",
+	}, []string{"profile"})
+
+	CollectorDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "supportability_collector_duration_seconds",
+		Help:    "Time taken by each collector.",
+		Buckets: prometheus.ExponentialBuckets(0.25, 2, 14),
+	}, []string{"collector"})
+
+	ObjectsCollected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "supportability_objects_collected_total",
+		Help: "Kubernetes objects written to bundles, by kind.",
+	}, []string{"kind"})
+
+	APIErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "supportability_api_errors_total",
+		Help: "Kubernetes API requests that failed, by HTTP status code or \"error\" when no response was received.",
+	}, []string{"code"})
+
+	BundleSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "supportability_bundle_size_bytes",
+		Help: "Size of the last bundle collected.",
+	})
+
+	UploadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "supportability_upload_duration_seconds",
+		Help:    "Time taken to upload bundles.",
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 12),
+	})
+
+	UploadFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "supportability_upload_failures_total",
+		Help: "Bundle uploads that failed.",
+	})
+
+	LastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "supportability_last_success_timestamp_seconds",
+		Help: "Unix time of the last successful collection.",
+	})
+)
+
+// RecordCollection records the outcome of a collection of profile that
+// produced tarFile, once its bundle was uploaded or failed to. tarFile is
+// a directory for directory bundles.
+func RecordCollection(profile string, tarFile string, err error) {
+	if err != nil {
+		CollectionsFailed.WithLabelValues(profile).Inc()
+		return
+	}
+	if size, sizeErr := bundleSize(tarFile); sizeErr == nil {
+		BundleSize.Set(float64(size))
+	}
+	LastSuccess.SetToCurrentTime()
+}
+
+// bundleSize returns the size of a bundle archive, or of the files of a
+// directory bundle.
+func bundleSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if !info.IsDir() {
+		return info.Size(), nil
+	}
+	var size int64
+	err = filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// Transport wraps a Kubernetes API transport to count failed requests.
+func Transport(rt http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+		response, err := rt.RoundTrip(request)
+		if err != nil {
+			APIErrors.WithLabelValues("error").Inc()
+			return response, err
+		}
+		if response.StatusCode >= http.StatusBadRequest {
+			APIErrors.WithLabelValues(strconv.Itoa(response.StatusCode)).Inc()
+		}
+		return response, err
+	})
+}
+
+type roundTripperFunc func(request *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecordCollection(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	err := os.WriteFile(archive, make([]byte, 1500), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for name, size := range map[string]int{"timestamp": 10, "rancher-data/rancher-data.yaml": 300, "nodes/server-1/system/df.txt": 700} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		profile string
+		tarFile string
+		err     error
+		failed  float64
+		size    float64
+	}{
+		{name: "archive", profile: "minimal", tarFile: archive, size: 1500},
+		{name: "directory", profile: "minimal", tarFile: dir, size: 1010},
+		{name: "failed", profile: "full", tarFile: archive, err: errors.New("upload failed"), failed: 1, size: 1010},
+		{name: "missing bundle", profile: "minimal", tarFile: filepath.Join(dir, "missing.tar.gz"), size: 1010},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			failed := testutil.ToFloat64(CollectionsFailed.WithLabelValues(test.profile))
+			LastSuccess.Set(0)
+
+			RecordCollection(test.profile, test.tarFile, test.err)
+
+			if got := testutil.ToFloat64(CollectionsFailed.WithLabelValues(test.profile)) - failed; got != test.failed {
+				t.Errorf("failed collections grew by %v, want %v", got, test.failed)
+			}
+			if got := testutil.ToFloat64(BundleSize); got != test.size {
+				t.Errorf("bundle size %v, want %v", got, test.size)
+			}
+			if succeeded := testutil.ToFloat64(LastSuccess) != 0; succeeded != (test.err == nil) {
+				t.Errorf("last success recorded %t for error %v", succeeded, test.err)
+			}
+		})
+	}
+}
+
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: Transport(http.DefaultTransport)}
+
+	tests := []struct {
+		url  string
+		code string
+	}{
+		{url: server.URL + "/ok"},
+		{url: server.URL + "/forbidden", code: "403"},
+		{url: server.URL + "/missing", code: "404"},
+		{url: "http://127.0.0.1:1/unreachable", code: "error"},
+	}
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			before := map[string]float64{}
+			for _, code := range []string{"403", "404", "error"} {
+				before[code] = testutil.ToFloat64(APIErrors.WithLabelValues(code))
+			}
+			response, err := client.Get(test.url)
+			if err == nil {
+				response.Body.Close()
+			}
+			for code, count := range before {
+				want := count
+				if code == test.code {
+					want++
+				}
+				if got := testutil.ToFloat64(APIErrors.WithLabelValues(code)); got != want {
+					t.Errorf("%s API errors %v, want %v", code, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package report
+
+import (
+	_ "embed"
+	"encoding/json"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattmattox/supportability-collector/modules/analyze"
+	"github.com/mattmattox/supportability-collector/modules/logging"
+)
+
+var log = logging.SetupLogging()
+
+//go:embed report.html.tmpl
+var reportTemplate string
+
+// rancherNamespacePrefixes select the namespaces shown in the workload
+// health table.
+var rancherNamespacePrefixes = []string{"cattle-", "fleet-", "rancher-"}
+
+type RancherInfo struct {
+	Timestamp time.Time `json:"Timestamp"`
+	Version   string    `json:"Version"`
+	UUID      string    `json:"UUID"`
+	ServerUrl string    `json:"ServerUrl"`
+	EulaDate  string    `json:"EulaDate"`
+}
+
+type Cluster struct {
+	Name        string
+	DisplayName string
+	Provider    string
+	Version     string
+	Ready       string
+	Connected   string
+	File        string
+}
+
+type Node struct {
+	Name             string
+	Roles            string
+	Ready            string
+	KubeletVersion   string
+	OSImage          string
+	ContainerRuntime string
+	InternalIP       string
+	File             string
+}
+
+type Workload struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Ready     int64
+	Desired   int64
+	File      string
+}
+
+func (w Workload) Healthy() bool {
+	return w.Ready >= w.Desired
+}
+
+type Certificate struct {
+	Namespace string    `json:"namespace"`
+	Secret    string    `json:"secret"`
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	DNSNames  []string  `json:"dnsNames"`
+	IsCA      bool      `json:"isCA"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+}
+
+func (c Certificate) DaysLeft() int {
+	return int(time.Until(c.NotAfter).Hours() / 24)
+}
+
+// Data is everything the report template renders.
+type Data struct {
+	GeneratedAt      time.Time
+	Rancher          *RancherInfo
+	Clusters         []Cluster
+	Nodes            []Node
+	Workloads        []Workload
+	Analysis         *analyze.Report
+	Certificates     []Certificate
+	CollectionErrors []string
+}
+
+// Build extracts the report data from a loaded bundle and its analysis.
+func Build(bundle *analyze.Bundle, analysis *analyze.Report) *Data {
+	data := &Data{
+		GeneratedAt: time.Now().UTC(),
+		Analysis:    analysis,
+	}
+	if raw, ok := bundle.Files["rancher-data/rancher-data.json"]; ok {
+		var rancher RancherInfo
+		if json.Unmarshal(raw, &rancher) == nil {
+			data.Rancher = &rancher
+		}
+	}
+	if raw, ok := bundle.Files["certificates/certificates.json"]; ok {
+		json.Unmarshal(raw, &data.Certificates)
+		sort.Slice(data.Certificates, func(i, j int) bool {
+			return data.Certificates[i].NotAfter.Before(data.Certificates[j].NotAfter)
+		})
+	}
+	if raw, ok := bundle.Files["collection-errors.txt"]; ok {
+		for _, line := range strings.Split(strings.TrimSpace(string(raw)), "\n") {
+			if line != "" {
+				data.CollectionErrors = append(data.CollectionErrors, line)
+			}
+		}
+	}
+
+	for _, object := range bundle.Objects {
+		switch {
+		case object.Kind() == "Cluster" && strings.HasPrefix(object.APIVersion(), "management.cattle.io/"):
+			data.Clusters = append(data.Clusters, Cluster{
+				Name:        object.Name(),
+				DisplayName: object.Field("spec.displayName"),
+				Provider:    object.Field("status.provider"),
+				Version:     object.Field("status.version.gitVersion"),
+				Ready:       object.Field("status.conditions[type=Ready].status"),
+				Connected:   object.Field("status.conditions[type=Connected].status"),
+				File:        object.Path,
+			})
+		case object.Kind() == "Node" && object.APIVersion() == "v1":
+			data.Nodes = append(data.Nodes, Node{
+				Name:             object.Name(),
+				Roles:            nodeRoles(object),
+				Ready:            object.Field("status.conditions[type=Ready].status"),
+				KubeletVersion:   object.Field("status.nodeInfo.kubeletVersion"),
+				OSImage:          object.Field("status.nodeInfo.osImage"),
+				ContainerRuntime: object.Field("status.nodeInfo.containerRuntimeVersion"),
+				InternalIP:       object.Field("status.addresses[type=InternalIP].address"),
+				File:             object.Path,
+			})
+		case isWorkload(object.Kind()) && isRancherNamespace(object.Namespace()):
+			data.Workloads = append(data.Workloads, workload(object))
+		}
+	}
+	sort.Slice(data.Clusters, func(i, j int) bool { return data.Clusters[i].Name < data.Clusters[j].Name })
+	sort.Slice(data.Nodes, func(i, j int) bool { return data.Nodes[i].Name < data.Nodes[j].Name })
+	sort.Slice(data.Workloads, func(i, j int) bool {
+		a, b := data.Workloads[i], data.Workloads[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Kind+a.Name < b.Kind+b.Name
+	})
+	return data
+}
+
+// Render writes the self-contained HTML report.
+func Render(w io.Writer, data *Data) error {
+	tmpl, err := template.New("report").Funcs(template.FuncMap{
+		"join": strings.Join,
+		"date": func(t time.Time) string { return t.Format("2006-01-02 15:04") },
+	}).Parse(reportTemplate)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
+
+func number(object analyze.Object, path string) int64 {
+	values := analyze.Values(object.Data, path)
+	if len(values) == 0 {
+		return 0
+	}
+	if f, ok := values[0].(float64); ok {
+		return int64(f)
+	}
+	return 0
+}
+
+func nodeRoles(object analyze.Object) string {
+	var roles []string
+	for label := range object.Labels() {
+		if strings.HasPrefix(label, "node-role.kubernetes.io/") {
+			roles = append(roles, strings.TrimPrefix(label, "node-role.kubernetes.io/"))
+		}
+	}
+	sort.Strings(roles)
+	return strings.Join(roles, ",")
+}
+
+func isWorkload(kind string) bool {
+	return kind == "Deployment" || kind == "DaemonSet" || kind == "StatefulSet"
+}
+
+func isRancherNamespace(namespace string) bool {
+	for _, prefix := range rancherNamespacePrefixes {
+		if strings.HasPrefix(namespace, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func workload(object analyze.Object) Workload {
+	w := Workload{
+		Kind:      object.Kind(),
+		Namespace: object.Namespace(),
+		Name:      object.Name(),
+		File:      object.Path,
+	}
+	if w.Kind == "DaemonSet" {
+		w.Ready = number(object, "status.numberReady")
+		w.Desired = number(object, "status.desiredNumberScheduled")
+	} else {
+		w.Ready = number(object, "status.readyReplicas")
+		w.Desired = number(object, "spec.replicas")
+	}
+	return w
+}
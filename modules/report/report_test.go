@@ -0,0 +1,245 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattmattox/supportability-collector/modules/analyze"
+)
+
+const (
+	clusters = `apiVersion: management.cattle.io/v3
+kind: Cluster
+metadata:
+  name: local
+spec:
+  displayName: local
+status:
+  provider: rke2
+  version:
+    gitVersion: v1.26.8+rke2r1
+  conditions:
+    - type: Ready
+      status: "True"
+    - type: Connected
+      status: "True"
+---
+apiVersion: management.cattle.io/v3
+kind: Cluster
+metadata:
+  name: c-abcde
+spec:
+  displayName: <prod>
+status:
+  conditions:
+    - type: Ready
+      status: "False"
+    - type: Connected
+      status: "False"
+`
+	nodes = `items:
+  - metadata:
+      name: node-2
+      labels:
+        node-role.kubernetes.io/worker: "true"
+    status:
+      conditions:
+        - type: Ready
+          status: "False"
+  - metadata:
+      name: node-1
+      labels:
+        node-role.kubernetes.io/etcd: "true"
+        node-role.kubernetes.io/control-plane: "true"
+    status:
+      addresses:
+        - type: Hostname
+          address: node-1
+        - type: InternalIP
+          address: 10.0.0.1
+      conditions:
+        - type: Ready
+          status: "True"
+      nodeInfo:
+        kubeletVersion: v1.26.8+rke2r1
+        osImage: SLES 15 SP5
+        containerRuntimeVersion: containerd://1.7.3
+`
+	rancherDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: rancher
+  namespace: cattle-system
+spec:
+  replicas: 3
+status:
+  readyReplicas: 2
+`
+	fleetDaemonSet = `apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: fleet-agent
+  namespace: cattle-fleet-system
+status:
+  desiredNumberScheduled: 2
+  numberReady: 2
+`
+	appDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: default
+spec:
+  replicas: 1
+`
+	rancherData = `{"Timestamp":"2024-01-02T03:04:05Z","Version":"v2.7.5","UUID":"uuid-1","ServerUrl":"https://rancher.example.com","EulaDate":""}`
+)
+
+// bundleOf builds a bundle from file names and contents.
+func bundleOf(files map[string]string) *analyze.Bundle {
+	bundle := analyze.NewBundle()
+	for name, content := range files {
+		bundle.AddFile(name, []byte(content))
+	}
+	return bundle
+}
+
+func TestBuild(t *testing.T) {
+	now := time.Now().UTC()
+	certificates, err := json.Marshal([]Certificate{
+		{Namespace: "cattle-system", Secret: "tls-rancher", NotAfter: now.Add(90 * 24 * time.Hour)},
+		{Namespace: "cattle-system", Secret: "tls-expired", NotAfter: now.Add(-24 * time.Hour)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := bundleOf(map[string]string{
+		"rancher-data/rancher-data.json":            rancherData,
+		"certificates/certificates.json":            string(certificates),
+		"collection-errors.txt":                     "etcd: port-forward failed\n\nprobes: timed out\n",
+		"rancher-resources/clusters/clusters.yaml":  clusters,
+		"nodes/nodes.yaml":                          nodes,
+		"deployments/cattle-system/rancher.yaml":    rancherDeployment,
+		"daemonsets/cattle-fleet-system/fleet.yaml": fleetDaemonSet,
+		"deployments/default/web.yaml":              appDeployment,
+	})
+	data := Build(bundle, nil)
+
+	if data.Rancher == nil || data.Rancher.Version != "v2.7.5" || data.Rancher.ServerUrl != "https://rancher.example.com" {
+		t.Errorf("Rancher %+v", data.Rancher)
+	}
+	wantClusters := []Cluster{
+		{Name: "c-abcde", DisplayName: "<prod>", Ready: "False", Connected: "False", File: "rancher-resources/clusters/clusters.yaml"},
+		{Name: "local", DisplayName: "local", Provider: "rke2", Version: "v1.26.8+rke2r1", Ready: "True", Connected: "True", File: "rancher-resources/clusters/clusters.yaml"},
+	}
+	if !reflect.DeepEqual(data.Clusters, wantClusters) {
+		t.Errorf("clusters %+v, want %+v", data.Clusters, wantClusters)
+	}
+	wantNodes := []Node{
+		{Name: "node-1", Roles: "control-plane,etcd", Ready: "True", KubeletVersion: "v1.26.8+rke2r1", OSImage: "SLES 15 SP5", ContainerRuntime: "containerd://1.7.3", InternalIP: "10.0.0.1", File: "nodes/nodes.yaml"},
+		{Name: "node-2", Roles: "worker", Ready: "False", File: "nodes/nodes.yaml"},
+	}
+	if !reflect.DeepEqual(data.Nodes, wantNodes) {
+		t.Errorf("nodes %+v, want %+v", data.Nodes, wantNodes)
+	}
+	wantWorkloads := []Workload{
+		{Kind: "DaemonSet", Namespace: "cattle-fleet-system", Name: "fleet-agent", Ready: 2, Desired: 2, File: "daemonsets/cattle-fleet-system/fleet.yaml"},
+		{Kind: "Deployment", Namespace: "cattle-system", Name: "rancher", Ready: 2, Desired: 3, File: "deployments/cattle-system/rancher.yaml"},
+	}
+	if !reflect.DeepEqual(data.Workloads, wantWorkloads) {
+		t.Errorf("workloads %+v, want %+v", data.Workloads, wantWorkloads)
+	}
+	if len(data.Certificates) != 2 || data.Certificates[0].Secret != "tls-expired" {
+		t.Errorf("certificates %+v, want the expired one first", data.Certificates)
+	}
+	wantErrors := []string{"etcd: port-forward failed", "probes: timed out"}
+	if !reflect.DeepEqual(data.CollectionErrors, wantErrors) {
+		t.Errorf("collection errors %q, want %q", data.CollectionErrors, wantErrors)
+	}
+}
+
+func TestRender(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name    string
+		data    *Data
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "empty bundle",
+			data: &Data{GeneratedAt: now},
+			want: []string{
+				"Rancher data was not collected.",
+				"No clusters found.",
+				"No nodes found.",
+				"No workloads found in Rancher namespaces.",
+				"The analyzer did not run.",
+				"No certificates found.",
+				"No errors during collection.",
+			},
+		},
+		{
+			name: "collected bundle",
+			data: &Data{
+				GeneratedAt: now,
+				Rancher:     &RancherInfo{Version: "v2.7.5", ServerUrl: "https://rancher.example.com"},
+				Clusters:    []Cluster{{Name: "c-abcde", DisplayName: "<prod>", Ready: "False", Connected: "True", File: "rancher-resources/clusters/c-abcde.yaml"}},
+				Nodes:       []Node{{Name: "node-1", Ready: "True", File: "nodes/node-1.yaml"}},
+				Workloads:   []Workload{{Kind: "Deployment", Namespace: "cattle-system", Name: "rancher", Ready: 2, Desired: 3, File: "deployments/cattle-system/rancher.yaml"}},
+				Analysis: &analyze.Report{
+					Rules:    12,
+					Objects:  40,
+					Counts:   map[string]int{"error": 1},
+					Findings: []analyze.Finding{{RuleID: "cluster-not-ready", Severity: "error", Kind: "Cluster", Name: "c-abcde", File: "rancher-resources/clusters/c-abcde.yaml", Message: "Cluster c-abcde is not ready"}},
+				},
+				Certificates:     []Certificate{{Namespace: "cattle-system", Secret: "tls-rancher", DNSNames: []string{"rancher.example.com", "rancher"}, NotAfter: now.Add(10 * 24 * time.Hour)}},
+				CollectionErrors: []string{"etcd: port-forward failed"},
+			},
+			want: []string{
+				"<td>v2.7.5</td>",
+				`<td><a href="rancher-resources/clusters/c-abcde.yaml">c-abcde</a></td>`,
+				"<td>&lt;prod&gt;</td>",
+				`<td class="bad">False</td>`,
+				`<td class="ok">True</td>`,
+				`<td class="bad">2/3</td>`,
+				"40 object(s) checked with 12 rule(s): 1 error(s), 0 warning(s), 0 info.",
+				`<td class="error">error</td>`,
+				"Cluster c-abcde is not ready",
+				"rancher.example.com, rancher",
+				`<td class="warning">`,
+				"etcd: port-forward failed",
+			},
+			notWant: []string{"<prod>", "No problems found.", "No errors during collection."},
+		},
+		{
+			name: "analysis without findings",
+			data: &Data{GeneratedAt: now, Analysis: &analyze.Report{Counts: map[string]int{}}},
+			want: []string{"No problems found."},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Render(&out, test.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			html := out.String()
+			for _, want := range test.want {
+				if !strings.Contains(html, want) {
+					t.Errorf("report is missing %q", want)
+				}
+			}
+			for _, notWant := range test.notWant {
+				if strings.Contains(html, notWant) {
+					t.Errorf("report contains %q", notWant)
+				}
+			}
+		})
+	}
+}
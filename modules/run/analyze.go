@@ -0,0 +1,68 @@
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/mattmattox/supportability-collector/modules/analyze"
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	"github.com/mattmattox/supportability-collector/modules/collect"
+	"github.com/mattmattox/supportability-collector/modules/manifest"
+)
+
+// Analyze re-runs the analyzer and the redaction check over an existing
+// bundle without a cluster.
+func Analyze(options cli.AnalyzeOptions) {
+	log.Infof("Analyzing bundle %s", options.Bundle)
+	var unredacted []analyze.Finding
+	bundle, err := analyze.ReadBundle(options.Bundle, func(name string, data []byte) {
+		if collect.NeedsRedaction(data) {
+			unredacted = append(unredacted, analyze.Finding{
+				RuleID:   "unredacted-secret",
+				Severity: "warning",
+				Title:    "File contains values the redaction rules would mask",
+				Kind:     "File",
+				Name:     name,
+				File:     name,
+				Message:  "File " + name + " contains values the redaction rules would mask",
+			})
+		}
+	})
+	if err != nil {
+		log.Fatalf("Reading bundle failed - Error %s", err)
+	}
+
+	report, err := analyze.Analyze(bundle, analyze.Options{
+		RulesDir:          options.RulesDir,
+		SupportMatrixFile: options.SupportMatrixFile,
+		UpgradeTarget:     options.UpgradeTarget,
+	})
+	if err != nil {
+		log.Fatalf("Loading analyzer rules or support matrix failed - Error %s", err)
+	}
+	report.Add(unredacted...)
+
+	err = os.MkdirAll(options.OutputDir, 0755)
+	if err != nil {
+		log.Fatalf("Output directory creation failed - Error %s", err)
+	}
+	err = analyze.WriteReport(options.OutputDir, report)
+	if err != nil {
+		log.Fatalf("Writing analysis failed - Error %s", err)
+	}
+
+	printBundleInfo(bundle)
+	analyze.WriteSummary(os.Stdout, report)
+}
+
+func printBundleInfo(bundle *analyze.Bundle) {
+	var bundleManifest manifest.Manifest
+	if data, ok := bundle.Files[manifest.FileName]; ok && json.Unmarshal(data, &bundleManifest) == nil && bundleManifest.Format != "" {
+		fmt.Printf("Bundle format %s, profile %s, %d file(s)\n", bundleManifest.Format, bundleManifest.Profile, len(bundleManifest.Files))
+	}
+	var rancherInfo collect.RancherInfo
+	if data, ok := bundle.Files["rancher-data/rancher-data.json"]; ok && json.Unmarshal(data, &rancherInfo) == nil {
+		fmt.Printf("Rancher %s at %s (install %s), collected %s\n\n", rancherInfo.Version, rancherInfo.ServerUrl, rancherInfo.UUID, rancherInfo.Timestamp.Format("2006-01-02 15:04:05"))
+	}
+}
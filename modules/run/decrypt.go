@@ -0,0 +1,47 @@
+package run
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	"github.com/mattmattox/supportability-collector/modules/collect"
+)
+
+// Decrypt writes the plaintext of an encrypted bundle.
+func Decrypt(options cli.DecryptOptions) {
+	log.Infof("Decrypting bundle %s", options.Bundle)
+	var passphrase []byte
+	if options.PassphraseFile != "" {
+		data, err := os.ReadFile(options.PassphraseFile)
+		if err != nil {
+			log.Fatalf("Reading passphrase file failed - Error %s", err)
+		}
+		passphrase = []byte(strings.TrimRight(string(data), "\r\n"))
+	}
+
+	in, err := os.Open(options.Bundle)
+	if err != nil {
+		log.Fatalf("Opening bundle %s failed - Error %s", options.Bundle, err)
+	}
+	defer in.Close()
+	plaintext, err := collect.Decrypt(in, options.IdentityFile, passphrase)
+	if err != nil {
+		log.Fatalf("Decrypting bundle %s failed - Error %s", options.Bundle, err)
+	}
+
+	out, err := os.OpenFile(options.Output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("Creating %s failed - Error %s", options.Output, err)
+	}
+	_, err = io.Copy(out, plaintext)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(options.Output)
+		log.Fatalf("Decrypting bundle %s failed - Error %s", options.Bundle, err)
+	}
+	log.Infof("Decrypted bundle written to %s", options.Output)
+}
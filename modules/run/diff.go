@@ -0,0 +1,35 @@
+package run
+
+import (
+	"os"
+
+	"github.com/mattmattox/supportability-collector/modules/analyze"
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	"github.com/mattmattox/supportability-collector/modules/diff"
+)
+
+// Diff compares two bundles and prints what changed between them.
+func Diff(options cli.DiffOptions) {
+	log.Infof("Comparing bundle %s with %s", options.Old, options.New)
+	old := readBundle(options.Old)
+	new := readBundle(options.New)
+
+	result := diff.Compare(old, new)
+	var err error
+	if options.Format == "json" {
+		err = diff.WriteJSON(os.Stdout, result)
+	} else {
+		err = diff.WriteText(os.Stdout, result)
+	}
+	if err != nil {
+		log.Fatalf("Writing diff failed - Error %s", err)
+	}
+}
+
+func readBundle(path string) *analyze.Bundle {
+	bundle, err := analyze.ReadBundle(path, nil)
+	if err != nil {
+		log.Fatalf("Reading bundle %s failed - Error %s", path, err)
+	}
+	return bundle
+}
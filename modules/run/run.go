@@ -8,7 +8,20 @@ import (
 
 var log = logging.SetupLogging()
 
-func Run(cli.Cli) {
+func Run(settings cli.Cli) {
 	log.Infoln("Starting Rancher Supportability Collector")
-	collect.CollectData()
+	loadRedactionRules(settings)
+	collect.CollectData(settings)
+}
+
+// loadRedactionRules adds the rules of REDACTION_RULES_FILE before any
+// collection starts.
+func loadRedactionRules(settings cli.Cli) {
+	if settings.RedactionRulesFile == "" {
+		return
+	}
+	err := collect.LoadRedactionRules(settings.RedactionRulesFile)
+	if err != nil {
+		log.Fatalf("Redaction rules loading failed - Error %s", err)
+	}
 }
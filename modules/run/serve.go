@@ -0,0 +1,97 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/mattmattox/supportability-collector/modules/auth"
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	"github.com/mattmattox/supportability-collector/modules/collect"
+	"github.com/mattmattox/supportability-collector/modules/controller"
+	"github.com/mattmattox/supportability-collector/modules/health"
+	"github.com/mattmattox/supportability-collector/modules/jobs"
+	"github.com/mattmattox/supportability-collector/modules/kubernetes"
+	"github.com/mattmattox/supportability-collector/modules/schedule"
+	"github.com/mattmattox/supportability-collector/modules/server"
+	"github.com/mattmattox/supportability-collector/modules/trigger"
+	"k8s.io/client-go/rest"
+)
+
+// Serve keeps the health server running with the collection API added to
+// it, so collections can be started on demand instead of once at start-up.
+func Serve(settings cli.Cli) {
+	log.Infoln("Starting Rancher Supportability Collector in server mode")
+	if settings.ArchiveFormat == collect.FormatDir {
+		log.Fatal("ARCHIVE_FORMAT dir can't be served, use tar.gz, tar.zst or zip")
+	}
+	err := auth.CheckServe(settings)
+	if err != nil {
+		log.Fatalf("Refusing to serve - Error %s", err)
+	}
+	if settings.InsecureServe {
+		log.Warningf("INSECURE_SERVE is set, the collection API on %s may be served without TLS or authentication", settings.ListenAddress)
+	}
+	loadRedactionRules(settings)
+	manager, err := jobs.NewManager(settings)
+	if err != nil {
+		log.Fatalf("Bundle directory creation failed - Error %s", err)
+	}
+	log.Infof("Storing bundles in %s, running at most %d collection(s) at a time", manager.Dir, manager.Concurrency)
+	routes := []func(router *mux.Router){server.Routes(manager)}
+	var watcher *trigger.Watcher
+	if settings.TriggersFile != "" {
+		triggers, err := trigger.LoadTriggers(settings.TriggersFile)
+		if err != nil {
+			log.Fatalf("Triggers loading failed - Error %s", err)
+		}
+		watcher, err = trigger.New(manager, settings, triggers)
+		if err != nil {
+			log.Fatalf("Triggers loading failed - Error %s", err)
+		}
+		routes = append(routes, server.TriggerRoutes(watcher))
+	}
+	var config *rest.Config
+	if settings.Controller || (watcher != nil && watcher.Polls()) {
+		config, err = kubernetes.GetConfig()
+		if err != nil {
+			log.Fatalf("Failed to connect to upstream cluster - Error %s", err)
+		}
+	}
+	if settings.Controller {
+		err = controller.Start(context.Background(), config, manager, settings)
+		if err != nil {
+			log.Fatalf("SupportBundle controller start failed - Error %s", err)
+		}
+	}
+	if watcher != nil {
+		err = watcher.Start(config)
+		if err != nil {
+			log.Fatalf("Trigger watcher start failed - Error %s", err)
+		}
+	}
+	if settings.SchedulesFile != "" {
+		schedules, err := schedule.LoadSchedules(settings.SchedulesFile)
+		if err != nil {
+			log.Fatalf("Schedules loading failed - Error %s", err)
+		}
+		scheduler, err := schedule.New(manager, settings, schedules)
+		if err != nil {
+			log.Fatalf("Schedules loading failed - Error %s", err)
+		}
+		scheduler.Start()
+		routes = append(routes, server.ScheduleRoutes(scheduler))
+	}
+	health.AddReadinessCheck("bundle-storage", health.WritableCheck(manager.Dir))
+	health.AddLivenessCheck("collections", func() error {
+		stuck := manager.Stuck(settings.StuckJobTimeout)
+		if len(stuck) > 0 {
+			return fmt.Errorf("collection %s made no progress since %s", stuck[0].ID, stuck[0].UpdatedAt.Format(time.RFC3339))
+		}
+		return nil
+	})
+	health.StartHealthServer(settings, routes...)
+	select {}
+}
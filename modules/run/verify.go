@@ -0,0 +1,52 @@
+package run
+
+import (
+	"os"
+
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	"github.com/mattmattox/supportability-collector/modules/manifest"
+)
+
+// Verify checks the manifest signature of a bundle and that every file
+// matches its manifest checksum, exiting with an error when not.
+func Verify(options cli.VerifyOptions) {
+	log.Infof("Verifying bundle %s", options.Bundle)
+	var publicKey []byte
+	if options.PublicKeyFile != "" {
+		var err error
+		publicKey, err = os.ReadFile(options.PublicKeyFile)
+		if err != nil {
+			log.Fatalf("Reading public key failed - Error %s", err)
+		}
+	}
+
+	result, err := manifest.Verify(options.Bundle, publicKey)
+	if err != nil {
+		log.Fatalf("Verifying bundle %s failed - Error %s", options.Bundle, err)
+	}
+
+	switch {
+	case publicKey == nil:
+		log.Warningln("No -key given: this checks integrity only, not authenticity. Anyone who can change the bundle can rewrite its manifest and signature to match")
+		if result.Keyless {
+			log.Warningf("Bundle has a keyless signature claiming to be by %s, which isn't backed by a transparency log", result.Identity)
+		} else if !result.Signed {
+			log.Warningln("Bundle is not signed, only the file checksums were verified")
+		}
+	default:
+		log.Infoln("Manifest signature is valid")
+	}
+	for _, name := range result.Mismatched {
+		log.Errorf("Checksum mismatch: %s", name)
+	}
+	for _, name := range result.Missing {
+		log.Errorf("Missing from bundle: %s", name)
+	}
+	for _, name := range result.Unexpected {
+		log.Errorf("Not in manifest: %s", name)
+	}
+	if !result.OK() {
+		log.Fatalf("Bundle %s failed verification", options.Bundle)
+	}
+	log.Infof("Bundle %s verified: %d files match the manifest", options.Bundle, result.Verified)
+}
@@ -0,0 +1,318 @@
+package schedule
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	"github.com/mattmattox/supportability-collector/modules/collect"
+	"github.com/mattmattox/supportability-collector/modules/jobs"
+	"github.com/mattmattox/supportability-collector/modules/logging"
+	"github.com/robfig/cron/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+var log = logging.SetupLogging()
+
+// validName keeps schedule names usable in bundle file names.
+var validName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// Schedule runs a profile periodically. Cron is a standard five field cron
+// expression or a descriptor such as @daily, optionally prefixed with
+// CRON_TZ=<zone>. Keep and MaxAge bound the bundles kept for the schedule
+// in the bundle directory and in a file:// upload destination; when both
+// are set a bundle is kept as long as it satisfies either.
+type Schedule struct {
+	Name     string          `json:"name"`
+	Cron     string          `json:"cron"`
+	Profile  string          `json:"profile,omitempty"`
+	Clusters []string        `json:"clusters,omitempty"`
+	Upload   string          `json:"upload,omitempty"`
+	Keep     int             `json:"keep,omitempty"`
+	MaxAge   metav1.Duration `json:"maxAge,omitempty"`
+}
+
+// State is the status of a schedule. The upload destination is left out
+// as presigned URLs carry credentials.
+type State struct {
+	Name       string     `json:"name"`
+	Cron       string     `json:"cron"`
+	Profile    string     `json:"profile"`
+	Clusters   []string   `json:"clusters,omitempty"`
+	Keep       int        `json:"keep,omitempty"`
+	MaxAge     string     `json:"maxAge,omitempty"`
+	Running    bool       `json:"running"`
+	Runs       int        `json:"runs"`
+	Failures   int        `json:"failures"`
+	NextRun    *time.Time `json:"nextRun,omitempty"`
+	LastRun    *time.Time `json:"lastRun,omitempty"`
+	LastJob    string     `json:"lastJob,omitempty"`
+	LastStatus string     `json:"lastStatus,omitempty"`
+	LastError  string     `json:"lastError,omitempty"`
+	LastBundle string     `json:"lastBundle,omitempty"`
+	Pruned     int        `json:"pruned"`
+}
+
+// Scheduler starts the collections of its schedules through a job manager
+// and prunes their bundles after every successful run.
+type Scheduler struct {
+	manager *jobs.Manager
+	cluster string
+	cron    *cron.Cron
+	mu      sync.Mutex
+	entries map[string]cron.EntryID
+	states  map[string]*State
+	order   []string
+}
+
+// LoadSchedules reads a YAML list of schedules.
+func LoadSchedules(path string) ([]Schedule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var schedules []Schedule
+	err = yaml.Unmarshal(data, &schedules)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return schedules, nil
+}
+
+// New validates schedules and registers them. Nothing runs before Start.
+func New(manager *jobs.Manager, settings cli.Cli, schedules []Schedule) (*Scheduler, error) {
+	s := &Scheduler{
+		manager: manager,
+		cluster: settings.ClusterName,
+		cron:    cron.New(cron.WithChain(cron.SkipIfStillRunning(cron.PrintfLogger(log)))),
+		entries: map[string]cron.EntryID{},
+		states:  map[string]*State{},
+	}
+	for _, schedule := range schedules {
+		if !validName.MatchString(schedule.Name) {
+			return nil, fmt.Errorf("schedule %q: name must consist of lower case letters, digits and dashes", schedule.Name)
+		}
+		if _, ok := s.states[schedule.Name]; ok {
+			return nil, fmt.Errorf("schedule %s: defined twice", schedule.Name)
+		}
+		if schedule.Profile == "" {
+			schedule.Profile = collect.DefaultProfile
+		}
+		if _, err := collect.ProfileCollectors(settings, schedule.Profile); err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", schedule.Name, err)
+		}
+		if schedule.Keep < 0 || schedule.MaxAge.Duration < 0 {
+			return nil, fmt.Errorf("schedule %s: keep and maxAge can't be negative", schedule.Name)
+		}
+		dir, err := uploadDir(schedule.Upload)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", schedule.Name, err)
+		}
+		if dir == "" && schedule.Upload != "" && (schedule.Keep > 0 || schedule.MaxAge.Duration > 0) {
+			log.Warningf("Schedule %s uploads over HTTP, retention only applies to the bundle directory", schedule.Name)
+		}
+		schedule := schedule
+		id, err := s.cron.AddFunc(schedule.Cron, func() { s.run(schedule) })
+		if err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", schedule.Name, err)
+		}
+		state := &State{
+			Name:     schedule.Name,
+			Cron:     schedule.Cron,
+			Profile:  schedule.Profile,
+			Clusters: schedule.Clusters,
+			Keep:     schedule.Keep,
+		}
+		if schedule.MaxAge.Duration > 0 {
+			state.MaxAge = schedule.MaxAge.Duration.String()
+		}
+		s.entries[schedule.Name] = id
+		s.states[schedule.Name] = state
+		s.order = append(s.order, schedule.Name)
+	}
+	return s, nil
+}
+
+// Start runs the schedules in the background.
+func (s *Scheduler) Start() {
+	for _, name := range s.order {
+		state := s.states[name]
+		log.Infof("Scheduled collection %s with profile %s at %q", name, state.Profile, state.Cron)
+	}
+	s.cron.Start()
+}
+
+// Stop stops scheduling new collections; running ones carry on.
+func (s *Scheduler) Stop() {
+	s.cron.Stop()
+}
+
+// States returns the state of every schedule in the order they were
+// defined.
+func (s *Scheduler) States() []State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	states := make([]State, 0, len(s.order))
+	for _, name := range s.order {
+		state := *s.states[name]
+		if next := s.cron.Entry(s.entries[name]).Next; !next.IsZero() {
+			next = next.UTC()
+			state.NextRun = &next
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+func (s *Scheduler) run(schedule Schedule) {
+	log.Infof("Running scheduled collection %s", schedule.Name)
+	now := time.Now().UTC()
+	s.update(schedule.Name, func(state *State) {
+		state.Running = true
+		state.Runs++
+		state.LastRun = &now
+		state.LastJob, state.LastStatus, state.LastError, state.LastBundle = "", "", "", ""
+	})
+	defer s.update(schedule.Name, func(state *State) { state.Running = false })
+
+	job, err := s.manager.Start(jobs.Request{
+		Profile:  schedule.Profile,
+		Clusters: schedule.Clusters,
+		Upload:   schedule.Upload,
+		Schedule: schedule.Name,
+	})
+	if err == nil {
+		s.update(schedule.Name, func(state *State) { state.LastJob = job.ID })
+		job, err = s.manager.Wait(job.ID)
+	}
+	if err == nil && job.Status == jobs.StatusFailed {
+		err = fmt.Errorf("%s", job.Error)
+	}
+	if err != nil {
+		log.Warningf("Scheduled collection %s failed - Error %s", schedule.Name, err)
+		s.update(schedule.Name, func(state *State) {
+			state.Failures++
+			state.LastStatus = jobs.StatusFailed
+			state.LastError = err.Error()
+		})
+		return
+	}
+	s.update(schedule.Name, func(state *State) {
+		state.LastStatus = job.Status
+		state.LastBundle = job.Bundle
+	})
+
+	pruned := s.prune(schedule, time.Now())
+	s.update(schedule.Name, func(state *State) { state.Pruned += pruned })
+}
+
+// prune applies the retention of a schedule to the bundle directory and
+// the upload directory. Bundles only uploaded over HTTP can't be listed
+// and are left alone.
+func (s *Scheduler) prune(schedule Schedule, now time.Time) int {
+	if schedule.Keep == 0 && schedule.MaxAge.Duration == 0 {
+		return 0
+	}
+	dirs := []string{s.manager.Dir}
+	if dir, _ := uploadDir(schedule.Upload); dir != "" && filepath.Clean(dir) != filepath.Clean(s.manager.Dir) {
+		dirs = append(dirs, dir)
+	}
+	pruned := 0
+	for _, dir := range dirs {
+		removed, err := Prune(dir, collect.BundlePrefix(s.cluster), jobs.ScheduleSuffix(schedule.Name), schedule.Keep, schedule.MaxAge.Duration, now)
+		if err != nil {
+			log.Warningf("Pruning bundles of schedule %s in %s failed - Error %s", schedule.Name, dir, err)
+		}
+		for _, path := range removed {
+			log.Infof("Pruned bundle %s of schedule %s", path, schedule.Name)
+		}
+		pruned += len(removed)
+	}
+	return pruned
+}
+
+func (s *Scheduler) update(name string, change func(state *State)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change(s.states[name])
+}
+
+// Prune removes the bundles in dir named prefix, a collect.BundleTimeFormat
+// time, suffix and an extension that are both beyond the newest keep ones
+// and older than maxAge, and returns their paths. A zero keep or maxAge
+// disables that limit, a bundle is only kept by the other one then.
+// Matching the suffix up to the extension keeps the bundles of a schedule
+// named like suffix followed by a dash out, e.g. daily-prod ones when
+// pruning daily.
+func Prune(dir string, prefix string, suffix string, keep int, maxAge time.Duration, now time.Time) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, prefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+	type bundle struct {
+		path    string
+		modTime time.Time
+	}
+	var bundles []bundle
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || !collect.IsBundle(path) || !bundleOf(filepath.Base(path), prefix, suffix) {
+			continue
+		}
+		bundles = append(bundles, bundle{path: path, modTime: info.ModTime()})
+	}
+	sort.Slice(bundles, func(i, j int) bool { return bundles[i].modTime.After(bundles[j].modTime) })
+
+	var removed []string
+	for i, bundle := range bundles {
+		kept := keep > 0 && i < keep
+		recent := maxAge > 0 && now.Sub(bundle.modTime) <= maxAge
+		if kept || recent || (keep == 0 && maxAge == 0) {
+			continue
+		}
+		err := os.Remove(bundle.path)
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, bundle.path)
+	}
+	return removed, nil
+}
+
+// bundleOf reports whether name is prefix followed by a bundle time,
+// suffix and the extensions of the bundle.
+func bundleOf(name string, prefix string, suffix string) bool {
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	rest := name[len(prefix):]
+	if len(rest) < len(collect.BundleTimeFormat) {
+		return false
+	}
+	_, err := time.Parse(collect.BundleTimeFormat, rest[:len(collect.BundleTimeFormat)])
+	return err == nil && strings.HasPrefix(rest[len(collect.BundleTimeFormat):], suffix+".")
+}
+
+// uploadDir returns the directory of a file:// upload destination, or an
+// empty string for other destinations.
+func uploadDir(destination string) (string, error) {
+	if destination == "" {
+		return "", nil
+	}
+	target, err := url.Parse(destination)
+	if err != nil {
+		return "", err
+	}
+	if target.Scheme != "file" {
+		return "", nil
+	}
+	return target.Path, nil
+}
@@ -0,0 +1,120 @@
+package schedule
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/mattmattox/supportability-collector/modules/collect"
+	"github.com/mattmattox/supportability-collector/modules/jobs"
+)
+
+func TestPrune(t *testing.T) {
+	now := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	bundle := func(schedule string, day int, extension string) string {
+		return collect.BundleRoot("local", time.Date(2024, 1, day, 0, 0, 0, 0, time.UTC)) + jobs.ScheduleSuffix(schedule) + extension
+	}
+	daily := func(day int, extension string) string {
+		return bundle("daily", day, extension)
+	}
+	tests := []struct {
+		name    string
+		keep    int
+		maxAge  time.Duration
+		files   map[string]int
+		removed []string
+	}{
+		{
+			name:    "keeps the newest bundles",
+			keep:    2,
+			files:   map[string]int{daily(7, ".tar.gz"): 3, daily(8, ".zip"): 2, daily(9, ".tar.zst.age"): 1},
+			removed: []string{daily(7, ".tar.gz")},
+		},
+		{
+			name:    "removes bundles older than maxAge",
+			maxAge:  48 * time.Hour,
+			files:   map[string]int{daily(6, ".tar.gz"): 4, daily(8, ".tar.gz"): 2, daily(9, ".tar.gz"): 1},
+			removed: []string{daily(6, ".tar.gz")},
+		},
+		{
+			name:    "bundles satisfying either limit are kept",
+			keep:    1,
+			maxAge:  60 * time.Hour,
+			files:   map[string]int{daily(6, ".tar.gz"): 4, daily(7, ".tar.gz"): 3, daily(8, ".tar.gz"): 2, daily(9, ".tar.gz"): 1},
+			removed: []string{daily(6, ".tar.gz"), daily(7, ".tar.gz")},
+		},
+		{
+			name:    "old bundles are kept up to keep",
+			keep:    3,
+			maxAge:  24 * time.Hour,
+			files:   map[string]int{daily(6, ".tar.gz"): 4, daily(7, ".tar.gz"): 3, daily(8, ".tar.gz"): 2, daily(9, ".tar.gz"): 1},
+			removed: []string{daily(6, ".tar.gz")},
+		},
+		{
+			name: "leaves schedules sharing the prefix alone",
+			keep: 1,
+			files: map[string]int{
+				daily(8, ".tar.gz"):                2,
+				daily(9, ".tar.gz"):                1,
+				bundle("daily-prod", 1, ".tar.gz"): 9,
+			},
+			removed: []string{daily(8, ".tar.gz")},
+		},
+		{
+			name: "leaves other files alone",
+			keep: 1,
+			files: map[string]int{
+				daily(9, ".tar.gz"): 1,
+				daily(8, ".txt"):    2,
+				collect.BundlePrefix("local") + "x-daily.zip":                                             3,
+				collect.BundleRoot("local", now.AddDate(0, 0, -5)) + ".tar.gz":                            4,
+				bundle("daily", 2, "") + "-old.tar.gz":                                                    5,
+				collect.BundleRoot("prod", now.AddDate(0, 0, -6)) + jobs.ScheduleSuffix("daily") + ".zip": 6,
+			},
+		},
+		{
+			name:  "zero limits keep everything",
+			files: map[string]int{daily(1, ".tar.gz"): 9, daily(2, ".tar.gz"): 8},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, daysOld := range test.files {
+				path := filepath.Join(dir, name)
+				err := os.WriteFile(path, nil, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				modTime := now.Add(-time.Duration(daysOld) * 24 * time.Hour)
+				err = os.Chtimes(path, modTime, modTime)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			removed, err := Prune(dir, collect.BundlePrefix("local"), jobs.ScheduleSuffix("daily"), test.keep, test.maxAge, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, path := range removed {
+				got = append(got, filepath.Base(path))
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("%s was reported removed but still exists", path)
+				}
+			}
+			sort.Strings(got)
+			sort.Strings(test.removed)
+			if len(got) != len(test.removed) {
+				t.Fatalf("removed %v, want %v", got, test.removed)
+			}
+			for i := range got {
+				if got[i] != test.removed[i] {
+					t.Fatalf("removed %v, want %v", got, test.removed)
+				}
+			}
+		})
+	}
+}
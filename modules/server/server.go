@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mattmattox/supportability-collector/modules/collect"
+	"github.com/mattmattox/supportability-collector/modules/jobs"
+	"github.com/mattmattox/supportability-collector/modules/logging"
+	"github.com/mattmattox/supportability-collector/modules/schedule"
+	"github.com/mattmattox/supportability-collector/modules/trigger"
+)
+
+var log = logging.SetupLogging()
+
+// maxRequestSize bounds the body of a collection request.
+const maxRequestSize = 64 * 1024
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Routes returns a function adding the collection API to a router.
+func Routes(manager *jobs.Manager) func(router *mux.Router) {
+	return func(router *mux.Router) {
+		api := router.PathPrefix("/api/v1").Subrouter()
+		api.HandleFunc("/profiles", ProfilesHandler).Methods(http.MethodGet)
+		api.HandleFunc("/collections", StartCollectionHandler(manager)).Methods(http.MethodPost)
+		api.HandleFunc("/collections", ListCollectionsHandler(manager)).Methods(http.MethodGet)
+		api.HandleFunc("/collections/{id}", GetCollectionHandler(manager)).Methods(http.MethodGet)
+		api.HandleFunc("/bundles", ListBundlesHandler(manager)).Methods(http.MethodGet)
+		api.HandleFunc("/bundles/{name}", DownloadBundleHandler(manager)).Methods(http.MethodGet)
+		api.HandleFunc("/bundles/{name}", DeleteBundleHandler(manager)).Methods(http.MethodDelete)
+	}
+}
+
+// ScheduleRoutes returns a function adding the schedule state to a router.
+func ScheduleRoutes(scheduler *schedule.Scheduler) func(router *mux.Router) {
+	return func(router *mux.Router) {
+		router.HandleFunc("/api/v1/schedules", SchedulesHandler(scheduler)).Methods(http.MethodGet)
+	}
+}
+
+func SchedulesHandler(scheduler *schedule.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, scheduler.States())
+	}
+}
+
+// TriggerRoutes returns a function adding the trigger state and the
+// Alertmanager webhook to a router.
+func TriggerRoutes(watcher *trigger.Watcher) func(router *mux.Router) {
+	return func(router *mux.Router) {
+		router.HandleFunc("/api/v1/triggers", TriggersHandler(watcher)).Methods(http.MethodGet)
+		router.HandleFunc("/api/v1/alerts", AlertsHandler(watcher)).Methods(http.MethodPost)
+	}
+}
+
+func TriggersHandler(watcher *trigger.Watcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, watcher.States())
+	}
+}
+
+func AlertsHandler(watcher *trigger.Watcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var notification trigger.Notification
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&notification)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		started := watcher.Alert(notification)
+		if started == nil {
+			started = []jobs.Job{}
+		}
+		writeJSON(w, http.StatusOK, started)
+	}
+}
+
+func ProfilesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, collect.Profiles)
+}
+
+func StartCollectionHandler(manager *jobs.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request jobs.Request
+		if r.ContentLength != 0 {
+			err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&request)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		if profile := r.URL.Query().Get("profile"); profile != "" {
+			request.Profile = profile
+		}
+		err := manager.CheckUpload(request.Upload)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		job, err := manager.Start(request)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		w.Header().Set("Location", "/api/v1/collections/"+job.ID)
+		writeJSON(w, http.StatusAccepted, job)
+	}
+}
+
+func ListCollectionsHandler(manager *jobs.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, manager.List())
+	}
+}
+
+func GetCollectionHandler(manager *jobs.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, err := manager.Get(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	}
+}
+
+func ListBundlesHandler(manager *jobs.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bundles, err := manager.Bundles()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, bundles)
+	}
+}
+
+func DownloadBundleHandler(manager *jobs.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		path, err := manager.BundlePath(name)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		w.Header().Set("Content-Type", collect.ContentType(name))
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		http.ServeFile(w, r, path)
+	}
+}
+
+func DeleteBundleHandler(manager *jobs.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := manager.DeleteBundle(mux.Vars(r)["name"])
+		if errors.Is(err, jobs.ErrNotFound) {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		log.Warningf("Writing response failed - Error %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
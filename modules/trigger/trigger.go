@@ -0,0 +1,412 @@
+package trigger
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	"github.com/mattmattox/supportability-collector/modules/collect"
+	"github.com/mattmattox/supportability-collector/modules/jobs"
+	"github.com/mattmattox/supportability-collector/modules/kubernetes"
+	"github.com/mattmattox/supportability-collector/modules/logging"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+)
+
+var log = logging.SetupLogging()
+
+const (
+	TypePodCrashLoop        = "pod-crashloop"
+	TypeNodeNotReady        = "node-notready"
+	TypeClusterDisconnected = "cluster-disconnected"
+	TypeAlert               = "alert"
+)
+
+// DefaultCooldown is the time a trigger waits after firing when it
+// doesn't set its own cooldown.
+const DefaultCooldown = time.Hour
+
+// DefaultNamespace is the namespace watched for crash looping pods.
+const DefaultNamespace = "cattle-system"
+
+// Trigger starts a collection when its condition is met. Namespace applies
+// to pod-crashloop triggers and Labels to alert triggers, which fire for
+// every firing alert carrying all of the labels and require at least one
+// so they don't fire for every alert. After firing, a trigger stays quiet
+// for Cooldown.
+type Trigger struct {
+	Name      string            `json:"name"`
+	Type      string            `json:"type"`
+	Profile   string            `json:"profile,omitempty"`
+	Clusters  []string          `json:"clusters,omitempty"`
+	Upload    string            `json:"upload,omitempty"`
+	Cooldown  metav1.Duration   `json:"cooldown,omitempty"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// State is the status of a trigger. The upload destination is left out as
+// presigned URLs carry credentials.
+type State struct {
+	Name          string     `json:"name"`
+	Type          string     `json:"type"`
+	Profile       string     `json:"profile"`
+	Cooldown      string     `json:"cooldown"`
+	Fired         int        `json:"fired"`
+	Suppressed    int        `json:"suppressed"`
+	LastFired     *time.Time `json:"lastFired,omitempty"`
+	LastReason    string     `json:"lastReason,omitempty"`
+	LastJob       string     `json:"lastJob,omitempty"`
+	CooldownUntil *time.Time `json:"cooldownUntil,omitempty"`
+}
+
+// Alert is an alert of an Alertmanager webhook notification.
+type Alert struct {
+	Status      string            `json:"status"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Notification is the body of an Alertmanager webhook.
+type Notification struct {
+	Status string  `json:"status"`
+	Alerts []Alert `json:"alerts"`
+}
+
+// Starter starts collections and looks them up, as jobs.Manager does.
+type Starter interface {
+	Start(request jobs.Request) (jobs.Job, error)
+	Get(id string) (jobs.Job, error)
+}
+
+// Watcher polls the cluster for the conditions of its triggers and starts
+// collections through a job manager. Objects already unhealthy when the
+// watcher starts are taken as the baseline; only objects becoming
+// unhealthy afterwards fire.
+type Watcher struct {
+	Interval time.Duration
+
+	manager   Starter
+	triggers  []Trigger
+	mu        sync.Mutex
+	states    map[string]*State
+	unhealthy map[string]map[string]bool
+}
+
+// LoadTriggers reads a YAML list of triggers.
+func LoadTriggers(path string) ([]Trigger, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var triggers []Trigger
+	err = yaml.Unmarshal(data, &triggers)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return triggers, nil
+}
+
+// New validates triggers. Nothing is polled before Start.
+func New(manager Starter, settings cli.Cli, triggers []Trigger) (*Watcher, error) {
+	w := &Watcher{
+		Interval:  settings.TriggerInterval,
+		manager:   manager,
+		states:    map[string]*State{},
+		unhealthy: map[string]map[string]bool{},
+	}
+	for _, trigger := range triggers {
+		if trigger.Name == "" {
+			return nil, fmt.Errorf("trigger of type %q: name is required", trigger.Type)
+		}
+		if _, ok := w.states[trigger.Name]; ok {
+			return nil, fmt.Errorf("trigger %s: defined twice", trigger.Name)
+		}
+		switch trigger.Type {
+		case TypePodCrashLoop, TypeNodeNotReady, TypeClusterDisconnected, TypeAlert:
+		default:
+			return nil, fmt.Errorf("trigger %s: unknown type %q", trigger.Name, trigger.Type)
+		}
+		if trigger.Profile == "" {
+			trigger.Profile = collect.DefaultProfile
+		}
+		if _, err := collect.ProfileCollectors(settings, trigger.Profile); err != nil {
+			return nil, fmt.Errorf("trigger %s: %w", trigger.Name, err)
+		}
+		if trigger.Cooldown.Duration == 0 {
+			trigger.Cooldown.Duration = DefaultCooldown
+		}
+		if trigger.Type == TypeAlert && len(trigger.Labels) == 0 {
+			return nil, fmt.Errorf("trigger %s: alert triggers need labels to match", trigger.Name)
+		}
+		if trigger.Type == TypePodCrashLoop && trigger.Namespace == "" {
+			trigger.Namespace = DefaultNamespace
+		}
+		w.triggers = append(w.triggers, trigger)
+		w.states[trigger.Name] = &State{
+			Name:     trigger.Name,
+			Type:     trigger.Type,
+			Profile:  trigger.Profile,
+			Cooldown: trigger.Cooldown.Duration.String(),
+		}
+	}
+	return w, nil
+}
+
+// Polls reports whether any trigger needs the cluster to be polled, as
+// alert triggers only react to webhooks.
+func (w *Watcher) Polls() bool {
+	for _, trigger := range w.triggers {
+		if trigger.Type != TypeAlert {
+			return true
+		}
+	}
+	return false
+}
+
+// Start polls the cluster in the background every Interval.
+func (w *Watcher) Start(config *rest.Config) error {
+	for _, trigger := range w.triggers {
+		log.Infof("Watching trigger %s of type %s with profile %s", trigger.Name, trigger.Type, trigger.Profile)
+	}
+	if !w.Polls() {
+		return nil
+	}
+	client, err := k8s.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	clusterConditions := func() (map[string][]kubernetes.RancherClusterCondition, error) {
+		return kubernetes.GetRancherClusterConditions(config)
+	}
+	go func() {
+		for {
+			w.poll(client, clusterConditions)
+			time.Sleep(w.Interval)
+		}
+	}()
+	return nil
+}
+
+// States returns the state of every trigger in the order they were
+// defined.
+func (w *Watcher) States() []State {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	states := make([]State, 0, len(w.triggers))
+	for _, trigger := range w.triggers {
+		states = append(states, *w.states[trigger.Name])
+	}
+	return states
+}
+
+// Alert fires the alert triggers matching the firing alerts of an
+// Alertmanager notification and returns the jobs started.
+func (w *Watcher) Alert(notification Notification) []jobs.Job {
+	var started []jobs.Job
+	for _, trigger := range w.triggers {
+		if trigger.Type != TypeAlert {
+			continue
+		}
+		var alerts []string
+		for _, alert := range notification.Alerts {
+			if alert.Status == "firing" && labelsMatch(alert.Labels, trigger.Labels) {
+				alerts = append(alerts, alert.Labels["alertname"])
+			}
+		}
+		if len(alerts) == 0 {
+			continue
+		}
+		job, ok := w.fire(trigger, "alert "+strings.Join(alerts, ", ")+" firing")
+		if ok {
+			started = append(started, job)
+		}
+	}
+	return started
+}
+
+// poll runs the checks of the triggers once, reading Rancher clusters
+// through clusterConditions.
+func (w *Watcher) poll(client k8s.Interface, clusterConditions func() (map[string][]kubernetes.RancherClusterCondition, error)) {
+	checks := map[string]func() ([]string, error){
+		TypeNodeNotReady: func() ([]string, error) { return notReadyNodes(client) },
+		TypeClusterDisconnected: func() ([]string, error) {
+			clusters, err := clusterConditions()
+			return disconnectedClusters(clusters), err
+		},
+	}
+	for _, trigger := range w.triggers {
+		if trigger.Type == TypePodCrashLoop {
+			namespace := trigger.Namespace
+			checks[TypePodCrashLoop+"/"+namespace] = func() ([]string, error) { return crashLoopingPods(client, namespace) }
+		}
+	}
+	for key, check := range checks {
+		if !w.watches(key) {
+			continue
+		}
+		unhealthy, err := check()
+		if err != nil {
+			log.Warningf("Trigger check %s failed - Error %s", key, err)
+			continue
+		}
+		previous, polled := w.unhealthy[key]
+		current := map[string]bool{}
+		var entered []string
+		for _, name := range unhealthy {
+			current[name] = true
+			if polled && !previous[name] {
+				entered = append(entered, name)
+			}
+		}
+		w.unhealthy[key] = current
+		if len(entered) == 0 {
+			continue
+		}
+		sort.Strings(entered)
+		for _, trigger := range w.triggers {
+			if checkKey(trigger) == key {
+				w.fire(trigger, describe(trigger.Type, entered))
+			}
+		}
+	}
+}
+
+// watches reports whether a trigger uses the check with key.
+func (w *Watcher) watches(key string) bool {
+	for _, trigger := range w.triggers {
+		if checkKey(trigger) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// fire starts the collection of a trigger unless it is cooling down or
+// its previous collection is still running.
+func (w *Watcher) fire(trigger Trigger, reason string) (jobs.Job, bool) {
+	w.mu.Lock()
+	state := w.states[trigger.Name]
+	now := time.Now().UTC()
+	if state.CooldownUntil != nil && now.Before(*state.CooldownUntil) {
+		state.Suppressed++
+		w.mu.Unlock()
+		log.Infof("Trigger %s suppressed until %s: %s", trigger.Name, state.CooldownUntil.Format(time.RFC3339), reason)
+		return jobs.Job{}, false
+	}
+	if job, err := w.manager.Get(state.LastJob); err == nil && (job.Status == jobs.StatusQueued || job.Status == jobs.StatusRunning) {
+		state.Suppressed++
+		w.mu.Unlock()
+		log.Infof("Trigger %s suppressed while collection %s runs: %s", trigger.Name, job.ID, reason)
+		return jobs.Job{}, false
+	}
+	// Cool down before starting so concurrent webhooks fire only once
+	previous := state.CooldownUntil
+	until := now.Add(trigger.Cooldown.Duration)
+	state.CooldownUntil = &until
+	w.mu.Unlock()
+
+	log.Infof("Trigger %s fired: %s", trigger.Name, reason)
+	job, err := w.manager.Start(jobs.Request{
+		Profile:  trigger.Profile,
+		Clusters: trigger.Clusters,
+		Upload:   trigger.Upload,
+		Trigger:  trigger.Name,
+	})
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err != nil {
+		log.Warningf("Trigger %s collection failed to start - Error %s", trigger.Name, err)
+		state.CooldownUntil = previous
+		return jobs.Job{}, false
+	}
+	state.Fired++
+	state.LastFired = &now
+	state.LastReason = reason
+	state.LastJob = job.ID
+	return job, true
+}
+
+func checkKey(trigger Trigger) string {
+	if trigger.Type == TypePodCrashLoop {
+		return TypePodCrashLoop + "/" + trigger.Namespace
+	}
+	return trigger.Type
+}
+
+func describe(triggerType string, names []string) string {
+	switch triggerType {
+	case TypePodCrashLoop:
+		return "pods in CrashLoopBackOff: " + strings.Join(names, ", ")
+	case TypeNodeNotReady:
+		return "nodes NotReady: " + strings.Join(names, ", ")
+	case TypeClusterDisconnected:
+		return "clusters disconnected: " + strings.Join(names, ", ")
+	}
+	return strings.Join(names, ", ")
+}
+
+func crashLoopingPods(client k8s.Interface, namespace string) ([]string, error) {
+	pods, err := kubernetes.GetPodsBySelector(client, namespace, "")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, pod := range pods {
+		statuses := append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			if status.State.Waiting != nil && status.State.Waiting.Reason == "CrashLoopBackOff" {
+				names = append(names, pod.Namespace+"/"+pod.Name)
+				break
+			}
+		}
+	}
+	return names, nil
+}
+
+func notReadyNodes(client k8s.Interface) ([]string, error) {
+	nodes, err := kubernetes.GetNodesBySelector(client, "")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, node := range nodes {
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == v1.NodeReady && condition.Status != v1.ConditionTrue {
+				names = append(names, node.Name)
+			}
+		}
+	}
+	return names, nil
+}
+
+// disconnectedClusters returns the clusters whose Connected condition
+// isn't True, which includes Unknown once Rancher lost track of them.
+func disconnectedClusters(clusters map[string][]kubernetes.RancherClusterCondition) []string {
+	var names []string
+	for cluster, conditions := range clusters {
+		for _, condition := range conditions {
+			if condition.Type == "Connected" && condition.Status != "True" {
+				names = append(names, cluster)
+			}
+		}
+	}
+	return names
+}
+
+func labelsMatch(labels map[string]string, selector map[string]string) bool {
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
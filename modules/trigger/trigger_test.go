@@ -0,0 +1,428 @@
+package trigger
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattmattox/supportability-collector/modules/cli"
+	"github.com/mattmattox/supportability-collector/modules/jobs"
+	"github.com/mattmattox/supportability-collector/modules/kubernetes"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeStarter queues the collections it is asked to start without running
+// them, failing to start them while err is set.
+type fakeStarter struct {
+	mu       sync.Mutex
+	err      error
+	jobs     map[string]jobs.Job
+	requests []jobs.Request
+}
+
+func (s *fakeStarter) Start(request jobs.Request) (jobs.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return jobs.Job{}, s.err
+	}
+	if s.jobs == nil {
+		s.jobs = map[string]jobs.Job{}
+	}
+	job := jobs.Job{ID: fmt.Sprintf("job-%d", len(s.requests)+1), Profile: request.Profile, Status: jobs.StatusQueued, Trigger: request.Trigger}
+	s.jobs[job.ID] = job
+	s.requests = append(s.requests, request)
+	return job, nil
+}
+
+func (s *fakeStarter) Get(id string) (jobs.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return jobs.Job{}, errors.New("job not found")
+	}
+	return job, nil
+}
+
+// finish marks a collection as done.
+func (s *fakeStarter) finish(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := s.jobs[id]
+	job.Status = jobs.StatusSucceeded
+	s.jobs[id] = job
+}
+
+func (s *fakeStarter) started() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.requests)
+}
+
+// watch returns a watcher of triggers starting collections through a
+// fake starter.
+func watch(t *testing.T, triggers ...Trigger) (*Watcher, *fakeStarter) {
+	t.Helper()
+	starter := &fakeStarter{}
+	w, err := New(starter, cli.Cli{}, triggers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w, starter
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		trigger Trigger
+		err     string
+	}{
+		{name: "pod trigger defaults", trigger: Trigger{Name: "pods", Type: TypePodCrashLoop}},
+		{name: "alert trigger with labels", trigger: Trigger{Name: "alerts", Type: TypeAlert, Labels: map[string]string{"severity": "critical"}}},
+		{name: "alert trigger without labels", trigger: Trigger{Name: "alerts", Type: TypeAlert}, err: "need labels"},
+		{name: "unknown type", trigger: Trigger{Name: "disk", Type: "disk-full"}, err: "unknown type"},
+		{name: "unnamed", trigger: Trigger{Type: TypeNodeNotReady}, err: "name is required"},
+		{name: "unknown profile", trigger: Trigger{Name: "nodes", Type: TypeNodeNotReady, Profile: "everything"}, err: "everything"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w, err := New(&fakeStarter{}, cli.Cli{}, []Trigger{test.trigger})
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			trigger := w.triggers[0]
+			if trigger.Profile == "" || trigger.Cooldown.Duration != DefaultCooldown {
+				t.Errorf("defaults not applied: %+v", trigger)
+			}
+			if trigger.Type == TypePodCrashLoop && trigger.Namespace != DefaultNamespace {
+				t.Errorf("watching namespace %q", trigger.Namespace)
+			}
+		})
+	}
+	_, err := New(&fakeStarter{}, cli.Cli{}, []Trigger{{Name: "nodes", Type: TypeNodeNotReady}, {Name: "nodes", Type: TypeNodeNotReady}})
+	if err == nil {
+		t.Error("triggers defined twice were accepted")
+	}
+}
+
+func TestFire(t *testing.T) {
+	at := func(offset time.Duration) *time.Time {
+		until := time.Now().UTC().Add(offset)
+		return &until
+	}
+	tests := []struct {
+		name       string
+		state      func(starter *fakeStarter) State
+		startErr   error
+		fired      bool
+		suppressed int
+		cooldown   time.Duration
+	}{
+		{
+			name:     "fires when idle",
+			state:    func(*fakeStarter) State { return State{} },
+			fired:    true,
+			cooldown: time.Hour,
+		},
+		{
+			name:       "suppressed while cooling down",
+			state:      func(*fakeStarter) State { return State{CooldownUntil: at(time.Minute)} },
+			suppressed: 1,
+			cooldown:   time.Minute,
+		},
+		{
+			name:     "fires once the cooldown passed",
+			state:    func(*fakeStarter) State { return State{CooldownUntil: at(-time.Second), LastJob: "forgotten"} },
+			fired:    true,
+			cooldown: time.Hour,
+		},
+		{
+			name: "suppressed while the previous collection is queued",
+			state: func(starter *fakeStarter) State {
+				job, _ := starter.Start(jobs.Request{Profile: "minimal"})
+				return State{CooldownUntil: at(-time.Second), LastJob: job.ID}
+			},
+			suppressed: 1,
+			cooldown:   -time.Second,
+		},
+		{
+			name: "fires once the previous collection finished",
+			state: func(starter *fakeStarter) State {
+				job, _ := starter.Start(jobs.Request{Profile: "minimal"})
+				starter.finish(job.ID)
+				return State{CooldownUntil: at(-time.Second), LastJob: job.ID}
+			},
+			fired:    true,
+			cooldown: time.Hour,
+		},
+		{
+			name:     "failed starts keep the previous cooldown",
+			state:    func(*fakeStarter) State { return State{CooldownUntil: at(-time.Second)} },
+			startErr: errors.New("collector is shutting down"),
+			cooldown: -time.Second,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w, starter := watch(t, Trigger{Name: "alerts", Type: TypeAlert, Profile: "minimal", Cooldown: metav1.Duration{Duration: time.Hour}, Labels: map[string]string{"severity": "critical"}})
+			*w.states["alerts"] = test.state(starter)
+			starter.err = test.startErr
+			before := starter.started()
+
+			job, fired := w.fire(w.triggers[0], "test")
+			if fired != test.fired {
+				t.Fatalf("fired %t, want %t", fired, test.fired)
+			}
+			state := w.States()[0]
+			if test.fired {
+				if state.Fired != 1 || state.LastJob != job.ID || state.LastReason != "test" || starter.started() != before+1 {
+					t.Errorf("state %+v after firing job %s", state, job.ID)
+				}
+			} else if state.Fired != 0 || starter.started() != before {
+				t.Errorf("state %+v, a collection started without firing", state)
+			}
+			if state.Suppressed != test.suppressed {
+				t.Errorf("suppressed %d, want %d", state.Suppressed, test.suppressed)
+			}
+			until := time.Now().UTC().Add(test.cooldown)
+			if state.CooldownUntil == nil || state.CooldownUntil.Sub(until).Abs() > 5*time.Second {
+				t.Errorf("cooling down until %v, want about %v", state.CooldownUntil, until)
+			}
+		})
+	}
+}
+
+func TestFireConcurrently(t *testing.T) {
+	w, starter := watch(t, Trigger{Name: "alerts", Type: TypeAlert, Labels: map[string]string{"severity": "critical"}})
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	fired := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := w.fire(w.triggers[0], "webhook"); ok {
+				mu.Lock()
+				fired++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	state := w.States()[0]
+	if fired != 1 || state.Fired != 1 || state.Suppressed != 19 || starter.started() != 1 {
+		t.Errorf("%d fired, state %+v, %d collections", fired, state, starter.started())
+	}
+}
+
+func pod(namespace string, name string, reason string, init bool) *v1.Pod {
+	status := v1.ContainerStatus{Name: "main", State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}}
+	if reason != "" {
+		status.State = v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: reason}}
+	}
+	p := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	if init {
+		p.Status.InitContainerStatuses = []v1.ContainerStatus{status}
+	} else {
+		p.Status.ContainerStatuses = []v1.ContainerStatus{status}
+	}
+	return p
+}
+
+func node(name string, ready v1.ConditionStatus) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: ready}}},
+	}
+}
+
+func connected(status string) []kubernetes.RancherClusterCondition {
+	return []kubernetes.RancherClusterCondition{{Type: "Ready", Status: "True"}, {Type: "Connected", Status: status}}
+}
+
+// TestPoll polls twice: what is unhealthy on the first poll is the
+// baseline, what turns unhealthy on the second fires.
+func TestPoll(t *testing.T) {
+	tests := []struct {
+		name          string
+		trigger       Trigger
+		first, second []runtime.Object
+		clusters      [2]map[string][]kubernetes.RancherClusterCondition
+		reason        string
+	}{
+		{
+			name:    "pod starts crash looping",
+			trigger: Trigger{Type: TypePodCrashLoop},
+			first:   []runtime.Object{pod("cattle-system", "rancher-1", "", false), pod("cattle-system", "rancher-2", "", false)},
+			second:  []runtime.Object{pod("cattle-system", "rancher-1", "", false), pod("cattle-system", "rancher-2", "CrashLoopBackOff", false)},
+			reason:  "pods in CrashLoopBackOff: cattle-system/rancher-2",
+		},
+		{
+			name:    "init container starts crash looping",
+			trigger: Trigger{Type: TypePodCrashLoop, Namespace: "cattle-fleet-system"},
+			first:   []runtime.Object{pod("cattle-fleet-system", "fleet-agent", "PodInitializing", true)},
+			second:  []runtime.Object{pod("cattle-fleet-system", "fleet-agent", "CrashLoopBackOff", true)},
+			reason:  "pods in CrashLoopBackOff: cattle-fleet-system/fleet-agent",
+		},
+		{
+			name:    "pods crash looping from the start are the baseline",
+			trigger: Trigger{Type: TypePodCrashLoop},
+			first:   []runtime.Object{pod("cattle-system", "rancher-1", "CrashLoopBackOff", false)},
+			second:  []runtime.Object{pod("cattle-system", "rancher-1", "CrashLoopBackOff", false)},
+		},
+		{
+			name:    "pods of other namespaces are ignored",
+			trigger: Trigger{Type: TypePodCrashLoop},
+			first:   []runtime.Object{pod("kube-system", "coredns", "", false)},
+			second:  []runtime.Object{pod("kube-system", "coredns", "CrashLoopBackOff", false)},
+		},
+		{
+			name:    "pods waiting for another reason are ignored",
+			trigger: Trigger{Type: TypePodCrashLoop},
+			first:   []runtime.Object{pod("cattle-system", "rancher-1", "", false)},
+			second:  []runtime.Object{pod("cattle-system", "rancher-1", "ImagePullBackOff", false)},
+		},
+		{
+			name:    "node turns NotReady",
+			trigger: Trigger{Type: TypeNodeNotReady},
+			first:   []runtime.Object{node("server-1", v1.ConditionTrue), node("agent-1", v1.ConditionTrue)},
+			second:  []runtime.Object{node("server-1", v1.ConditionTrue), node("agent-1", v1.ConditionFalse)},
+			reason:  "nodes NotReady: agent-1",
+		},
+		{
+			name:    "node stops reporting",
+			trigger: Trigger{Type: TypeNodeNotReady},
+			first:   []runtime.Object{node("server-1", v1.ConditionTrue), node("agent-1", v1.ConditionTrue)},
+			second:  []runtime.Object{node("server-1", v1.ConditionUnknown), node("agent-1", v1.ConditionFalse)},
+			reason:  "nodes NotReady: agent-1, server-1",
+		},
+		{
+			name:    "node recovers",
+			trigger: Trigger{Type: TypeNodeNotReady},
+			first:   []runtime.Object{node("agent-1", v1.ConditionFalse)},
+			second:  []runtime.Object{node("agent-1", v1.ConditionTrue)},
+		},
+		{
+			name:     "cluster disconnects",
+			trigger:  Trigger{Type: TypeClusterDisconnected},
+			clusters: [2]map[string][]kubernetes.RancherClusterCondition{{"c-1": connected("True"), "c-2": connected("True")}, {"c-1": connected("True"), "c-2": connected("False")}},
+			reason:   "clusters disconnected: c-2",
+		},
+		{
+			name:     "cluster connection turns Unknown",
+			trigger:  Trigger{Type: TypeClusterDisconnected},
+			clusters: [2]map[string][]kubernetes.RancherClusterCondition{{"c-1": connected("True")}, {"c-1": connected("Unknown")}},
+			reason:   "clusters disconnected: c-1",
+		},
+		{
+			name:     "cluster reconnects",
+			trigger:  Trigger{Type: TypeClusterDisconnected},
+			clusters: [2]map[string][]kubernetes.RancherClusterCondition{{"c-1": connected("False")}, {"c-1": connected("True")}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.trigger.Name = "test"
+			w, starter := watch(t, test.trigger)
+			for i, objects := range [][]runtime.Object{test.first, test.second} {
+				clusters := test.clusters[i]
+				w.poll(fake.NewSimpleClientset(objects...), func() (map[string][]kubernetes.RancherClusterCondition, error) {
+					return clusters, nil
+				})
+			}
+			state := w.States()[0]
+			if state.LastReason != test.reason {
+				t.Errorf("fired for %q, want %q", state.LastReason, test.reason)
+			}
+			want := 0
+			if test.reason != "" {
+				want = 1
+			}
+			if starter.started() != want {
+				t.Errorf("%d collections started, want %d", starter.started(), want)
+			}
+		})
+	}
+}
+
+func TestPollFailedCheck(t *testing.T) {
+	w, starter := watch(t, Trigger{Name: "clusters", Type: TypeClusterDisconnected})
+	responses := []map[string][]kubernetes.RancherClusterCondition{{"c-1": connected("True")}, nil, {"c-1": connected("False")}}
+	for i, clusters := range responses {
+		var err error
+		if clusters == nil {
+			err = errors.New("the server is currently unable to handle the request")
+		}
+		w.poll(fake.NewSimpleClientset(), func() (map[string][]kubernetes.RancherClusterCondition, error) {
+			return clusters, err
+		})
+		if i == 1 && starter.started() != 0 {
+			t.Fatal("a failed check fired")
+		}
+	}
+	if state := w.States()[0]; state.LastReason != "clusters disconnected: c-1" {
+		t.Errorf("fired for %q after a failed check", state.LastReason)
+	}
+}
+
+func TestAlert(t *testing.T) {
+	critical := Trigger{Name: "critical", Type: TypeAlert, Labels: map[string]string{"severity": "critical"}}
+	etcd := Trigger{Name: "etcd", Type: TypeAlert, Labels: map[string]string{"severity": "critical", "job": "etcd"}}
+	tests := []struct {
+		name   string
+		alerts []Alert
+		fired  map[string]string
+	}{
+		{
+			name:   "matching alert fires",
+			alerts: []Alert{{Status: "firing", Labels: map[string]string{"alertname": "RancherDown", "severity": "critical"}}},
+			fired:  map[string]string{"critical": "alert RancherDown firing"},
+		},
+		{
+			name: "every trigger whose labels match fires",
+			alerts: []Alert{
+				{Status: "firing", Labels: map[string]string{"alertname": "EtcdNoLeader", "severity": "critical", "job": "etcd"}},
+				{Status: "firing", Labels: map[string]string{"alertname": "RancherDown", "severity": "critical"}},
+			},
+			fired: map[string]string{"critical": "alert EtcdNoLeader, RancherDown firing", "etcd": "alert EtcdNoLeader firing"},
+		},
+		{
+			name:   "resolved alerts don't fire",
+			alerts: []Alert{{Status: "resolved", Labels: map[string]string{"alertname": "RancherDown", "severity": "critical"}}},
+		},
+		{
+			name:   "alerts missing a label don't fire",
+			alerts: []Alert{{Status: "firing", Labels: map[string]string{"alertname": "EtcdHighCommitDuration", "severity": "warning", "job": "etcd"}}},
+		},
+		{
+			name: "no alerts",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w, _ := watch(t, critical, etcd, Trigger{Name: "nodes", Type: TypeNodeNotReady})
+			started := w.Alert(Notification{Status: "firing", Alerts: test.alerts})
+			if len(started) != len(test.fired) {
+				t.Errorf("%d collections started, want %d", len(started), len(test.fired))
+			}
+			for _, state := range w.States() {
+				if state.LastReason != test.fired[state.Name] {
+					t.Errorf("trigger %s fired for %q, want %q", state.Name, state.LastReason, test.fired[state.Name])
+				}
+			}
+		})
+	}
+}